@@ -0,0 +1,235 @@
+package gotcl
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+)
+
+// Pos is a position in a script. Line counts from 1.
+type Pos struct {
+	File      string
+	Line, Col int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
+}
+
+func toPos(l loc) Pos { return Pos{l.file, l.line + 1, l.col} }
+
+// TokenKind says what kind of word, or part of a word, a Token is.
+type TokenKind int
+
+const (
+	LiteralToken    TokenKind = iota // a bare word, with escapes applied
+	BlockToken                       // {...}, whose Text is the unparsed contents
+	SubcommandToken                  // [...]
+	ExpandToken                      // {*} followed by the word in Parts
+	StringToken                      // "...", made of the tokens in Parts
+	VarToken                         // $name or $name(index)
+	RawToken                         // plain text inside a quoted string
+	CommentToken                     // a comment, the only word of its Command
+)
+
+var tokenKindNames = [...]string{"literal", "block", "subcommand", "expand", "string", "var", "raw", "comment"}
+
+func (k TokenKind) String() string {
+	if k < 0 || int(k) >= len(tokenKindNames) {
+		return fmt.Sprintf("TokenKind(%d)", int(k))
+	}
+	return tokenKindNames[k]
+}
+
+// Token is a word of a parsed command, or a part of one.
+type Token struct {
+	Kind TokenKind
+	Pos  Pos // zero for RawToken
+	// Text is the text of a literal, block, raw or comment token, or
+	// the name of a variable, with a leading :: if it's global.
+	Text  string
+	Cmd   *Command // the command of a SubcommandToken
+	Parts []Token  // the parts of a StringToken, or the word an ExpandToken expands
+	Index *Token   // the array index of a VarToken, if it has one
+}
+
+// Command is a parsed command.
+type Command struct {
+	Pos   Pos
+	Words []Token
+}
+
+// ParseScript parses src, attributing positions to filename. The
+// result is a copy of the parse tree the interpreter uses, so it may
+// be kept and modified freely. Comments are included, each as a
+// Command with a single CommentToken.
+func ParseScript(src, filename string) ([]Command, error) {
+	cmds, e := parseTree(bufio.NewReader(strings.NewReader(src)), loc{filename, 0, 0})
+	if e != nil {
+		return nil, e
+	}
+	return exportCommands(cmds), nil
+}
+
+func exportCommands(cmds []command) []Command {
+	res := make([]Command, len(cmds))
+	for ind, c := range cmds {
+		res[ind] = exportCommand(c)
+	}
+	return res
+}
+
+func exportCommand(c command) Command {
+	words := make([]Token, len(c.words))
+	for ind, w := range c.words {
+		words[ind] = exportTok(w)
+	}
+	return Command{toPos(c.loc), words}
+}
+
+func exportTok(t tclTok) Token {
+	switch t := t.(type) {
+	case *tliteral:
+		return Token{Kind: LiteralToken, Pos: toPos(t.loc), Text: t.strval}
+	case *comment:
+		return Token{Kind: CommentToken, Pos: toPos(t.loc), Text: t.text}
+	case *block:
+		return Token{Kind: BlockToken, Pos: toPos(t.loc), Text: t.strval}
+	case *subcommand:
+		c := exportCommand(t.cmd)
+		return Token{Kind: SubcommandToken, Pos: toPos(t.loc), Cmd: &c}
+	case *expandTok:
+		return Token{Kind: ExpandToken, Pos: toPos(t.loc), Parts: []Token{exportTok(t.subject)}}
+	case strlit:
+		parts := make([]Token, len(t.toks))
+		for ind, lt := range t.toks {
+			switch lt.kind {
+			case kRaw:
+				parts[ind] = Token{Kind: RawToken, Text: lt.value}
+			case kVar:
+				parts[ind] = exportTok(*lt.varref)
+			case kSubcmd:
+				parts[ind] = exportTok(lt.subcmd)
+			}
+		}
+		return Token{Kind: StringToken, Pos: toPos(t.loc), Parts: parts}
+	case varRef:
+		tok := Token{Kind: VarToken, Pos: toPos(t.loc), Text: t.name}
+		if t.is_global {
+			tok.Text = "::" + t.name
+		}
+		if t.arrind != nil {
+			ind := exportTok(t.arrind)
+			tok.Index = &ind
+		}
+		return tok
+	}
+	panic(fmt.Sprintf("unknown token type %T", t))
+}
+
+// Walk visits the words of cmds depth first, calling visit on each
+// token before the tokens inside it. The tokens inside one are skipped
+// if visit returns false for it.
+func Walk(cmds []Command, visit func(t *Token) bool) {
+	for ind := range cmds {
+		walkWords(cmds[ind].Words, visit)
+	}
+}
+
+func walkWords(toks []Token, visit func(t *Token) bool) {
+	for ind := range toks {
+		walkTok(&toks[ind], visit)
+	}
+}
+
+func walkTok(t *Token, visit func(t *Token) bool) {
+	if !visit(t) {
+		return
+	}
+	if t.Cmd != nil {
+		walkWords(t.Cmd.Words, visit)
+	}
+	walkWords(t.Parts, visit)
+	if t.Index != nil {
+		walkTok(t.Index, visit)
+	}
+}
+
+// String returns t in Tcl syntax, so that parsing it gives back an
+// equivalent token.
+func (t Token) String() string {
+	switch t.Kind {
+	case LiteralToken:
+		if t.Text == "" {
+			return "{}"
+		}
+		return escapeText(t.Text, "\\$[]\"{};# ")
+	case BlockToken:
+		return "{" + t.Text + "}"
+	case SubcommandToken:
+		return "[" + t.Cmd.String() + "]"
+	case ExpandToken:
+		return "{*}" + t.Parts[0].String()
+	case StringToken:
+		var b strings.Builder
+		b.WriteByte('"')
+		for _, p := range t.Parts {
+			b.WriteString(p.String())
+		}
+		b.WriteByte('"')
+		return b.String()
+	case VarToken:
+		name := strings.TrimPrefix(t.Text, "::")
+		if name == "" || strings.IndexFunc(name, func(r rune) bool { return !isvarword(r) }) != -1 {
+			return "${" + t.Text + "}"
+		}
+		if t.Index != nil {
+			return "$" + t.Text + "(" + t.Index.String() + ")"
+		}
+		return "$" + t.Text
+	case RawToken:
+		return escapeText(t.Text, "\\$[]\"")
+	}
+	return t.Text
+}
+
+// escapeText backslash-escapes the characters of s that are in special,
+// and writes newlines, tabs and carriage returns as \n, \t and \r.
+func escapeText(s, special string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case strings.ContainsRune(special, r):
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// String returns c in Tcl syntax, with its words separated by spaces.
+func (c Command) String() string {
+	words := make([]string, len(c.Words))
+	for ind, w := range c.Words {
+		words[ind] = w.String()
+	}
+	return strings.Join(words, " ")
+}
+
+// ScriptString returns cmds as a script, one command per line.
+func ScriptString(cmds []Command) string {
+	var b strings.Builder
+	for _, c := range cmds {
+		b.WriteString(c.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
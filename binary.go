@@ -0,0 +1,364 @@
+package gotcl
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// binary encode base64 ?-maxlen length? ?-wrapchar char? data
+// binary encode hex data
+// binary decode base64 string
+// binary decode hex string
+//
+// Strings hold raw bytes, so data is encoded byte for byte and a
+// decoded result may not be valid UTF-8. Decoding ignores whitespace,
+// so wrapped base64 reads back unchanged.
+
+func binaryEncodeBase64(i *Interp, args []*TclObj) TclStatus {
+	maxlen, wrap := 0, "\n"
+	for len(args) > 1 {
+		if len(args) < 3 {
+			return i.FailStr("wrong # args: should be \"binary encode base64 ?-maxlen len? ?-wrapchar char? data\"")
+		}
+		switch opt := args[0].AsString(); opt {
+		case "-maxlen":
+			n, e := args[1].AsInt()
+			if e != nil {
+				return i.Fail(e)
+			}
+			if n < 0 {
+				return i.FailStr("line length out of range")
+			}
+			maxlen = n
+		case "-wrapchar":
+			wrap = args[1].AsString()
+		default:
+			return i.FailStr("bad option \"" + opt + "\": must be -maxlen or -wrapchar")
+		}
+		args = args[2:]
+	}
+	if len(args) != 1 {
+		return i.FailStr("wrong # args: should be \"binary encode base64 ?-maxlen len? ?-wrapchar char? data\"")
+	}
+	enc := base64.StdEncoding.EncodeToString([]byte(args[0].AsString()))
+	if maxlen == 0 || len(enc) <= maxlen {
+		return i.Return(FromStr(enc))
+	}
+	var b strings.Builder
+	for len(enc) > maxlen {
+		b.WriteString(enc[:maxlen])
+		b.WriteString(wrap)
+		enc = enc[maxlen:]
+	}
+	b.WriteString(enc)
+	return i.Return(FromStr(b.String()))
+}
+
+func binaryEncodeHex(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 1 {
+		return i.FailStr("wrong # args: should be \"binary encode hex data\"")
+	}
+	return i.Return(FromStr(hex.EncodeToString([]byte(args[0].AsString()))))
+}
+
+func stripSpace(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+func binaryDecodeBase64(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 1 {
+		return i.FailStr("wrong # args: should be \"binary decode base64 string\"")
+	}
+	s := strings.TrimRight(stripSpace(args[0].AsString()), "=")
+	b, e := base64.RawStdEncoding.DecodeString(s)
+	if e != nil {
+		return i.Fail(errors.New("invalid base64 data: " + e.Error()))
+	}
+	return i.Return(FromStr(string(b)))
+}
+
+func binaryDecodeHex(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 1 {
+		return i.FailStr("wrong # args: should be \"binary decode hex string\"")
+	}
+	b, e := hex.DecodeString(stripSpace(args[0].AsString()))
+	if e != nil {
+		return i.Fail(errors.New("invalid hex data: " + e.Error()))
+	}
+	return i.Return(FromStr(string(b)))
+}
+
+// binary scan ?-cursor varName? string formatString ?varName ...?
+//
+// Picks fields out of string as formatString describes, storing each
+// in the next varName, and returns the number of variables set. Each
+// field is a letter, optionally followed by u for unsigned, then a
+// count or *:
+//
+//	a A     count bytes, or all that are left with *; A drops trailing
+//	        spaces and nulls
+//	H h     count hex digits, high or low nibble of each byte first
+//	c       8-bit integers
+//	s S     16-bit integers, little or big endian
+//	i I     32-bit integers, little or big endian
+//	w W     64-bit integers, little or big endian
+//	x       skip count bytes forward, or to the end with *
+//	X       move count bytes back, or to the start with *
+//	@       move to byte count, or to the end with *
+//
+// An integer field with no count stores a single value, and with a
+// count, a list of that many, or as many as are left with *. Moves are
+// clamped to the ends of string, but a field that needs more bytes
+// than are left ends the scan, leaving it and those after it unset.
+//
+// Only a prefix of string need be described, and -cursor stores the
+// position where the scan ended in varName, so a loop can take one
+// record at a time:
+//
+//	while {[binary scan -cursor n $data "S a4" len tag] == 2} {
+//	    set data [string range $data $n end]
+//	    ...
+//	}
+
+// A binaryField is one directive of a binary scan format.
+type binaryField struct {
+	code     byte
+	unsigned bool
+	count    int // -1 for *, 0 if none was given
+	hasCount bool
+}
+
+var binaryIntSizes = map[byte]int{'c': 1, 's': 2, 'S': 2, 'i': 4, 'I': 4, 'w': 8, 'W': 8}
+
+func parseBinaryFormat(f string) ([]binaryField, error) {
+	var res []binaryField
+	for k := 0; k < len(f); {
+		if f[k] == ' ' || f[k] == '\t' || f[k] == '\n' {
+			k++
+			continue
+		}
+		fd := binaryField{code: f[k]}
+		if _, ok := binaryIntSizes[fd.code]; !ok && !strings.ContainsRune("aAHhxX@", rune(fd.code)) {
+			return nil, errors.New("bad field specifier \"" + string(f[k]) + "\"")
+		}
+		k++
+		if k < len(f) && f[k] == 'u' {
+			fd.unsigned = true
+			k++
+		}
+		if k < len(f) && f[k] == '*' {
+			fd.count, fd.hasCount = -1, true
+			k++
+		} else {
+			start := k
+			for k < len(f) && f[k] >= '0' && f[k] <= '9' {
+				k++
+			}
+			if k > start {
+				n, e := strconv.Atoi(f[start:k])
+				if e != nil {
+					return nil, e
+				}
+				fd.count, fd.hasCount = n, true
+			}
+		}
+		if fd.code == '@' && !fd.hasCount {
+			return nil, errors.New("missing count for \"@\" field specifier")
+		}
+		res = append(res, fd)
+	}
+	return res, nil
+}
+
+// binaryInt reads the integer of the given size at the start of b.
+func binaryInt(b []byte, code byte, unsigned bool) int {
+	switch code {
+	case 'c':
+		if unsigned {
+			return int(b[0])
+		}
+		return int(int8(b[0]))
+	case 's':
+		return sized16(binary.LittleEndian.Uint16(b), unsigned)
+	case 'S':
+		return sized16(binary.BigEndian.Uint16(b), unsigned)
+	case 'i':
+		return sized32(binary.LittleEndian.Uint32(b), unsigned)
+	case 'I':
+		return sized32(binary.BigEndian.Uint32(b), unsigned)
+	case 'w':
+		return int(binary.LittleEndian.Uint64(b))
+	}
+	return int(binary.BigEndian.Uint64(b))
+}
+
+func sized16(v uint16, unsigned bool) int {
+	if unsigned {
+		return int(v)
+	}
+	return int(int16(v))
+}
+
+func sized32(v uint32, unsigned bool) int {
+	if unsigned {
+		return int(v)
+	}
+	return int(int32(v))
+}
+
+func binaryScan(i *Interp, args []*TclObj) TclStatus {
+	var cursor *TclObj
+	if len(args) > 3 && args[0].AsString() == "-cursor" {
+		cursor, args = args[1], args[2:]
+	}
+	if len(args) < 2 {
+		return i.FailStr("wrong # args: should be \"binary scan ?-cursor varName? value formatString ?varName ...?\"")
+	}
+	data := []byte(args[0].AsString())
+	fields, e := parseBinaryFormat(args[1].AsString())
+	if e != nil {
+		return i.Fail(e)
+	}
+	vars := args[2:]
+	needed := 0
+	for _, fd := range fields {
+		if !strings.ContainsRune("xX@", rune(fd.code)) {
+			needed++
+		}
+	}
+	if needed > len(vars) {
+		return i.FailStr("not enough arguments for all format specifiers")
+	}
+	if needed < len(vars) {
+		return i.FailStr("variable is not assigned by any conversion specifiers")
+	}
+	pos, set := 0, 0
+scan:
+	for _, fd := range fields {
+		left := len(data) - pos
+		var val *TclObj
+		switch fd.code {
+		case 'x':
+			n := fd.count
+			if !fd.hasCount {
+				n = 1
+			}
+			if n < 0 || n > left {
+				pos = len(data)
+			} else {
+				pos += n
+			}
+			continue
+		case 'X':
+			n := fd.count
+			if !fd.hasCount {
+				n = 1
+			}
+			if n < 0 || n > pos {
+				pos = 0
+			} else {
+				pos -= n
+			}
+			continue
+		case '@':
+			if fd.count < 0 || fd.count > len(data) {
+				pos = len(data)
+			} else {
+				pos = fd.count
+			}
+			continue
+		case 'a', 'A':
+			n := fd.count
+			if !fd.hasCount {
+				n = 1
+			} else if n < 0 {
+				n = left
+			}
+			if n > left {
+				break scan
+			}
+			s := string(data[pos : pos+n])
+			if fd.code == 'A' {
+				s = strings.TrimRight(s, " \x00")
+			}
+			val, pos = FromStr(s), pos+n
+		case 'H', 'h':
+			n := fd.count
+			if !fd.hasCount {
+				n = 1
+			} else if n < 0 {
+				n = 2 * left
+			}
+			nbytes := n/2 + n%2
+			if nbytes > left {
+				break scan
+			}
+			digits := hex.EncodeToString(data[pos : pos+nbytes])
+			if fd.code == 'h' {
+				swapped := []byte(digits)
+				for k := 0; k+1 < len(swapped); k += 2 {
+					swapped[k], swapped[k+1] = swapped[k+1], swapped[k]
+				}
+				digits = string(swapped)
+			}
+			val, pos = FromStr(digits[:n]), pos+nbytes
+		default:
+			size := binaryIntSizes[fd.code]
+			if !fd.hasCount {
+				if size > left {
+					break scan
+				}
+				val, pos = FromInt(binaryInt(data[pos:], fd.code, fd.unsigned)), pos+size
+				break
+			}
+			n := fd.count
+			if n < 0 {
+				n = left / size
+			}
+			if n > left/size {
+				break scan
+			}
+			list := make([]*TclObj, n)
+			for k := range list {
+				list[k], pos = FromInt(binaryInt(data[pos:], fd.code, fd.unsigned)), pos+size
+			}
+			val = fromList(list)
+		}
+		if rc := i.setVar(vars[set].asVarRef(), val); rc != kTclOK {
+			return rc
+		}
+		set++
+	}
+	if cursor != nil {
+		if rc := i.setVar(cursor.asVarRef(), FromInt(pos)); rc != kTclOK {
+			return rc
+		}
+	}
+	return i.Return(FromInt(set))
+}
+
+var binaryEn = ensembleSpec{
+	"encode": ensembleSpec{
+		"base64": binaryEncodeBase64,
+		"hex":    binaryEncodeHex,
+	}.makeCmd(),
+	"decode": ensembleSpec{
+		"base64": binaryDecodeBase64,
+		"hex":    binaryDecodeHex,
+	}.makeCmd(),
+	"scan": binaryScan,
+}
+
+func init() {
+	RegisterDefaultCmd("binary", binaryEn.makeCmd())
+}
@@ -90,13 +90,13 @@ func tclSendChan(i *Interp, args []*TclObj) TclStatus {
 }
 
 func tclGo(i *Interp, args []*TclObj) TclStatus {
-	ni := new(Interp)
-	ni.cmds = i.cmds
+	ni := NewInterpFrom(i)
 	ni.chans = i.chans
-	ni.frame = newstackframe(nil)
+	ni.maxDepth = i.maxDepth
+	ni.ctx = i.ctx
 	go func() {
 		tclEval(ni, args)
-		if ni.err != nil {
+		if ni.err != nil && !isExit(ni.err) {
 			fmt.Println(ni.err.Error())
 		}
 	}()
@@ -3,13 +3,16 @@ package gotcl
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
-	"unicode/utf8"
 )
 
 func tclSet(i *Interp, args []*TclObj) TclStatus {
@@ -27,12 +30,52 @@ func tclSet(i *Interp, args []*TclObj) TclStatus {
 	return i.Return(v)
 }
 
+// const varName value
+//
+// Creates a variable that can't be set or unset afterwards. It's not
+// an error to declare the same constant again, but its value doesn't
+// change.
+func tclConst(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 2 {
+		return i.FailStr("wrong # args: should be \"const varName value\"")
+	}
+	vr := args[0].asVarRef()
+	if vr.arrind != nil {
+		return i.FailStr("can't create constant \"" + args[0].AsString() + "\": variable is an array element")
+	}
+	m := i.getVarMap(vr.is_global)
+	if old, ok := m[vr.name]; ok {
+		if old.immutable {
+			return i.Return(kNil)
+		}
+		return i.FailStr("can't create constant \"" + args[0].AsString() + "\": variable already exists")
+	}
+	m[vr.name] = &varEntry{obj: args[1], immutable: true}
+	return i.Return(kNil)
+}
+
+// unset ?-nocomplain? ?--? ?name ...?
+//
+// Unsets each variable or array element named, in order, stopping at
+// the first error. Naming one that doesn't exist is an error, unless
+// -nocomplain is given. -- ends the options, so a name that starts
+// with a dash can follow.
 func tclUnset(i *Interp, args []*TclObj) TclStatus {
-	if len(args) == 0 {
-		return i.FailStr("wrong # args")
+	complain := true
+	if len(args) > 0 && args[0].AsString() == "-nocomplain" {
+		complain = false
+		args = args[1:]
+	}
+	if len(args) > 0 && args[0].AsString() == "--" {
+		args = args[1:]
+	}
+	for _, a := range args {
+		vr := a.asVarRef()
+		if rc := i.unsetVar(i.getVarMap(vr.is_global), vr, complain); rc != kTclOK {
+			return rc
+		}
 	}
-	i.setVar(args[0].asVarRef(), nil)
-	return kTclOK
+	return i.Return(kNil)
 }
 
 func tclUplevel(i *Interp, args []*TclObj) TclStatus {
@@ -59,20 +102,396 @@ var getUniqueNum = func() func() int {
 	}
 }()
 
+// A tclChan is an open I/O channel.
+type tclChan struct {
+	r          *bufio.Reader // nil if not open for reading
+	w          io.Writer     // nil if not open for writing
+	out        *bufio.Writer // buffers w unless buffering is "none"
+	buffering  string
+	closer     io.Closer // nil for the standard channels
+	eof        bool
+	pids       []int            // the processes of a command pipeline
+	transforms []*chanTransform // pushed by chan push, the last on top
+	// transforming counts the transform commands running, which
+	// can't close the channel or pop its transforms.
+	transforming int
+	// afterCR is set when a line ended in "\r" with nothing buffered
+	// after it, so that a "\n" starting the next line is skipped.
+	afterCR bool
+}
+
+func newChan(r io.Reader, w io.Writer, c io.Closer, buffering string) *tclChan {
+	ch := &tclChan{w: w, closer: c}
+	if br, ok := r.(*bufio.Reader); ok {
+		ch.r = br
+	} else if r != nil {
+		ch.r = bufio.NewReader(r)
+	}
+	ch.setBuffering(buffering)
+	return ch
+}
+
+func (ch *tclChan) setBuffering(mode string) error {
+	switch mode {
+	case "none", "line", "full":
+	default:
+		return errors.New("bad value for -buffering: must be one of full, line, or none")
+	}
+	if e := ch.flush(); e != nil {
+		return e
+	}
+	ch.buffering = mode
+	ch.out = nil
+	if ch.w != nil && mode != "none" {
+		ch.out = bufio.NewWriter(ch.w)
+	}
+	return nil
+}
+
+func (ch *tclChan) write(s string) error {
+	if ch.w == nil {
+		return errors.New("channel wasn't opened for writing")
+	}
+	s, e := ch.transformDown(s, len(ch.transforms))
+	if e != nil {
+		return e
+	}
+	return ch.writeRaw(s)
+}
+
+// writeRaw writes s to the channel below any transforms.
+func (ch *tclChan) writeRaw(s string) error {
+	if ch.out == nil {
+		_, e := io.WriteString(ch.w, s)
+		return e
+	}
+	if _, e := ch.out.WriteString(s); e != nil {
+		return e
+	}
+	if ch.buffering == "line" && strings.IndexRune(s, '\n') != -1 {
+		return ch.out.Flush()
+	}
+	return nil
+}
+
+func (ch *tclChan) flush() error {
+	for k := len(ch.transforms) - 1; k >= 0; k-- {
+		if e := ch.flushTransform(k); e != nil {
+			return e
+		}
+	}
+	if ch.out != nil {
+		return ch.out.Flush()
+	}
+	return nil
+}
+
+func (ch *tclChan) close() error {
+	var e error
+	for len(ch.transforms) > 0 {
+		if pe := ch.pop(); e == nil {
+			e = pe
+		}
+	}
+	if fe := ch.flush(); e == nil {
+		e = fe
+	}
+	if ch.closer != nil {
+		if ce := ch.closer.Close(); e == nil {
+			e = ce
+		}
+	}
+	return e
+}
+
+func (ch *tclChan) reader() (*bufio.Reader, error) {
+	if ch.r == nil {
+		return nil, errors.New("channel wasn't opened for reading")
+	}
+	return ch.r, nil
+}
+
+// readLine reads the next line without its terminator, which may be
+// "\n", "\r\n" or a bare "\r". eof is true if the channel ended before
+// anything was read. After a "\r" it only looks for a "\n" that's
+// already buffered, since waiting for more input could block an
+// interactive channel; the "\n" is skipped by the next call instead.
+func (ch *tclChan) readLine() (line string, eof bool, err error) {
+	in, err := ch.reader()
+	if err != nil {
+		return "", false, err
+	}
+	var buf bytes.Buffer
+	afterCR := ch.afterCR
+	ch.afterCR = false
+	for {
+		r, _, e := in.ReadRune()
+		if e == io.EOF {
+			ch.eof = true
+			return buf.String(), buf.Len() == 0, nil
+		} else if e != nil {
+			return "", false, e
+		}
+		switch r {
+		case '\n':
+			if afterCR {
+				afterCR = false
+				continue
+			}
+			return buf.String(), false, nil
+		case '\r':
+			if in.Buffered() == 0 {
+				ch.afterCR = true
+			} else if next, e := in.Peek(1); e == nil && next[0] == '\n' {
+				in.ReadByte()
+			}
+			return buf.String(), false, nil
+		}
+		afterCR = false
+		buf.WriteRune(r)
+	}
+}
+
+func (i *Interp) getChan(name string) (*tclChan, error) {
+	ch, ok := i.chans[name]
+	if !ok {
+		return nil, errors.New("can not find channel named \"" + name + "\"")
+	}
+	return ch, nil
+}
+
+var openModes = map[string]int{
+	"r":  os.O_RDONLY,
+	"r+": os.O_RDWR,
+	"w":  os.O_WRONLY | os.O_CREATE | os.O_TRUNC,
+	"w+": os.O_RDWR | os.O_CREATE | os.O_TRUNC,
+	"a":  os.O_WRONLY | os.O_CREATE | os.O_APPEND,
+	"a+": os.O_RDWR | os.O_CREATE | os.O_APPEND,
+}
+
 func tclOpen(i *Interp, args []*TclObj) TclStatus {
-	if len(args) != 1 {
-		return i.FailStr("wrong # args")
+	if len(args) != 1 && len(args) != 2 {
+		return i.FailStr("wrong # args: should be \"open fileName ?access?\"")
 	}
 	fname := args[0].AsString()
-	ff, err := os.Open(fname)
+	mode := "r"
+	if len(args) == 2 {
+		mode = args[1].AsString()
+	}
+	if strings.HasPrefix(fname, "|") {
+		return openPipe(i, fname[1:], mode)
+	}
+	flags, ok := openModes[mode]
+	if !ok {
+		return i.FailStr("illegal access mode \"" + mode + "\"")
+	}
+	ff, err := os.OpenFile(fname, flags, 0666)
 	if err != nil {
-		return i.Fail(err)
+		return i.Fail(posixError(err))
+	}
+	var r io.Reader
+	var w io.Writer
+	if mode == "r" || strings.HasSuffix(mode, "+") {
+		r = ff
+	}
+	if mode != "r" {
+		w = ff
 	}
 	channame := fmt.Sprintf("file%d", getUniqueNum())
-	i.chans[channame] = bufio.NewReader(ff)
+	i.chans[channame] = newChan(r, w, ff, "full")
 	return i.Return(FromStrLoc(channame, i.loc))
 }
 
+func tclClose(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 1 {
+		return i.FailStr("wrong # args: should be \"close channelId\"")
+	}
+	name := args[0].AsString()
+	ch, e := i.getChan(name)
+	if e != nil {
+		return i.Fail(e)
+	}
+	if ch.transforming > 0 {
+		return i.FailStr("can't close channel \"" + name + "\" while its transforms are running")
+	}
+	delete(i.chans, name)
+	if e := ch.close(); e != nil {
+		return i.Fail(e)
+	}
+	return i.Return(kNil)
+}
+
+func tclEof(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 1 {
+		return i.FailStr("wrong # args: should be \"eof channelId\"")
+	}
+	ch, e := i.getChan(args[0].AsString())
+	if e != nil {
+		return i.Fail(e)
+	}
+	return i.Return(FromBool(ch.eof))
+}
+
+// pid ?channelId?
+//
+// With no channel, returns the id of the current process. Otherwise
+// returns the ids of the processes in the channel's pipeline, which
+// is empty for other kinds of channel.
+func tclPid(i *Interp, args []*TclObj) TclStatus {
+	if len(args) > 1 {
+		return i.FailStr("wrong # args: should be \"pid ?channelId?\"")
+	}
+	if len(args) == 0 {
+		return i.Return(FromInt(os.Getpid()))
+	}
+	ch, e := i.getChan(args[0].AsString())
+	if e != nil {
+		return i.Fail(e)
+	}
+	pids := make([]*TclObj, len(ch.pids))
+	for ind, p := range ch.pids {
+		pids[ind] = FromInt(p)
+	}
+	return i.Return(fromList(pids))
+}
+
+// read ?-nonewline? channelId
+// read channelId numChars
+//
+// With no count, reads to the end of the channel. -nonewline drops a
+// single trailing newline from what was read.
+func tclRead(i *Interp, args []*TclObj) TclStatus {
+	nonewline := len(args) == 2 && args[0].AsString() == "-nonewline"
+	if nonewline {
+		args = args[1:]
+	}
+	if len(args) != 1 && len(args) != 2 {
+		return i.FailStr("wrong # args: should be \"read channelId ?numChars?\" or \"read ?-nonewline? channelId\"")
+	}
+	ch, e := i.getChan(args[0].AsString())
+	if e != nil {
+		return i.Fail(e)
+	}
+	in, e := ch.reader()
+	if e != nil {
+		return i.Fail(e)
+	}
+	if len(args) == 1 {
+		data, e := ioutil.ReadAll(in)
+		if e != nil {
+			return i.Fail(e)
+		}
+		ch.eof = true
+		return i.Return(FromStrLoc(trimNewline(string(data), nonewline), i.loc))
+	}
+	n, e := args[1].AsInt()
+	if e != nil {
+		return i.Fail(e)
+	}
+	var buf bytes.Buffer
+	for ; n > 0; n-- {
+		r, _, e := in.ReadRune()
+		if e == io.EOF {
+			ch.eof = true
+			break
+		} else if e != nil {
+			return i.Fail(e)
+		}
+		buf.WriteRune(r)
+	}
+	return i.Return(FromStrLoc(buf.String(), i.loc))
+}
+
+func trimNewline(s string, trim bool) string {
+	if trim && strings.HasSuffix(s, "\n") {
+		return s[:len(s)-1]
+	}
+	return s
+}
+
+// readfile ?-nonewline? fileName
+//
+// Returns the contents of a file, like opening it, reading it all and
+// closing it again.
+func tclReadfile(i *Interp, args []*TclObj) TclStatus {
+	nonewline := len(args) == 2 && args[0].AsString() == "-nonewline"
+	if nonewline {
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		return i.FailStr("wrong # args: should be \"readfile ?-nonewline? fileName\"")
+	}
+	data, e := ioutil.ReadFile(args[0].AsString())
+	if e != nil {
+		return i.Fail(posixError(e))
+	}
+	return i.Return(FromStrLoc(trimNewline(string(data), nonewline), i.loc))
+}
+
+// fconfigure channelId ?optionName? ?value optionName value ...?
+func tclFconfigure(i *Interp, args []*TclObj) TclStatus {
+	if len(args) == 0 {
+		return i.FailStr("wrong # args: should be \"fconfigure channelId ?optionName? ?value optionName value ...?\"")
+	}
+	ch, e := i.getChan(args[0].AsString())
+	if e != nil {
+		return i.Fail(e)
+	}
+	args = args[1:]
+	if len(args) == 0 {
+		return i.Return(FromList([]string{"-buffering", ch.buffering}))
+	}
+	if len(args) == 1 {
+		if args[0].AsString() != "-buffering" {
+			return i.FailStr("bad option \"" + args[0].AsString() + "\": should be -buffering")
+		}
+		return i.Return(FromStr(ch.buffering))
+	}
+	if len(args)%2 != 0 {
+		return i.FailStr("missing value for option \"" + args[len(args)-1].AsString() + "\"")
+	}
+	for ; len(args) > 0; args = args[2:] {
+		if args[0].AsString() != "-buffering" {
+			return i.FailStr("bad option \"" + args[0].AsString() + "\": should be -buffering")
+		}
+		if e := ch.setBuffering(args[1].AsString()); e != nil {
+			return i.Fail(e)
+		}
+	}
+	return i.Return(kNil)
+}
+
+// chan names ?pattern?
+// file channels ?pattern?
+//
+// Lists the open channels whose names match pattern, including stdin,
+// stdout and stderr.
+func chanNames(i *Interp, args []*TclObj) TclStatus {
+	names := make([]string, 0, len(i.chans))
+	for n := range i.chans {
+		names = append(names, n)
+	}
+	return i.matchNames(names, args)
+}
+
+var chanEn = ensembleSpec{
+	"close":     tclClose,
+	"configure": tclFconfigure,
+	"eof":       tclEof,
+	"flush":     tclFlush,
+	"foreach":   chanForeach,
+	"gets":      tclGets,
+	"names":     chanNames,
+	"pop":       chanPop,
+	"push":      chanPush,
+	"puts":      tclPuts,
+	"read":      tclRead,
+}
+
+var fileEn = ensembleSpec{
+	"channels": chanNames,
+}
+
 func tclUpvar(i *Interp, args []*TclObj) TclStatus {
 	if len(args) != 2 && len(args) != 3 {
 		return i.FailStr("wrong # args")
@@ -92,16 +511,15 @@ func tclUpvar(i *Interp, args []*TclObj) TclStatus {
 	return i.Return(kNil)
 }
 
+// incr varName ?increment?
+//
+// The increment may be negative, so "incr x -1" decrements. Counting
+// is common enough in loops that a plain scalar holding an integer is
+// updated in place, skipping the lookups and checks of setVar.
 func tclIncr(i *Interp, args []*TclObj) TclStatus {
 	if len(args) != 1 && len(args) != 2 {
 		return i.FailStr("wrong # args")
 	}
-	vn := args[0].asVarRef()
-	v, ve := i.getVar(vn)
-	if ve != nil {
-		return i.Fail(ve)
-	}
-
 	inc := 1
 	if len(args) == 2 {
 		incv, ie := args[1].AsInt()
@@ -110,6 +528,24 @@ func tclIncr(i *Interp, args []*TclObj) TclStatus {
 		}
 		inc = incv
 	}
+	vn := args[0].asVarRef()
+	if vn.arrind == nil {
+		e := resolveLink(i.getVarMap(vn.is_global)[vn.name])
+		if e != nil && e.obj != nil && e.arrdata == nil && e.onset == nil && e.reflect == nil && !e.immutable && len(e.traces) == 0 {
+			if iv, err := e.obj.AsInt(); err == nil {
+				e.obj = FromInt(iv + inc)
+				return i.Return(e.obj)
+			}
+		}
+	}
+	// As in Tcl 8.5, a missing variable counts as 0.
+	v, ve := i.getVarIfExists(vn)
+	if ve != nil {
+		return i.Fail(ve)
+	}
+	if v == nil {
+		v = FromInt(0)
+	}
 	iv, err := v.AsInt()
 	if err != nil {
 		return i.Fail(err)
@@ -128,6 +564,12 @@ func tclReturn(i *Interp, args []*TclObj) TclStatus {
 	return i.FailStr("wrong # args")
 }
 
+// tclBreakpoint does nothing itself. It marks a spot in a script
+// for a step hook to stop at.
+func tclBreakpoint(i *Interp, args []*TclObj) TclStatus {
+	return i.Return(kNil)
+}
+
 func tclBreak(i *Interp, args []*TclObj) TclStatus {
 	if len(args) != 0 {
 		return i.FailStr("wrong # args")
@@ -147,6 +589,9 @@ func tclCatch(i *Interp, args []*TclObj) TclStatus {
 		return i.FailStr("wrong # args to catch")
 	}
 	r := i.EvalObj(args[0])
+	if r == kTclErr && isExit(i.err) {
+		return r
+	}
 	if len(args) == 2 {
 		val := kNil
 		if r == kTclErr {
@@ -301,8 +746,18 @@ func tclForeach(i *Interp, args []*TclObj) TclStatus {
 }
 
 func asInts(a *TclObj, b *TclObj) (ai int, bi int, e error) {
+	if ai, e = a.AsInt(); e != nil {
+		return
+	}
 	bi, e = b.AsInt()
-	ai, e = a.AsInt()
+	return
+}
+
+func asFloats(a *TclObj, b *TclObj) (af float64, bf float64, e error) {
+	if af, e = a.AsFloat(); e != nil {
+		return
+	}
+	bf, e = b.AsFloat()
 	return
 }
 
@@ -310,6 +765,8 @@ func asInts(a *TclObj, b *TclObj) (ai int, bi int, e error) {
 // Panics on failure.
 func MakeCmd(fni interface{}) TclCmd {
 	switch fn := fni.(type) {
+	case TclCmd:
+		return fn
 	case func(*Interp, []*TclObj) TclStatus:
 		return fn
 	case func(*TclObj, *TclObj) bool:
@@ -347,6 +804,13 @@ func MakeCmd(fni interface{}) TclCmd {
 			fn(args[0].AsString())
 			return it.Return(kNil)
 		}
+	case func(*TclObj) int:
+		return func(it *Interp, args []*TclObj) TclStatus {
+			if len(args) != 1 {
+				return it.FailStr("wrong # args")
+			}
+			return it.Return(FromInt(fn(args[0])))
+		}
 	case func(string) int:
 		return func(it *Interp, args []*TclObj) TclStatus {
 			if len(args) != 1 {
@@ -429,70 +893,470 @@ func tclList(i *Interp, args []*TclObj) TclStatus {
 	return i.Return(fromList(args))
 }
 
-func tclLindex(i *Interp, args []*TclObj) TclStatus {
-	l, err := args[0].AsList()
-	if err != nil {
-		return i.Fail(err)
+// parseIndex interprets o as an index into a sequence of length n.
+// It accepts integer, integer+integer, integer-integer, end, end-N
+// and end+N. The result isn't checked against n; commands decide
+// for themselves how to treat an index out of range.
+func parseIndex(o *TclObj, n int) (int, error) {
+	if v, e := o.AsInt(); e == nil {
+		return v, nil
+	}
+	s := o.AsString()
+	base, rest := 0, s
+	if strings.HasPrefix(s, "end") {
+		base, rest = n-1, s[3:]
+		if rest == "" {
+			return base, nil
+		}
+	} else if ind := strings.LastIndexAny(s, "+-"); ind > 0 {
+		if b, e := strconv.Atoi(s[:ind]); e == nil {
+			base, rest = b, s[ind:]
+		}
 	}
-	ind, err := args[1].AsInt()
-	if err != nil {
-		return i.Fail(err)
+	if rest != s && len(rest) > 1 && (rest[0] == '+' || rest[0] == '-') && isDigits(rest[1:]) {
+		off, e := strconv.Atoi(rest[1:])
+		if e != nil {
+			off = maxInt // too many digits for an int
+		}
+		if rest[0] == '-' {
+			off = -off
+		}
+		return addIndex(base, off), nil
+	}
+	return 0, errors.New("bad index \"" + s + "\": must be integer?[+-]integer? or end?[+-]integer?")
+}
+
+// addIndex adds off to base, saturating at the limits of an int rather
+// than wrapping, so that an index far out of range stays out of range.
+func addIndex(base, off int) int {
+	sum := base + off
+	if off > 0 && sum < base {
+		return maxInt
 	}
-	if ind >= len(l) {
-		return i.FailStr("out of bounds")
+	if off < 0 && sum > base {
+		return -maxInt
 	}
-	return i.Return(l[ind])
+	return sum
 }
 
-func concat(args []*TclObj) *TclObj {
-	var result bytes.Buffer
-	for ind, x := range args {
-		if ind != 0 {
-			result.WriteString(" ")
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
 		}
-		result.WriteString(strings.TrimSpace(x.AsString()))
-	}
-	loc := loc{"<unknown>", 0, 0}
-	if len(args) > 0 {
-		loc = args[0].loc
 	}
-	return FromStrLoc(result.String(), loc)
+	return s != ""
 }
 
-func tclEval(i *Interp, args []*TclObj) TclStatus {
-	if len(args) == 0 {
-		return i.FailStr("eval: no arguments")
+// parseRange parses first and last for a sequence of length n and
+// clamps them to it. If the range is empty, lo > hi.
+func parseRange(first, last *TclObj, n int) (lo, hi int, err error) {
+	if lo, err = parseIndex(first, n); err != nil {
+		return
 	}
-	if len(args) == 1 {
-		return i.EvalObj(args[0])
+	if hi, err = parseIndex(last, n); err != nil {
+		return
 	}
-	return i.EvalObj(concat(args))
+	if lo < 0 {
+		lo = 0
+	}
+	if hi >= n {
+		hi = n - 1
+	}
+	return
 }
 
-func tclConcat(i *Interp, args []*TclObj) TclStatus {
-	return i.Return(concat(args))
+// lindex list ?index ...?
+func tclLindex(i *Interp, args []*TclObj) TclStatus {
+	if len(args) == 0 {
+		return i.FailStr("wrong # args: should be \"lindex list ?index ...?\"")
+	}
+	v := args[0]
+	for _, io := range args[1:] {
+		l, err := v.AsList()
+		if err != nil {
+			return i.Fail(err)
+		}
+		ind, err := parseIndex(io, len(l))
+		if err != nil {
+			return i.Fail(err)
+		}
+		if ind < 0 || ind >= len(l) {
+			return i.Return(kNil)
+		}
+		v = l[ind]
+	}
+	return i.Return(v)
 }
 
-func tclLappend(i *Interp, args []*TclObj) TclStatus {
-	if len(args) == 0 {
-		return i.FailStr("lappend: no arguments")
+// lrange list first last
+func tclLrange(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 3 {
+		return i.FailStr("wrong # args: should be \"lrange list first last\"")
 	}
-	vname := args[0].asVarRef()
-	v, ve := i.getVar(vname)
-	if ve != nil {
-		v = fromList(make([]*TclObj, 0, 10))
+	l, err := args[0].AsList()
+	if err != nil {
+		return i.Fail(err)
 	}
-	items, err := v.AsList()
+	lo, hi, err := parseRange(args[1], args[2], len(l))
 	if err != nil {
 		return i.Fail(err)
 	}
-	new_items := args[1:]
-	new_len := len(items) + len(new_items)
-	dest := make([]*TclObj, 0, new_len)
-	dest = append(append(dest, items...), new_items...)
-	newobj := fromList(dest)
-	i.setVar(vname, newobj)
-	return i.Return(newobj)
+	if lo > hi {
+		return i.Return(kNil)
+	}
+	return i.Return(fromList(l[lo : hi+1 : hi+1]))
+}
+
+// linsert list index ?element ...?
+//
+// Here end refers to the position after the last element, so the
+// elements are appended.
+func tclLinsert(i *Interp, args []*TclObj) TclStatus {
+	if len(args) < 2 {
+		return i.FailStr("wrong # args: should be \"linsert list index ?element ...?\"")
+	}
+	l, err := args[0].AsList()
+	if err != nil {
+		return i.Fail(err)
+	}
+	ind, err := parseIndex(args[1], len(l)+1)
+	if err != nil {
+		return i.Fail(err)
+	}
+	if ind < 0 {
+		ind = 0
+	} else if ind > len(l) {
+		ind = len(l)
+	}
+	res := make([]*TclObj, 0, len(l)+len(args)-2)
+	res = append(append(append(res, l[:ind]...), args[2:]...), l[ind:]...)
+	return i.Return(fromList(res))
+}
+
+// lreplace list first last ?element ...?
+func tclLreplace(i *Interp, args []*TclObj) TclStatus {
+	if len(args) < 3 {
+		return i.FailStr("wrong # args: should be \"lreplace list first last ?element ...?\"")
+	}
+	l, err := args[0].AsList()
+	if err != nil {
+		return i.Fail(err)
+	}
+	lo, hi, err := parseRange(args[1], args[2], len(l))
+	if err != nil {
+		return i.Fail(err)
+	}
+	if lo > len(l) {
+		lo = len(l)
+	}
+	if hi < lo-1 {
+		hi = lo - 1
+	}
+	res := make([]*TclObj, 0, len(l)+len(args)-3)
+	res = append(append(append(res, l[:lo]...), args[3:]...), l[hi+1:]...)
+	return i.Return(fromList(res))
+}
+
+// lset varName ?index ...? newValue
+//
+// An index equal to the length of its list appends to it.
+func tclLset(i *Interp, args []*TclObj) TclStatus {
+	if len(args) < 2 {
+		return i.FailStr("wrong # args: should be \"lset listVar ?index ...? value\"")
+	}
+	vr := args[0].asVarRef()
+	v, err := i.getVar(vr)
+	if err != nil {
+		return i.Fail(err)
+	}
+	inds := args[1 : len(args)-1]
+	if len(inds) == 1 {
+		l, err := inds[0].AsList()
+		if err != nil {
+			return i.Fail(err)
+		}
+		inds = l
+	}
+	nv, err := lsetIn(v, inds, args[len(args)-1])
+	if err != nil {
+		return i.Fail(err)
+	}
+	return i.setVar(vr, nv)
+}
+
+func lsetIn(v *TclObj, inds []*TclObj, val *TclObj) (*TclObj, error) {
+	if len(inds) == 0 {
+		return val, nil
+	}
+	l, err := v.AsList()
+	if err != nil {
+		return nil, err
+	}
+	ind, err := parseIndex(inds[0], len(l))
+	if err != nil {
+		return nil, err
+	}
+	if ind < 0 || ind > len(l) {
+		return nil, errors.New("list index out of range")
+	}
+	elem := kNil
+	if ind < len(l) {
+		elem = l[ind]
+	}
+	if elem, err = lsetIn(elem, inds[1:], val); err != nil {
+		return nil, err
+	}
+	res := v.Dup()
+	res.setElem(ind, elem)
+	return res, nil
+}
+
+// lpop listVar ?index ...?
+//
+// Removes and returns the last element of the list in listVar, or the
+// element at index. Several indices reach into nested lists, as with
+// lset.
+func tclLpop(i *Interp, args []*TclObj) TclStatus {
+	if len(args) < 1 {
+		return i.FailStr("wrong # args: should be \"lpop listVar ?index ...?\"")
+	}
+	vr := args[0].asVarRef()
+	v, err := i.getVar(vr)
+	if err != nil {
+		return i.Fail(err)
+	}
+	inds := args[1:]
+	if len(inds) == 0 {
+		inds = []*TclObj{FromStr("end")}
+	}
+	elt, nv, err := lpopIn(v, inds)
+	if err != nil {
+		return i.Fail(err)
+	}
+	if rc := i.setVar(vr, nv); rc != kTclOK {
+		return rc
+	}
+	return i.Return(elt)
+}
+
+// lpopIn returns the element of v at inds, and v without it.
+func lpopIn(v *TclObj, inds []*TclObj) (elt, nv *TclObj, err error) {
+	l, err := v.AsList()
+	if err != nil {
+		return nil, nil, err
+	}
+	ind, err := parseIndex(inds[0], len(l))
+	if err != nil {
+		return nil, nil, err
+	}
+	if ind < 0 || ind >= len(l) {
+		return nil, nil, errors.New("index \"" + inds[0].AsString() + "\" out of range")
+	}
+	if len(inds) > 1 {
+		var sub *TclObj
+		if elt, sub, err = lpopIn(l[ind], inds[1:]); err != nil {
+			return nil, nil, err
+		}
+		res := make([]*TclObj, len(l))
+		copy(res, l)
+		res[ind] = sub
+		return elt, fromList(res), nil
+	}
+	res := make([]*TclObj, 0, len(l)-1)
+	res = append(append(res, l[:ind]...), l[ind+1:]...)
+	return l[ind], fromList(res), nil
+}
+
+// lremove list ?index ...?
+//
+// Every index refers to the list as given, and those out of range are
+// ignored.
+func tclLremove(i *Interp, args []*TclObj) TclStatus {
+	if len(args) < 1 {
+		return i.FailStr("wrong # args: should be \"lremove list ?index ...?\"")
+	}
+	l, err := args[0].AsList()
+	if err != nil {
+		return i.Fail(err)
+	}
+	drop := make(map[int]bool)
+	for _, io := range args[1:] {
+		ind, err := parseIndex(io, len(l))
+		if err != nil {
+			return i.Fail(err)
+		}
+		drop[ind] = true
+	}
+	res := make([]*TclObj, 0, len(l))
+	for ind, e := range l {
+		if !drop[ind] {
+			res = append(res, e)
+		}
+	}
+	return i.Return(fromList(res))
+}
+
+// lseq count
+// lseq start ?to|..? end ??by? step?
+// lseq start count n ??by? step?
+//
+// Generates a sequence of integers. With an end, the sequence includes
+// it and the step defaults to 1 or -1, whichever goes towards it. A
+// step going the other way, or a step of 0, gives an empty list.
+func tclLseq(i *Interp, args []*TclObj) TclStatus {
+	usage := "wrong # args: should be \"lseq n ??op? n ??by? n??\""
+	if len(args) == 0 {
+		return i.FailStr(usage)
+	}
+	nums := make([]int, 0, 3)
+	mode := "to"
+	for ind, a := range args {
+		switch s := a.AsString(); {
+		case (s == "to" || s == ".." || s == "count") && ind == 1:
+			mode = s
+		case s == "by" && len(nums) == 2 && ind == len(args)-2:
+		default:
+			n, err := a.AsInt()
+			if err != nil {
+				return i.Fail(err)
+			}
+			nums = append(nums, n)
+		}
+	}
+	if len(nums) == 1 && len(args) == 1 {
+		nums, mode = []int{0, nums[0]}, "count"
+	}
+	if len(nums) < 2 || len(nums) > 3 {
+		return i.FailStr(usage)
+	}
+	start, step := nums[0], 1
+	count := nums[1]
+	if mode != "count" {
+		end := nums[1]
+		if end < start {
+			step = -1
+		}
+		if len(nums) == 3 {
+			step = nums[2]
+		}
+		// The distance and step are taken unsigned, since end-start
+		// doesn't fit in an int when they're far enough apart.
+		var dist, by uint64
+		if step > 0 && end >= start {
+			dist, by = uint64(end)-uint64(start), uint64(step)
+		} else if step < 0 && end <= start {
+			dist, by = uint64(start)-uint64(end), -uint64(step)
+		}
+		count = 0
+		if by != 0 {
+			count = maxListLength + 1
+			if n := dist / by; n < maxListLength {
+				count = int(n) + 1
+			}
+		}
+	} else if len(nums) == 3 {
+		step = nums[2]
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > maxListLength {
+		return i.FailStr("max length of a Tcl list exceeded")
+	}
+	res := make([]*TclObj, count)
+	for ind := range res {
+		res[ind] = FromInt(start + ind*step)
+	}
+	return i.Return(fromList(res))
+}
+
+// maxListLength bounds the lists lseq builds, so that an absurd range
+// is an error rather than an attempt to allocate it.
+const maxListLength = 1 << 28
+
+func concat(args []*TclObj) *TclObj {
+	var result bytes.Buffer
+	for ind, x := range args {
+		if ind != 0 {
+			result.WriteString(" ")
+		}
+		result.WriteString(strings.TrimSpace(x.AsString()))
+	}
+	loc := loc{"<unknown>", 0, 0}
+	if len(args) > 0 {
+		loc = args[0].loc
+	}
+	return FromStrLoc(result.String(), loc)
+}
+
+func tclEval(i *Interp, args []*TclObj) TclStatus {
+	if len(args) == 0 {
+		return i.FailStr("eval: no arguments")
+	}
+	if len(args) == 1 {
+		return i.EvalObj(args[0])
+	}
+	// Pure lists concatenate to one command, so their elements can be
+	// run as its words directly.
+	var words []*TclObj
+	for _, a := range args {
+		if !a.isPureList() {
+			return i.EvalObj(concat(args))
+		}
+		words = append(words, a.listval...)
+	}
+	return i.evalWords(words)
+}
+
+func tclConcat(i *Interp, args []*TclObj) TclStatus {
+	return i.Return(concat(args))
+}
+
+// append varName ?value ...?
+//
+// Appends each value to the variable, creating it if it doesn't exist,
+// and returns the new value.
+func tclAppend(i *Interp, args []*TclObj) TclStatus {
+	if len(args) == 0 {
+		return i.FailStr("wrong # args: should be \"append varName ?value ...?\"")
+	}
+	vr := args[0].asVarRef()
+	v, e := i.getVarIfExists(vr)
+	if e != nil {
+		return i.Fail(e)
+	}
+	var b strings.Builder
+	if v != nil {
+		b.WriteString(v.AsString())
+	}
+	for _, a := range args[1:] {
+		b.WriteString(a.AsString())
+	}
+	return i.setVar(vr, FromStr(b.String()))
+}
+
+func tclLappend(i *Interp, args []*TclObj) TclStatus {
+	if len(args) == 0 {
+		return i.FailStr("lappend: no arguments")
+	}
+	vname := args[0].asVarRef()
+	v, ve := i.getVarIfExists(vname)
+	if ve != nil {
+		return i.Fail(ve)
+	}
+	if v == nil {
+		v = fromList(make([]*TclObj, 0, 10))
+	}
+	items, err := v.AsList()
+	if err != nil {
+		return i.Fail(err)
+	}
+	new_items := args[1:]
+	new_len := len(items) + len(new_items)
+	dest := make([]*TclObj, 0, new_len)
+	dest = append(append(dest, items...), new_items...)
+	return i.setVar(vname, fromList(dest))
 }
 
 func getDuration(i *Interp, code *TclObj) (int64, TclStatus) {
@@ -510,6 +1374,53 @@ func formatTime(ns int64) string {
 	return fmt.Sprintf("%v ms", us/1000)
 }
 
+// exit ?code?
+func tclExit(i *Interp, args []*TclObj) TclStatus {
+	if len(args) > 1 {
+		return i.FailStr("wrong # args: should be \"exit ?returnCode?\"")
+	}
+	code := 0
+	if len(args) == 1 {
+		c, e := args[0].AsInt()
+		if e != nil {
+			return i.Fail(e)
+		}
+		code = c
+	}
+	for _, ch := range i.chans {
+		ch.flush()
+	}
+	if i.exitHandler == nil {
+		os.Exit(code)
+	}
+	i.exitHandler(code)
+	return i.Fail(&ExitError{code})
+}
+
+// sleep ms
+func tclSleep(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 1 {
+		return i.FailStr("wrong # args: should be \"sleep ms\"")
+	}
+	ms, e := args[0].AsInt()
+	if e != nil {
+		return i.Fail(e)
+	}
+	d := time.Duration(ms) * time.Millisecond
+	if i.ctx == nil {
+		time.Sleep(d)
+		return i.Return(kNil)
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return i.Return(kNil)
+	case <-i.ctx.Done():
+		return i.FailStr("evaluation cancelled: " + i.ctx.Err().Error())
+	}
+}
+
 func tclTime(i *Interp, args []*TclObj) TclStatus {
 	if len(args) == 1 {
 		dur, rc := getDuration(i, args[0])
@@ -537,14 +1448,12 @@ func tclFlush(i *Interp, args []*TclObj) TclStatus {
 	if len(args) != 1 {
 		return i.FailStr(fmt.Sprintf("flush: expected 1 argument, got %d", len(args)))
 	}
-	outfile, ok := i.chans[args[0].AsString()]
-	if !ok {
-		return i.FailStr("no such channel")
+	ch, e := i.getChan(args[0].AsString())
+	if e != nil {
+		return i.Fail(e)
 	}
-	if fl, ok := outfile.(interface {
-		Flush() error
-	}); ok {
-		fl.Flush()
+	if e := ch.flush(); e != nil {
+		return i.Fail(e)
 	}
 	return i.Return(kNil)
 }
@@ -552,7 +1461,7 @@ func tclFlush(i *Interp, args []*TclObj) TclStatus {
 func tclPuts(i *Interp, args []*TclObj) TclStatus {
 	newline := true
 	var s string
-	file := i.chans["stdout"].(io.Writer)
+	chname := "stdout"
 	if len(args) == 1 {
 		s = args[0].AsString()
 	} else if len(args) == 2 || len(args) == 3 {
@@ -561,22 +1470,22 @@ func tclPuts(i *Interp, args []*TclObj) TclStatus {
 			args = args[1:]
 		}
 		if len(args) > 1 {
-			outfile, ok := i.chans[args[0].AsString()]
-			if !ok {
-				return i.FailStr("wrong args")
-			}
-			file, ok = outfile.(io.Writer)
-			if !ok {
-				return i.FailStr("channel wasn't opened for writing")
-			}
+			chname = args[0].AsString()
 			args = args[1:]
 		}
 		s = args[0].AsString()
+	} else {
+		return i.FailStr("wrong # args: should be \"puts ?-nonewline? ?channelId? string\"")
+	}
+	ch, e := i.getChan(chname)
+	if e != nil {
+		return i.Fail(e)
 	}
 	if newline {
-		fmt.Fprintln(file, s)
-	} else {
-		fmt.Fprint(file, s)
+		s += "\n"
+	}
+	if e := ch.write(s); e != nil {
+		return i.Fail(e)
 	}
 	return i.Return(kNil)
 }
@@ -585,24 +1494,13 @@ func tclGets(i *Interp, args []*TclObj) TclStatus {
 	if len(args) != 1 && len(args) != 2 {
 		return i.FailStr("gets: need 1 or 2 arguments")
 	}
-	ini, ok := i.chans[args[0].AsString()]
-	if !ok {
-		return i.FailStr("invalid channel: " + args[0].AsString())
-	}
-	in, ok_read := ini.(*bufio.Reader)
-	if !ok_read {
-		return i.FailStr("channel wasn't opened for reading")
+	ch, ce := i.getChan(args[0].AsString())
+	if ce != nil {
+		return i.Fail(ce)
 	}
-	str, e := in.ReadString('\n')
-	eof := false
+	str, eof, e := ch.readLine()
 	if e != nil {
-		if e != io.EOF {
-			return i.Fail(e)
-		}
-		eof = true
-	}
-	if len(str) > 0 {
-		str = str[:len(str)-1]
+		return i.Fail(e)
 	}
 	if len(args) == 2 {
 		i.setVar(args[1].asVarRef(), FromStrLoc(str, i.loc))
@@ -615,6 +1513,44 @@ func tclGets(i *Interp, args []*TclObj) TclStatus {
 	return i.Return(FromStrLoc(str, i.loc))
 }
 
+// chan foreach varName channelId body
+//
+// Runs body once for each line read from channelId, with varName set
+// to the line. Lines are read one at a time as body needs them, so
+// the whole channel is never held in memory. After a break, the
+// channel is positioned just after the last line read, so gets or read
+// carry on from there.
+func chanForeach(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 3 {
+		return i.FailStr("wrong # args: should be \"chan foreach varName channelId body\"")
+	}
+	vr := args[0].asVarRef()
+	for {
+		// body may close the channel, so look it up each time.
+		ch, e := i.getChan(args[1].AsString())
+		if e != nil {
+			return i.Fail(e)
+		}
+		str, eof, e := ch.readLine()
+		if e != nil {
+			return i.Fail(e)
+		}
+		if eof {
+			break
+		}
+		if rc := i.setVar(vr, FromStrLoc(str, i.loc)); rc != kTclOK {
+			return rc
+		}
+		rc := i.EvalObj(args[2])
+		if rc == kTclBreak {
+			break
+		} else if rc != kTclOK && rc != kTclContinue {
+			return rc
+		}
+	}
+	return i.Return(kNil)
+}
+
 func getVarNameList(m varMap) *TclObj {
 	results := make([]*TclObj, len(m))
 	ind := 0
@@ -633,10 +1569,128 @@ var infoEn = ensembleSpec{
 	"globals": func(i *Interp) *TclObj {
 		return getVarNameList(i.getVarMap(true))
 	},
+	// locals are the variables of the current proc, leaving out those
+	// linked in by upvar or global.
+	"locals": func(i *Interp) *TclObj {
+		if i.frame.next == nil {
+			return kNil
+		}
+		locals := make(varMap)
+		for n, v := range i.frame.vars {
+			if v.link == nil {
+				locals[n] = v
+			}
+		}
+		return getVarNameList(locals)
+	},
 	"commands": getCmdNames,
+	"procs":    getProcNames,
 	"cmdcount": func(i *Interp) *TclObj {
 		return FromInt(i.cmdcount)
 	},
+	// script is the file being sourced, or "" if there isn't one.
+	"script": func(i *Interp) *TclObj {
+		return FromStr(i.file)
+	},
+	"nameofexecutable": func(i *Interp) *TclObj {
+		return FromStr(os.Args[0])
+	},
+	"level":    infoLevel,
+	"frame":    infoFrame,
+	"hostname": infoHostname,
+	"tclversion": func(i *Interp) *TclObj {
+		return FromStr(TclVersion)
+	},
+	"patchlevel": func(i *Interp) *TclObj {
+		return FromStr(TclPatchLevel)
+	},
+	// library is empty, since there's no library of Tcl scripts.
+	"library": func(i *Interp) *TclObj {
+		return kNil
+	},
+}
+
+// TclVersion is the version of Tcl that gotcl aims to be compatible
+// with, as reported by info tclversion. Most of Tcl 8.6 is there, as
+// are some commands from later versions, like lpop and lseq.
+const TclVersion = "8.6"
+
+// TclPatchLevel is reported by info patchlevel.
+const TclPatchLevel = TclVersion + ".0"
+
+func infoHostname(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 0 {
+		return i.FailStr("wrong # args: should be \"info hostname\"")
+	}
+	h, e := os.Hostname()
+	if e != nil {
+		return i.Fail(e)
+	}
+	return i.Return(FromStr(h))
+}
+
+// callFrame finds the frame for a level given to info level or info
+// frame. Positive levels count up from the global frame, and others
+// count down from the current one.
+func (i *Interp) callFrame(lo *TclObj) (*stackframe, error) {
+	n, e := lo.AsInt()
+	if e != nil {
+		return nil, e
+	}
+	cur := i.frame.level()
+	if n <= 0 {
+		n += cur
+	}
+	if n < 1 || n > cur {
+		return nil, errors.New("bad level \"" + lo.AsString() + "\"")
+	}
+	f := i.frame
+	for ; cur > n; cur-- {
+		f = f.next
+	}
+	return f, nil
+}
+
+// info level ?level?
+//
+// With no level, returns the current level. Otherwise returns the
+// command, with its arguments, that invoked the proc at that level.
+func infoLevel(i *Interp, args []*TclObj) TclStatus {
+	if len(args) > 1 {
+		return i.FailStr("wrong # args: should be \"info level ?number?\"")
+	}
+	if len(args) == 0 {
+		return i.Return(FromInt(i.frame.level()))
+	}
+	f, e := i.callFrame(args[0])
+	if e != nil {
+		return i.Fail(e)
+	}
+	return i.Return(fromList(f.call.words))
+}
+
+// info frame ?level?
+//
+// Like info level, but describes where the call was made, as a list
+// of keys and values: level, cmd, file and line. Only proc calls are
+// counted as frames.
+func infoFrame(i *Interp, args []*TclObj) TclStatus {
+	if len(args) > 1 {
+		return i.FailStr("wrong # args: should be \"info frame ?number?\"")
+	}
+	if len(args) == 0 {
+		return i.Return(FromInt(i.frame.level()))
+	}
+	f, e := i.callFrame(args[0])
+	if e != nil {
+		return i.Fail(e)
+	}
+	return i.Return(fromList([]*TclObj{
+		FromStr("level"), FromInt(f.level()),
+		FromStr("cmd"), fromList(f.call.words),
+		FromStr("file"), FromStr(f.call.loc.file),
+		FromStr("line"), FromInt(f.call.loc.line + 1),
+	}))
 }
 
 func varExists(i *Interp, args []*TclObj) TclStatus {
@@ -655,57 +1709,433 @@ func varExists(i *Interp, args []*TclObj) TclStatus {
 }
 
 func getCmdNames(i *Interp, args []*TclObj) TclStatus {
+	return i.matchNames(i.cmdNames(), args)
+}
+
+// info procs ?pattern?
+//
+// Like info commands, but only lists commands defined by proc.
+func getProcNames(i *Interp, args []*TclObj) TclStatus {
+	names := make([]string, 0, len(i.procs))
+	for n := range i.procs {
+		names = append(names, n)
+	}
+	return i.matchNames(names, args)
+}
+
+// matchNames returns the names that match the optional glob pattern
+// in args.
+func (i *Interp) matchNames(names []string, args []*TclObj) TclStatus {
 	if len(args) > 1 {
 		return i.FailStr("wrong # args")
 	}
-	filtered := false
-	pattern := ""
-	if len(args) == 1 {
-		filtered = true
-		pattern = args[0].AsString()
-	}
-	cmds := make([]*TclObj, len(i.cmds))
-	ind := 0
-	for n := range i.cmds {
-		if !filtered || GlobMatch(pattern, n) {
-			cmds[ind] = FromStrLoc(n, i.loc)
-			ind++
+	res := make([]*TclObj, 0, len(names))
+	for _, n := range names {
+		if len(args) == 0 || GlobMatch(args[0].AsString(), n) {
+			res = append(res, FromStrLoc(n, i.loc))
 		}
 	}
-	return i.Return(fromList(cmds[:ind]))
+	return i.Return(fromList(res))
 }
 
 var stringEn = ensembleSpec{
-	"length":     utf8.RuneCountInString,
-	"bytelength": func(s string) int { return len(s) },
-	"trim":       strings.TrimSpace,
+	"length":     (*TclObj).Len,
+	"bytelength": (*TclObj).ByteLen,
+	"trim":       strTrimmer("trim", strings.Trim, strings.TrimSpace),
+	"trimleft":   strTrimmer("trimleft", strings.TrimLeft, func(s string) string { return strings.TrimLeftFunc(s, unicode.IsSpace) }),
+	"trimright":  strTrimmer("trimright", strings.TrimRight, func(s string) string { return strings.TrimRightFunc(s, unicode.IsSpace) }),
 	"match":      GlobMatch,
 	"index":      strIndex,
+	"range":      strRange,
+	"replace":    strReplace,
+	"insert":     strInsert,
+	"is":         strIs,
+	"compare":    strCompare,
+	"equal":      strEqual,
+	"first":      strFirst,
+	"last":       strLast,
+	"tolower":    caseMapper("tolower", unicode.ToLower, unicode.ToLower),
+	"toupper":    caseMapper("toupper", unicode.ToUpper, unicode.ToUpper),
+	"totitle":    caseMapper("totitle", unicode.ToTitle, unicode.ToLower),
 }
 
+// string trim string ?chars?
+// string trimleft string ?chars?
+// string trimright string ?chars?
+//
+// strTrimmer returns a string subcommand that removes any of chars
+// from one or both ends of string with cut, or whitespace with space
+// if chars is omitted. Whitespace is any Unicode space, as it is to
+// the parser, so non-breaking spaces are trimmed too.
+func strTrimmer(name string, cut func(s, chars string) string, space func(s string) string) TclCmd {
+	return func(i *Interp, args []*TclObj) TclStatus {
+		if len(args) != 1 && len(args) != 2 {
+			return i.FailStr("wrong # args: should be \"string " + name + " string ?chars?\"")
+		}
+		if len(args) == 1 {
+			return i.Return(FromStr(space(args[0].AsString())))
+		}
+		return i.Return(FromStr(cut(args[0].AsString(), args[1].AsString())))
+	}
+}
+
+// string tolower string ?first? ?last?
+// string toupper string ?first? ?last?
+// string totitle string ?first? ?last?
+//
+// caseMapper returns the string subcommand name, which maps the first
+// rune in the range with first and the others with rest. Mapping is
+// rune by rune with the unicode package's default rules, so
+// language-specific cases like the Turkish dotted and dotless i aren't
+// handled.
+func caseMapper(name string, first, rest func(rune) rune) TclCmd {
+	return func(i *Interp, args []*TclObj) TclStatus {
+		if len(args) < 1 || len(args) > 3 {
+			return i.FailStr("wrong # args: should be \"string " + name + " string ?first? ?last?\"")
+		}
+		rs := []rune(args[0].AsString())
+		lo, hi := 0, len(rs)-1
+		var e error
+		if len(args) > 1 {
+			if lo, e = parseIndex(args[1], len(rs)); e != nil {
+				return i.Fail(e)
+			}
+			hi = lo
+		}
+		if len(args) > 2 {
+			if hi, e = parseIndex(args[2], len(rs)); e != nil {
+				return i.Fail(e)
+			}
+		}
+		if lo < 0 {
+			lo = 0
+		}
+		for ind := lo; ind <= hi && ind < len(rs); ind++ {
+			if ind == lo {
+				rs[ind] = first(rs[ind])
+			} else {
+				rs[ind] = rest(rs[ind])
+			}
+		}
+		return i.Return(FromStr(string(rs)))
+	}
+}
+
+// string index string charIndex
+//
+// An index before the start or past the end gives an empty string
+// rather than an error, so loops can walk off the end safely.
 func strIndex(i *Interp, args []*TclObj) TclStatus {
 	if len(args) != 2 {
 		return i.FailStr("wrong # args")
 	}
-	str := args[0].AsString()
-	ind, e := args[1].AsInt()
+	str := []rune(args[0].AsString())
+	ind, e := parseIndex(args[1], len(str))
 	if e != nil {
-		if args[1].AsString() == "end" {
-			ind = len(str) - 1
-		} else {
+		return i.Fail(e)
+	}
+	if ind < 0 || ind >= len(str) {
+		return i.Return(kNil)
+	}
+	return i.Return(FromStrLoc(string(str[ind]), i.loc))
+}
+
+func strRange(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 3 {
+		return i.FailStr("wrong # args: should be \"string range string first last\"")
+	}
+	str := []rune(args[0].AsString())
+	lo, hi, e := parseRange(args[1], args[2], len(str))
+	if e != nil {
+		return i.Fail(e)
+	}
+	if lo > hi {
+		return i.Return(kNil)
+	}
+	return i.Return(FromStr(string(str[lo : hi+1])))
+}
+
+// string replace string first last ?newstring?
+//
+// Replaces the characters from first to last with newstring, or
+// removes them if it's omitted. If the range is empty once clamped to
+// the string, the string is returned unchanged.
+func strReplace(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 3 && len(args) != 4 {
+		return i.FailStr("wrong # args: should be \"string replace string first last ?string?\"")
+	}
+	str := []rune(args[0].AsString())
+	lo, hi, e := parseRange(args[1], args[2], len(str))
+	if e != nil {
+		return i.Fail(e)
+	}
+	if lo > hi {
+		return i.Return(args[0])
+	}
+	repl := ""
+	if len(args) == 4 {
+		repl = args[3].AsString()
+	}
+	return i.Return(FromStr(string(str[:lo]) + repl + string(str[hi+1:])))
+}
+
+// string insert string index insertString
+//
+// Inserts insertString before the character at index, so that it
+// starts there in the result. As with linsert, end is just past the
+// last character; an index before the start inserts at the start,
+// and one past the end appends.
+func strInsert(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 3 {
+		return i.FailStr("wrong # args: should be \"string insert string index insertString\"")
+	}
+	str := []rune(args[0].AsString())
+	ind, e := parseIndex(args[1], len(str)+1)
+	if e != nil {
+		return i.Fail(e)
+	}
+	if ind < 0 {
+		ind = 0
+	} else if ind > len(str) {
+		ind = len(str)
+	}
+	return i.Return(FromStr(string(str[:ind]) + args[2].AsString() + string(str[ind:])))
+}
+
+// string compare ?-nocase? ?-length length? string1 string2
+//
+// Returns -1, 0 or 1 as string1 is less than, equal to or greater
+// than string2. With -length, only the first length characters of
+// each are compared; a negative length compares them all.
+func strCompare(i *Interp, args []*TclObj) TclStatus {
+	if len(args) == 2 {
+		if eq, ok := knownEqual(args[0], args[1]); ok && eq {
+			return i.Return(FromInt(0))
+		}
+	}
+	a, b, e := compareArgs("compare", args)
+	if e != nil {
+		return i.Fail(e)
+	}
+	return i.Return(FromInt(strings.Compare(a, b)))
+}
+
+// string equal ?-nocase? ?-length length? string1 string2
+func strEqual(i *Interp, args []*TclObj) TclStatus {
+	if len(args) == 2 {
+		if eq, ok := knownEqual(args[0], args[1]); ok {
+			return i.Return(FromBool(eq))
+		}
+	}
+	a, b, e := compareArgs("equal", args)
+	if e != nil {
+		return i.Fail(e)
+	}
+	return i.Return(FromBool(a == b))
+}
+
+// knownEqual reports whether a and b have the same string form, if
+// that can be told without making it: when they're the same object,
+// or both are integers with no string form yet, which would get
+// distinct strings only if they differ.
+func knownEqual(a, b *TclObj) (eq, ok bool) {
+	if a == b {
+		return true, true
+	}
+	if a.value == nil && b.value == nil && a.has_intval && b.has_intval {
+		return a.intval == b.intval, true
+	}
+	return false, false
+}
+
+// compareArgs parses the arguments of string compare and string equal,
+// returning the two strings cut to length and folded to lower case as
+// the options ask.
+func compareArgs(name string, args []*TclObj) (a, b string, err error) {
+	if len(args) == 2 {
+		return args[0].AsString(), args[1].AsString(), nil
+	}
+	usage := errors.New("wrong # args: should be \"string " + name + " ?-nocase? ?-length length? string1 string2\"")
+	nocase, length := false, -1
+	for len(args) > 2 {
+		switch opt := args[0].AsString(); opt {
+		case "-nocase":
+			nocase = true
+			args = args[1:]
+		case "-length":
+			if len(args) < 4 {
+				return "", "", usage
+			}
+			if length, err = args[1].AsInt(); err != nil {
+				return "", "", err
+			}
+			args = args[2:]
+		default:
+			return "", "", errors.New("bad option \"" + opt + "\": must be -nocase or -length")
+		}
+	}
+	if len(args) != 2 {
+		return "", "", usage
+	}
+	a, b = args[0].AsString(), args[1].AsString()
+	if length >= 0 {
+		a, b = firstRunes(a, length), firstRunes(b, length)
+	}
+	if nocase {
+		a, b = strings.ToLower(a), strings.ToLower(b)
+	}
+	return a, b, nil
+}
+
+// firstRunes returns the first n runes of s.
+func firstRunes(s string, n int) string {
+	for ind := range s {
+		if n == 0 {
+			return s[:ind]
+		}
+		n--
+	}
+	return s
+}
+
+// runesAt reports whether needle occurs in hay at ind.
+func runesAt(hay, needle []rune, ind int) bool {
+	if ind > len(hay)-len(needle) {
+		return false
+	}
+	for k, r := range needle {
+		if hay[ind+k] != r {
+			return false
+		}
+	}
+	return true
+}
+
+// string first needleString haystackString ?startIndex?
+func strFirst(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 2 && len(args) != 3 {
+		return i.FailStr("wrong # args: should be \"string first needleString haystackString ?startIndex?\"")
+	}
+	needle, hay := []rune(args[0].AsString()), []rune(args[1].AsString())
+	start := 0
+	if len(args) == 3 {
+		var e error
+		if start, e = parseIndex(args[2], len(hay)); e != nil {
 			return i.Fail(e)
 		}
+		if start < 0 {
+			start = 0
+		}
 	}
-	if ind >= len(str) {
-		return i.Return(kNil)
+	if len(needle) > 0 {
+		for ind := start; ind <= len(hay)-len(needle); ind++ {
+			if runesAt(hay, needle, ind) {
+				return i.Return(FromInt(ind))
+			}
+		}
+	}
+	return i.Return(FromInt(-1))
+}
+
+// string last needleString haystackString ?lastIndex?
+//
+// Finds the last match that ends at or before lastIndex.
+func strLast(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 2 && len(args) != 3 {
+		return i.FailStr("wrong # args: should be \"string last needleString haystackString ?lastIndex?\"")
+	}
+	needle, hay := []rune(args[0].AsString()), []rune(args[1].AsString())
+	last := len(hay) - len(needle)
+	if len(args) == 3 {
+		l, e := parseIndex(args[2], len(hay))
+		if e != nil {
+			return i.Fail(e)
+		}
+		// The whole match has to be at or before lastIndex. An index
+		// before the start is clamped first, so the subtraction can't
+		// wrap.
+		if l < -1 {
+			l = -1
+		}
+		if l := l - len(needle) + 1; l < last {
+			last = l
+		}
 	}
-	return i.Return(FromStrLoc(str[ind:ind+1], i.loc))
+	if len(needle) > 0 {
+		for ind := last; ind >= 0; ind-- {
+			if runesAt(hay, needle, ind) {
+				return i.Return(FromInt(ind))
+			}
+		}
+	}
+	return i.Return(FromInt(-1))
+}
+
+// regexp ?switches? exp string ?matchVar? ?subMatchVar ...?
+//
+// Tcl's defaults differ from Go's: "." matches a newline and "^" and
+// "$" only match at the ends of the string. So patterns are compiled
+// with (?s), and the line switches map onto Go's flags like this:
+//
+//	-linestop    (?-s)   "." doesn't match a newline
+//	-lineanchor  (?m)    "^" and "$" match at line boundaries
+//	-line        (?m-s)  both of the above
+//	-nocase      (?i)
+func tclRegexp(i *Interp, args []*TclObj) TclStatus {
+	dotall, multiline, nocase := true, false, false
+Loop:
+	for len(args) > 0 {
+		switch args[0].AsString() {
+		case "-nocase":
+			nocase = true
+		case "-line":
+			dotall, multiline = false, true
+		case "-lineanchor":
+			multiline = true
+		case "-linestop":
+			dotall = false
+		case "--":
+			args = args[1:]
+			break Loop
+		default:
+			break Loop
+		}
+		args = args[1:]
+	}
+	if len(args) < 2 {
+		return i.FailStr("wrong # args: should be \"regexp ?switches? exp string ?matchVar? ?subMatchVar ...?\"")
+	}
+	flags := ""
+	if dotall {
+		flags += "s"
+	}
+	if multiline {
+		flags += "m"
+	}
+	if nocase {
+		flags += "i"
+	}
+	re, e := regexp.Compile("(?" + flags + ")" + args[0].AsString())
+	if e != nil {
+		return i.FailStr("couldn't compile regular expression pattern: " + e.Error())
+	}
+	m := re.FindStringSubmatch(args[1].AsString())
+	for ind, v := range args[2:] {
+		val := kNil
+		if ind < len(m) {
+			val = FromStr(m[ind])
+		}
+		if rc := i.setVar(v.asVarRef(), val); rc != kTclOK {
+			return rc
+		}
+	}
+	return i.Return(FromBool(m != nil))
 }
 
 var arrayEn = ensembleSpec{
-	"size":   arraySize,
-	"get":    arrayGet,
-	"setvar": arraySet,
+	"size": arraySize,
+	"get":  arrayGet,
+	"set":  arraySet,
 	"exists": func(i *Interp, args []*TclObj) TclStatus {
 		if len(args) != 1 {
 			return i.FailStr("wrong # args")
@@ -713,6 +2143,95 @@ var arrayEn = ensembleSpec{
 		_, e := i.getArray(args[0].asVarRef())
 		return i.Return(FromBool(e == nil))
 	},
+	"default": ensembleSpec{
+		"set":    arrayDefaultSet,
+		"get":    arrayDefaultGet,
+		"exists": arrayDefaultExists,
+		"unset":  arrayDefaultUnset,
+	}.makeCmd(),
+	"statistics": arrayStatistics,
+}
+
+// array default set arrayName value
+// array default get arrayName
+// array default exists arrayName
+// array default unset arrayName
+//
+// Gives an array a default value, which reading any element it doesn't
+// have returns, so that a counter or a list kept per key needs no
+// check for the element before incr or lappend. Elements set explicitly
+// override it, and since every element can then be read, info exists
+// reports them all as existing. array size and array get see only
+// the elements actually set. array default set creates the array if
+// needed; the default goes away when the array is unset.
+func arrayDefaultSet(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 2 {
+		return i.FailStr("wrong # args: should be \"array default set arrayName value\"")
+	}
+	vn := args[0].asVarRef()
+	if vn.arrind != nil {
+		return i.FailStr("can't array default set \"" + args[0].AsString() + "\": variable is an array element")
+	}
+	m := i.getVarMap(vn.is_global)
+	if _, ok := m[vn.name]; !ok {
+		m[vn.name] = &varEntry{arrdata: make(map[string]*TclObj)}
+	}
+	arr, e := i.getArray(vn)
+	if e != nil {
+		return i.FailStr("can't array default set \"" + args[0].AsString() + "\": variable isn't array")
+	}
+	arr.defaultVal = args[1]
+	return i.Return(kNil)
+}
+
+func arrayDefaultGet(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 1 {
+		return i.FailStr("wrong # args: should be \"array default get arrayName\"")
+	}
+	arr, e := i.getArray(args[0].asVarRef())
+	if e != nil {
+		return i.Fail(e)
+	}
+	if arr.defaultVal == nil {
+		return i.FailStr("array has no default value")
+	}
+	return i.Return(arr.defaultVal)
+}
+
+func arrayDefaultExists(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 1 {
+		return i.FailStr("wrong # args: should be \"array default exists arrayName\"")
+	}
+	arr, e := i.getArray(args[0].asVarRef())
+	return i.Return(FromBool(e == nil && arr.defaultVal != nil))
+}
+
+func arrayDefaultUnset(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 1 {
+		return i.FailStr("wrong # args: should be \"array default unset arrayName\"")
+	}
+	arr, e := i.getArray(args[0].asVarRef())
+	if e != nil {
+		return i.Fail(e)
+	}
+	arr.defaultVal = nil
+	return i.Return(kNil)
+}
+
+// array statistics arrayName
+//
+// Describes how the array is stored. Arrays are Go maps, which don't
+// expose their buckets as Tcl's hash tables do, so only the number of
+// entries is reported.
+func arrayStatistics(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 1 {
+		return i.FailStr("wrong # args: should be \"array statistics arrayName\"")
+	}
+	arr, e := i.getArray(args[0].asVarRef())
+	if e != nil {
+		return i.Fail(e)
+	}
+	return i.Return(FromStr(strconv.Itoa(len(arr.arrdata)) + " entries in table"))
 }
 
 func arraySize(i *Interp, args []*TclObj) TclStatus {
@@ -756,9 +2275,18 @@ func arraySet(it *Interp, args []*TclObj) TclStatus {
 	if len(items)&1 != 0 {
 		return it.FailStr("list must have even number of elements")
 	}
-	for i := 0; i < len(items)-1; i++ {
+	if len(items) == 0 {
+		// Create an empty array, as Tcl does.
+		m := it.getVarMap(vn.is_global)
+		if _, ok := m[vn.name]; !ok {
+			m[vn.name] = &varEntry{arrdata: make(map[string]*TclObj)}
+		}
+	}
+	for i := 0; i < len(items)-1; i += 2 {
 		vn.arrind = &tliteral{strval: items[i].AsString()}
-		it.setVar(vn, items[i+1])
+		if rc := it.setVar(vn, items[i+1]); rc != kTclOK {
+			return rc
+		}
 	}
 	return it.Return(kNil)
 }
@@ -770,13 +2298,16 @@ func tclSource(i *Interp, args []*TclObj) TclStatus {
 	filename := args[0].AsString()
 	file, e := os.Open(filename)
 	if e != nil {
-		return i.Fail(e)
+		return i.Fail(posixError(e))
 	}
 	defer file.Close()
 	cmds, pe := parseCommands(bufio.NewReader(file), loc{filename, 0, 0})
 	if pe != nil {
 		return i.Fail(pe)
 	}
+	oldfile := i.file
+	i.file = filename
+	defer func() { i.file = oldfile }()
 	return i.evalCmds(cmds)
 }
 
@@ -841,13 +2372,33 @@ func tclRename(i *Interp, args []*TclObj) TclStatus {
 		if !ok {
 			return i.FailStr("can't delete command, doesn't exist")
 		}
-		i.SetCmd(oldn, nil)
+		if e := i.deleteCmd(oldn); e != nil {
+			return i.Fail(e)
+		}
 	} else {
 		if !ok {
 			return i.FailStr("can't rename command, doesn't exist")
 		}
+		wasProc, wasAlias, class := i.procs[oldn], i.aliases[oldn], i.classes[oldn]
+		ts := i.cmdTraces[oldn]
+		delete(i.cmdTraces, oldn)
 		i.SetCmd(oldn, nil)
 		i.SetCmd(newn, oldc)
+		if wasProc {
+			i.procs[newn] = true
+		}
+		if wasAlias {
+			i.aliases[newn] = true
+		}
+		if class != nil {
+			i.classes[newn] = class
+		}
+		if ts != nil {
+			i.cmdTraces[newn] = ts
+			if e := i.fireCmdTraces(ts, oldn, newn, traceRename); e != nil {
+				return i.Fail(e)
+			}
+		}
 	}
 	return i.Return(kNil)
 }
@@ -874,42 +2425,71 @@ var tclBasicCmds = make(map[string]TclCmd)
 
 func init() {
 	for _, o := range binOps {
-		tclBasicCmds[o.name] = MakeCmd(o.action)
+		cmd := mathopCmd(o)
+		tclBasicCmds[o.name] = cmd
+		tclBasicCmds["tcl::mathop::"+o.name] = cmd
+	}
+	for n, f := range mathFuncs {
+		tclBasicCmds[mathfuncPrefix+n] = f.makeCmd()
 	}
 	initCmds := map[string]TclCmd{
-		"apply":    tclApply,
-		"array":    arrayEn.makeCmd(),
-		"break":    tclBreak,
-		"catch":    tclCatch,
-		"concat":   tclConcat,
-		"continue": tclContinue,
-		"eval":     tclEval,
-		"expr":     tclExpr,
-		"flush":    tclFlush,
-		"for":      tclFor,
-		"foreach":  tclForeach,
-		"gets":     tclGets,
-		"if":       tclIf,
-		"incr":     tclIncr,
-		"info":     infoEn.makeCmd(),
-		"lappend":  tclLappend,
-		"lindex":   tclLindex,
-		"list":     tclList,
-		"llength":  tclLlength,
-		"lsearch":  tclLsearch,
-		"open":     tclOpen,
-		"puts":     tclPuts,
-		"rename":   tclRename,
-		"return":   tclReturn,
-		"setvar":   tclSet,
-		"source":   tclSource,
-		"split":    tclSplit,
-		"string":   stringEn.makeCmd(),
-		"time":     tclTime,
-		"unset":    tclUnset,
-		"uplevel":  tclUplevel,
-		"upvar":    tclUpvar,
-		"while":    tclWhile,
+		"append":     tclAppend,
+		"apply":      tclApply,
+		"array":      arrayEn.makeCmd(),
+		"assert":     tclAssert,
+		"break":      tclBreak,
+		"breakpoint": tclBreakpoint,
+		"catch":      tclCatch,
+		"chan":       chanEn.makeCmd(),
+		"close":      tclClose,
+		"concat":     tclConcat,
+		"const":      tclConst,
+		"continue":   tclContinue,
+		"eof":        tclEof,
+		"eval":       tclEval,
+		"exit":       tclExit,
+		"expr":       tclExpr,
+		"fconfigure": tclFconfigure,
+		"file":       fileEn.makeCmd(),
+		"flush":      tclFlush,
+		"for":        tclFor,
+		"foreach":    tclForeach,
+		"gets":       tclGets,
+		"if":         tclIf,
+		"incr":       tclIncr,
+		"info":       infoEn.makeCmd(),
+		"lappend":    tclLappend,
+		"lindex":     tclLindex,
+		"linsert":    tclLinsert,
+		"list":       tclList,
+		"llength":    tclLlength,
+		"lpop":       tclLpop,
+		"lrange":     tclLrange,
+		"lremove":    tclLremove,
+		"lreplace":   tclLreplace,
+		"lsearch":    tclLsearch,
+		"lseq":       tclLseq,
+		"lset":       tclLset,
+		"open":       tclOpen,
+		"pid":        tclPid,
+		"puts":       tclPuts,
+		"rand":       randFn,
+		"read":       tclRead,
+		"readfile":   tclReadfile,
+		"regexp":     tclRegexp,
+		"rename":     tclRename,
+		"return":     tclReturn,
+		"set":        tclSet,
+		"sleep":      tclSleep,
+		"source":     tclSource,
+		"split":      tclSplit,
+		"srand":      srandFn,
+		"string":     stringEn.makeCmd(),
+		"time":       tclTime,
+		"unset":      tclUnset,
+		"uplevel":    tclUplevel,
+		"upvar":      tclUpvar,
+		"while":      tclWhile,
 	}
 	for k, v := range initCmds {
 		tclBasicCmds[k] = v
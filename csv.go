@@ -0,0 +1,89 @@
+package gotcl
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// csv split line ?-sep char?
+// csv join list ?-sep char?
+//
+// Convert between a CSV record and a list, with the quoting rules of
+// RFC 4180: fields containing the separator, quotes or newlines are
+// quoted, and quotes inside them are doubled. The separator defaults
+// to a comma.
+
+// csvArgs checks the arguments of a csv subcommand, returning its
+// operand and separator.
+func csvArgs(sub string, args []*TclObj) (*TclObj, rune, error) {
+	if len(args) != 1 && len(args) != 3 || len(args) == 3 && args[1].AsString() != "-sep" {
+		return nil, 0, errors.New("wrong # args: should be \"csv " + sub + " ?-sep char?\"")
+	}
+	if len(args) == 1 {
+		return args[0], ',', nil
+	}
+	sep := args[2].AsString()
+	r, n := utf8.DecodeRuneInString(sep)
+	if n == 0 || n != len(sep) || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+		return nil, 0, errors.New("bad separator \"" + sep + "\": must be a single character other than a quote or newline")
+	}
+	return args[0], r, nil
+}
+
+func csvSplit(i *Interp, args []*TclObj) TclStatus {
+	line, sep, e := csvArgs("split line", args)
+	if e != nil {
+		return i.Fail(e)
+	}
+	r := csv.NewReader(strings.NewReader(line.AsString()))
+	r.Comma = sep
+	r.FieldsPerRecord = -1
+	fields, e := r.Read()
+	if e == io.EOF {
+		return i.Return(kNil)
+	} else if e != nil {
+		return i.Fail(e)
+	}
+	return i.Return(FromList(fields))
+}
+
+func csvJoin(i *Interp, args []*TclObj) TclStatus {
+	lst, sep, e := csvArgs("join list", args)
+	if e != nil {
+		return i.Fail(e)
+	}
+	l, e := lst.AsList()
+	if e != nil {
+		return i.Fail(e)
+	}
+	fields := make([]string, len(l))
+	for ind, v := range l {
+		fields[ind] = v.AsString()
+	}
+	// A lone empty field would be written as an empty line, which
+	// reads back as no fields at all, so it's quoted instead.
+	if len(fields) == 1 && fields[0] == "" {
+		return i.Return(FromStr(`""`))
+	}
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.Comma = sep
+	w.Write(fields)
+	w.Flush()
+	if e := w.Error(); e != nil {
+		return i.Fail(e)
+	}
+	return i.Return(FromStr(strings.TrimSuffix(b.String(), "\n")))
+}
+
+var csvEn = ensembleSpec{
+	"split": csvSplit,
+	"join":  csvJoin,
+}
+
+func init() {
+	RegisterDefaultCmd("csv", csvEn.makeCmd())
+}
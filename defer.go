@@ -0,0 +1,43 @@
+package gotcl
+
+// defer script
+//
+// Registers script to run when the current proc returns, whether
+// normally or with an error. Deferred scripts run in the proc's frame,
+// last registered first, after the body is done. An error from one of
+// them becomes the error of the proc, unless the body already failed,
+// in which case the body's error is kept and the remaining scripts
+// still run.
+func tclDefer(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 1 {
+		return i.FailStr("wrong # args: should be \"defer script\"")
+	}
+	if i.frame.call == nil {
+		return i.FailStr("defer can only be called in a proc")
+	}
+	i.frame.deferred = append(i.frame.deferred, args[0])
+	return i.Return(kNil)
+}
+
+// runDeferred runs the current frame's deferred scripts after its body
+// finished with rc, returning the status of the call as a whole.
+func (i *Interp) runDeferred(rc TclStatus) TclStatus {
+	f := i.frame
+	for len(f.deferred) > 0 {
+		k := len(f.deferred) - 1
+		script := f.deferred[k]
+		f.deferred = f.deferred[:k]
+		retval, err := i.retval, i.err
+		drc := i.EvalObj(script)
+		if drc == kTclErr && (rc != kTclErr || isExit(i.err) && !isExit(err)) {
+			rc = kTclErr
+			continue
+		}
+		i.retval, i.err = retval, err
+	}
+	return rc
+}
+
+func init() {
+	RegisterDefaultCmd("defer", tclDefer)
+}
@@ -0,0 +1,393 @@
+package gotcl
+
+import (
+	"errors"
+)
+
+// Dicts are lists of alternating keys and values. Keys are unique and
+// keep the order they were first added in; if a list repeats a key, the
+// last value for it wins.
+
+// dictEntries returns the key/value pairs of o with duplicate keys
+// removed.
+func dictEntries(o *TclObj) ([]*TclObj, error) {
+	l, e := o.AsList()
+	if e != nil {
+		return nil, e
+	}
+	if len(l)%2 != 0 {
+		return nil, errors.New("missing value to go with key")
+	}
+	seen := make(map[string]int, len(l)/2)
+	kv := make([]*TclObj, 0, len(l))
+	for ind := 0; ind < len(l); ind += 2 {
+		k := l[ind].AsString()
+		if at, ok := seen[k]; ok {
+			kv[at+1] = l[ind+1]
+			continue
+		}
+		seen[k] = len(kv)
+		kv = append(kv, l[ind], l[ind+1])
+	}
+	return kv, nil
+}
+
+// dictFind returns the index of the value for key in kv, or -1.
+func dictFind(kv []*TclObj, key string) int {
+	for ind := 0; ind < len(kv); ind += 2 {
+		if kv[ind].AsString() == key {
+			return ind + 1
+		}
+	}
+	return -1
+}
+
+// dictLookup follows a path of keys through nested dicts. found is
+// false if a key is missing; err is set if a dict is malformed.
+func dictLookup(d *TclObj, keys []*TclObj) (v *TclObj, found bool, err error) {
+	v = d
+	for _, k := range keys {
+		kv, e := dictEntries(v)
+		if e != nil {
+			return nil, false, e
+		}
+		at := dictFind(kv, k.AsString())
+		if at < 0 {
+			return nil, false, nil
+		}
+		v = kv[at]
+	}
+	return v, true, nil
+}
+
+// dictPut returns a copy of d with the value at the key path replaced,
+// creating any missing dicts along the way.
+func dictPut(d *TclObj, keys []*TclObj, value *TclObj) (*TclObj, error) {
+	kv, e := dictEntries(d)
+	if e != nil {
+		return nil, e
+	}
+	at := dictFind(kv, keys[0].AsString())
+	if len(keys) > 1 {
+		inner := kNil
+		if at >= 0 {
+			inner = kv[at]
+		}
+		if value, e = dictPut(inner, keys[1:], value); e != nil {
+			return nil, e
+		}
+	}
+	res := make([]*TclObj, len(kv), len(kv)+2)
+	copy(res, kv)
+	if at >= 0 {
+		res[at] = value
+	} else {
+		res = append(res, keys[0], value)
+	}
+	return fromList(res), nil
+}
+
+// dictRemove returns a copy of d without the entry at the key path,
+// rebuilding each dict along it. A missing last key leaves d as it is,
+// but the keys before it must all exist.
+func dictRemove(d *TclObj, keys []*TclObj) (*TclObj, error) {
+	kv, e := dictEntries(d)
+	if e != nil {
+		return nil, e
+	}
+	at := dictFind(kv, keys[0].AsString())
+	if len(keys) == 1 {
+		if at < 0 {
+			return fromList(kv), nil
+		}
+		res := make([]*TclObj, 0, len(kv)-2)
+		res = append(append(res, kv[:at-1]...), kv[at+1:]...)
+		return fromList(res), nil
+	}
+	if at < 0 {
+		return nil, errors.New("key \"" + keys[0].AsString() + "\" not known in dictionary")
+	}
+	inner, e := dictRemove(kv[at], keys[1:])
+	if e != nil {
+		return nil, e
+	}
+	res := make([]*TclObj, len(kv))
+	copy(res, kv)
+	res[at] = inner
+	return fromList(res), nil
+}
+
+func dictCreate(i *Interp, args []*TclObj) TclStatus {
+	if len(args)%2 != 0 {
+		return i.FailStr("wrong # args: should be \"dict create ?key value ...?\"")
+	}
+	kv, e := dictEntries(fromList(args))
+	if e != nil {
+		return i.Fail(e)
+	}
+	return i.Return(fromList(kv))
+}
+
+// dict get dictionary ?key ...?
+func dictGet(i *Interp, args []*TclObj) TclStatus {
+	if len(args) == 0 {
+		return i.FailStr("wrong # args: should be \"dict get dictionary ?key ...?\"")
+	}
+	if len(args) == 1 {
+		kv, e := dictEntries(args[0])
+		if e != nil {
+			return i.Fail(e)
+		}
+		return i.Return(fromList(kv))
+	}
+	v, found, e := dictLookup(args[0], args[1:])
+	if e != nil {
+		return i.Fail(e)
+	}
+	if !found {
+		return i.FailStr("key \"" + args[len(args)-1].AsString() + "\" not known in dictionary")
+	}
+	return i.Return(v)
+}
+
+// dict getdef dictionary ?key ...? key default
+//
+// Like dict get, but returns default if any key on the path is missing.
+func dictGetdef(i *Interp, args []*TclObj) TclStatus {
+	if len(args) < 3 {
+		return i.FailStr("wrong # args: should be \"dict getdef dictionary ?key ...? key default\"")
+	}
+	v, found, e := dictLookup(args[0], args[1:len(args)-1])
+	if e != nil {
+		return i.Fail(e)
+	}
+	if !found {
+		v = args[len(args)-1]
+	}
+	return i.Return(v)
+}
+
+// dict exists dictionary key ?key ...?
+func dictExists(i *Interp, args []*TclObj) TclStatus {
+	if len(args) < 2 {
+		return i.FailStr("wrong # args: should be \"dict exists dictionary key ?key ...?\"")
+	}
+	_, found, e := dictLookup(args[0], args[1:])
+	return i.Return(FromBool(found && e == nil))
+}
+
+func dictKeys(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 1 {
+		return i.FailStr("wrong # args: should be \"dict keys dictionary\"")
+	}
+	kv, e := dictEntries(args[0])
+	if e != nil {
+		return i.Fail(e)
+	}
+	keys := make([]*TclObj, 0, len(kv)/2)
+	for ind := 0; ind < len(kv); ind += 2 {
+		keys = append(keys, kv[ind])
+	}
+	return i.Return(fromList(keys))
+}
+
+func dictSize(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 1 {
+		return i.FailStr("wrong # args: should be \"dict size dictionary\"")
+	}
+	kv, e := dictEntries(args[0])
+	if e != nil {
+		return i.Fail(e)
+	}
+	return i.Return(FromInt(len(kv) / 2))
+}
+
+var dictSortOptions = []string{"-ascii", "-command", "-decreasing", "-dictionary", "-increasing",
+	"-integer", "-key", "-nocase", "-real", "-value"}
+
+// dict sort dictionary ?-key|-value? ?-ascii|-dictionary|-integer|-real?
+// ?-command cmd? ?-nocase? ?-increasing|-decreasing?
+//
+// Returns dictionary with its entries reordered, by key unless -value
+// is given. The comparisons are those of lsort, and entries that
+// compare equal keep their order.
+func dictSort(i *Interp, args []*TclObj) TclStatus {
+	if len(args) == 0 {
+		return i.FailStr("wrong # args: should be \"dict sort dictionary ?options?\"")
+	}
+	so, e := parseSortOpts(i, args[1:], dictSortOptions)
+	if e != nil {
+		return i.Fail(e)
+	}
+	if so.index == nil {
+		so.index = FromInt(0)
+	}
+	kv, e := dictEntries(args[0])
+	if e != nil {
+		return i.Fail(e)
+	}
+	entries := make([]*TclObj, 0, len(kv)/2)
+	for ind := 0; ind < len(kv); ind += 2 {
+		entries = append(entries, fromList(kv[ind:ind+2]))
+	}
+	order, e := so.sort(entries)
+	if e != nil {
+		return i.Fail(e)
+	}
+	res := make([]*TclObj, 0, len(kv))
+	for _, pos := range order {
+		res = append(res, entries[pos].listval...)
+	}
+	return i.Return(fromList(res))
+}
+
+// dict set dictVarName key ?key ...? value
+func dictSet(i *Interp, args []*TclObj) TclStatus {
+	if len(args) < 3 {
+		return i.FailStr("wrong # args: should be \"dict set dictVarName key ?key ...? value\"")
+	}
+	vn := args[0].asVarRef()
+	d, e := i.getVar(vn)
+	if e != nil {
+		d = kNil
+	}
+	nd, e := dictPut(d, args[1:len(args)-1], args[len(args)-1])
+	if e != nil {
+		return i.Fail(e)
+	}
+	if rc := i.setVar(vn, nd); rc != kTclOK {
+		return rc
+	}
+	return i.Return(nd)
+}
+
+// dict unset dictVarName key ?key ...?
+func dictUnset(i *Interp, args []*TclObj) TclStatus {
+	if len(args) < 2 {
+		return i.FailStr("wrong # args: should be \"dict unset dictVarName key ?key ...?\"")
+	}
+	vn := args[0].asVarRef()
+	d, e := i.getVar(vn)
+	if e != nil {
+		d = kNil
+	}
+	nd, e := dictRemove(d, args[1:])
+	if e != nil {
+		return i.Fail(e)
+	}
+	if rc := i.setVar(vn, nd); rc != kTclOK {
+		return rc
+	}
+	return i.Return(nd)
+}
+
+// dict for {keyVarName valueVarName} dictionary body
+func dictFor(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 3 {
+		return i.FailStr("wrong # args: should be \"dict for {keyVarName valueVarName} dictionary script\"")
+	}
+	vars, e := args[0].AsList()
+	if e != nil {
+		return i.Fail(e)
+	}
+	if len(vars) != 2 {
+		return i.FailStr("must have exactly two variable names")
+	}
+	kv, e := dictEntries(args[1])
+	if e != nil {
+		return i.Fail(e)
+	}
+	for ind := 0; ind < len(kv); ind += 2 {
+		i.setVar(vars[0].asVarRef(), kv[ind])
+		i.setVar(vars[1].asVarRef(), kv[ind+1])
+		rc := i.EvalObj(args[2])
+		if rc == kTclBreak {
+			break
+		} else if rc != kTclOK && rc != kTclContinue {
+			return rc
+		}
+	}
+	return i.Return(kNil)
+}
+
+// dict with dictVarName ?key ...? body
+//
+// Runs body with a local variable for each key of the dict at the key
+// path, then writes the variables back into the dict. The write-back
+// happens however body completes, including with an error, so the
+// dict sees every change made before the error. A key whose variable
+// was unset is removed. Nothing is written back if the dict variable
+// itself was unset or the path no longer leads to a dict.
+func dictWith(i *Interp, args []*TclObj) TclStatus {
+	if len(args) < 2 {
+		return i.FailStr("wrong # args: should be \"dict with dictVarName ?key ...? script\"")
+	}
+	vn := args[0].asVarRef()
+	keys, body := args[1:len(args)-1], args[len(args)-1]
+	d, e := i.getVar(vn)
+	if e != nil {
+		return i.Fail(e)
+	}
+	inner, found, e := dictLookup(d, keys)
+	if e != nil {
+		return i.Fail(e)
+	}
+	if !found {
+		return i.FailStr("key \"" + keys[len(keys)-1].AsString() + "\" not known in dictionary")
+	}
+	kv, e := dictEntries(inner)
+	if e != nil {
+		return i.Fail(e)
+	}
+	for ind := 0; ind < len(kv); ind += 2 {
+		if rc := i.setVar(varRef{name: kv[ind].AsString()}, kv[ind+1]); rc != kTclOK {
+			return rc
+		}
+	}
+
+	rc := i.EvalObj(body)
+	retval, err := i.retval, i.err
+
+	if d, e = i.getVar(vn); e != nil {
+		return rc
+	}
+	if _, found, e = dictLookup(d, keys); e != nil || !found {
+		return rc
+	}
+	updated := make([]*TclObj, 0, len(kv))
+	for ind := 0; ind < len(kv); ind += 2 {
+		if v, e := i.getVar(varRef{name: kv[ind].AsString()}); e == nil {
+			updated = append(updated, kv[ind], v)
+		}
+	}
+	nd := fromList(updated)
+	if len(keys) > 0 {
+		if nd, e = dictPut(d, keys, nd); e != nil {
+			return i.Fail(e)
+		}
+	}
+	if wrc := i.setVar(vn, nd); wrc != kTclOK {
+		return wrc
+	}
+	i.retval, i.err = retval, err
+	return rc
+}
+
+var dictEn = ensembleSpec{
+	"create":         dictCreate,
+	"exists":         dictExists,
+	"for":            dictFor,
+	"get":            dictGet,
+	"getdef":         dictGetdef,
+	"getwithdefault": dictGetdef,
+	"keys":           dictKeys,
+	"set":            dictSet,
+	"size":           dictSize,
+	"sort":           dictSort,
+	"unset":          dictUnset,
+	"with":           dictWith,
+}
+
+func init() {
+	RegisterDefaultCmd("dict", dictEn.makeCmd())
+}
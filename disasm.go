@@ -0,0 +1,74 @@
+package gotcl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Disassemble parses s and describes the resulting commands and
+// tokens, one per line and indented by nesting, with the kind and
+// location of each. Braced blocks are shown as written, since they
+// are only parsed when evaluated.
+func (i *Interp) Disassemble(s string) (string, error) {
+	cmds, e := ParseScript(s, i.file)
+	if e != nil {
+		return "", e
+	}
+	var b strings.Builder
+	for _, c := range cmds {
+		dumpCommand(&b, c, 0)
+	}
+	return b.String(), nil
+}
+
+func dumpLine(b *strings.Builder, depth int, format string, args ...interface{}) {
+	b.WriteString(strings.Repeat("  ", depth))
+	fmt.Fprintf(b, format, args...)
+	b.WriteByte('\n')
+}
+
+func dumpCommand(b *strings.Builder, c Command, depth int) {
+	dumpLine(b, depth, "command %v words=%d", c.Pos, len(c.Words))
+	for _, w := range c.Words {
+		dumpTok(b, w, depth+1)
+	}
+}
+
+func dumpTok(b *strings.Builder, t Token, depth int) {
+	switch t.Kind {
+	case LiteralToken, BlockToken, VarToken, CommentToken:
+		dumpLine(b, depth, "%v %v %s", t.Kind, t.Pos, strconv.Quote(t.Text))
+	case RawToken:
+		dumpLine(b, depth, "%v %s", t.Kind, strconv.Quote(t.Text))
+	case StringToken:
+		dumpLine(b, depth, "%v %v parts=%d", t.Kind, t.Pos, len(t.Parts))
+	default:
+		dumpLine(b, depth, "%v %v", t.Kind, t.Pos)
+	}
+	if t.Cmd != nil {
+		dumpCommand(b, *t.Cmd, depth+1)
+	}
+	for _, p := range t.Parts {
+		dumpTok(b, p, depth+1)
+	}
+	if t.Index != nil {
+		dumpTok(b, *t.Index, depth+1)
+	}
+}
+
+// tcl::unsupported::disassemble script
+func tclDisassemble(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 1 {
+		return i.FailStr("wrong # args: should be \"tcl::unsupported::disassemble script\"")
+	}
+	s, e := i.Disassemble(args[0].AsString())
+	if e != nil {
+		return i.Fail(e)
+	}
+	return i.Return(FromStr(s))
+}
+
+func init() {
+	RegisterDefaultCmd("tcl::unsupported::disassemble", tclDisassemble)
+}
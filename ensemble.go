@@ -24,6 +24,13 @@ func (es ensembleSpec) makeCmd() TclCmd {
 	for k, v := range es {
 		cmds[k] = MakeCmd(v)
 	}
+	return MakeEnsemble(cmds)
+}
+
+// MakeEnsemble returns a command that dispatches on its first
+// argument to the matching command in cmds. A subcommand may be
+// abbreviated to any unambiguous prefix.
+func MakeEnsemble(cmds map[string]TclCmd) TclCmd {
 	return func(i *Interp, args []*TclObj) TclStatus {
 		if len(args) == 0 {
 			return i.FailStr("wrong # args")
@@ -32,6 +39,22 @@ func (es ensembleSpec) makeCmd() TclCmd {
 	}
 }
 
+// prefixMatches returns the names that s is a prefix of.
+// An exact match is returned alone, even if it is also a
+// prefix of other names.
+func prefixMatches(names []string, s string) []string {
+	var matches []string
+	for _, n := range names {
+		if n == s {
+			return []string{n}
+		}
+		if strings.HasPrefix(n, s) {
+			matches = append(matches, n)
+		}
+	}
+	return matches
+}
+
 func doEnsemble(e map[string]TclCmd, cmd string, i *Interp, args []*TclObj) TclStatus {
 	c, ok := e[cmd]
 	if ok {
@@ -43,6 +66,169 @@ func doEnsemble(e map[string]TclCmd, cmd string, i *Interp, args []*TclObj) TclS
 		sv[ind] = k
 		ind++
 	}
+	if m := prefixMatches(sv, cmd); len(m) == 1 && cmd != "" {
+		return e[m[0]](i, args)
+	}
 	return i.FailStr(
 		fmt.Sprintf("unknown or ambiguous subcommand \"%s\". Must be %s.", cmd, formatNames(sv)))
 }
+
+// namespace ensemble create -command name -map {sub cmdPrefix ...}
+func nsEnsembleCreate(i *Interp, args []*TclObj) TclStatus {
+	var name string
+	var mapping []*TclObj
+	for len(args) > 0 {
+		if len(args) < 2 {
+			return i.FailStr("wrong # args: should be \"namespace ensemble create -command name -map dict\"")
+		}
+		switch args[0].AsString() {
+		case "-command":
+			name = args[1].AsString()
+		case "-map":
+			m, e := args[1].AsList()
+			if e != nil {
+				return i.Fail(e)
+			}
+			if len(m)%2 != 0 {
+				return i.FailStr("missing value to go with key")
+			}
+			mapping = m
+		default:
+			return i.FailStr("bad option \"" + args[0].AsString() + "\": must be -command or -map")
+		}
+		args = args[2:]
+	}
+	if name == "" {
+		return i.FailStr("namespace ensemble create: -command is required")
+	}
+	cmds := make(map[string]TclCmd, len(mapping)/2)
+	for ind := 0; ind < len(mapping); ind += 2 {
+		prefix, e := mapping[ind+1].AsList()
+		if e != nil {
+			return i.Fail(e)
+		}
+		if len(prefix) == 0 {
+			return i.FailStr("empty command prefix for subcommand \"" + mapping[ind].AsString() + "\"")
+		}
+		cmds[mapping[ind].AsString()] = func(i *Interp, args []*TclObj) TclStatus {
+			words := make([]*TclObj, 0, len(prefix)+len(args))
+			return i.invoke(append(append(words, prefix...), args...))
+		}
+	}
+	i.SetCmd(name, MakeEnsemble(cmds))
+	return i.Return(FromStr(name))
+}
+
+var nsEnsembleEn = ensembleSpec{
+	"create": nsEnsembleCreate,
+}
+
+// splitQualified splits a name like ::a::b::c into its qualifiers,
+// ::a::b, and its tail, c. Runs of more than two colons count as a
+// single separator.
+func splitQualified(name string) (quals, tail string) {
+	ind := strings.LastIndex(name, "::")
+	if ind < 0 {
+		return "", name
+	}
+	return strings.TrimRight(name[:ind], ":"), name[ind+2:]
+}
+
+// There's only the global namespace, so current and parent are
+// trivial.
+func nsParent(i *Interp, args []*TclObj) TclStatus {
+	if len(args) > 1 {
+		return i.FailStr("wrong # args: should be \"namespace parent ?name?\"")
+	}
+	if len(args) == 1 && args[0].AsString() != "::" {
+		return i.FailStr("namespace \"" + args[0].AsString() + "\" not found")
+	}
+	return i.Return(kNil)
+}
+
+var namespaceEn = ensembleSpec{
+	"ensemble": nsEnsembleEn.makeCmd(),
+	"current":  func(*Interp) *TclObj { return FromStr("::") },
+	"parent":   nsParent,
+	"qualifiers": func(name string) string {
+		q, _ := splitQualified(name)
+		return q
+	},
+	"tail": func(name string) string {
+		_, t := splitQualified(name)
+		return t
+	},
+}
+
+// tcl::prefix match ?-exact? ?-message desc? ?-error opts? table string
+//
+// With -error, opts is a list of return options used instead of the
+// default error; only -errorcode is honored. An empty opts makes a
+// failed match return the empty string rather than fail.
+func prefixMatch(i *Interp, args []*TclObj) TclStatus {
+	desc, exact := "option", false
+	var errOpts []*TclObj
+	raiseErr := true
+	for len(args) > 2 {
+		switch args[0].AsString() {
+		case "-exact":
+			exact = true
+			args = args[1:]
+			continue
+		case "-message":
+			desc = args[1].AsString()
+		case "-error":
+			opts, e := args[1].AsList()
+			if e != nil {
+				return i.Fail(e)
+			}
+			if len(opts)%2 != 0 {
+				return i.FailStr("error options must have an even number of elements")
+			}
+			errOpts, raiseErr = opts, len(opts) != 0
+		default:
+			return i.FailStr("bad option \"" + args[0].AsString() + "\": must be -error, -exact, or -message")
+		}
+		args = args[2:]
+	}
+	if len(args) != 2 {
+		return i.FailStr("wrong # args: should be \"tcl::prefix match ?options? table string\"")
+	}
+	tl, e := args[0].AsList()
+	if e != nil {
+		return i.Fail(e)
+	}
+	table := make([]string, len(tl))
+	for ind, t := range tl {
+		table[ind] = t.AsString()
+	}
+	s := args[1].AsString()
+	m := prefixMatches(table, s)
+	if len(m) == 1 && (m[0] == s || (!exact && s != "")) {
+		return i.Return(FromStr(m[0]))
+	}
+	if !raiseErr {
+		return i.Return(kNil)
+	}
+	what := "bad"
+	if len(m) > 1 && !exact {
+		what = "ambiguous"
+	}
+	msg := fmt.Sprintf("%s %s \"%s\": must be %s", what, desc, s,
+		formatNames(append([]string(nil), table...)))
+	for ind := 0; ind < len(errOpts); ind += 2 {
+		if errOpts[ind].AsString() == "-errorcode" {
+			return i.Fail(&codedError{errOpts[ind+1], msg})
+		}
+	}
+	return i.FailStr(msg)
+}
+
+var prefixEn = ensembleSpec{
+	"match": prefixMatch,
+}
+
+func init() {
+	RegisterDefaultCmd("namespace", namespaceEn.makeCmd())
+	RegisterDefaultCmd("tcl::prefix", prefixEn.makeCmd())
+}
@@ -1,10 +1,16 @@
 package gotcl
 
 import (
+	"context"
 	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestFull(t *testing.T) {
@@ -18,6 +24,1101 @@ func TestFull(t *testing.T) {
 	}
 }
 
+func TestAbbrev(t *testing.T) {
+	it := NewInterp()
+	if _, e := it.EvalString("llen {a b}"); e == nil {
+		t.Fatal("abbreviation should be off by default")
+	}
+	it.SetAllowAbbrev(true)
+	v, e := it.EvalString("llen {a b}")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if v.AsString() != "2" {
+		t.Fatalf("llen {a b}: expected 2, got %s", v.AsString())
+	}
+	if _, e := it.EvalString("l {a b}"); e == nil {
+		t.Fatal("expected ambiguous command error")
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	it := NewInterp()
+	_, e := it.EvalString("proc f {n} { f [+ $n 1] }; f 0")
+	if e == nil || !strings.Contains(e.Error(), "too many nested evaluations") {
+		t.Fatalf("expected nesting error, got %v", e)
+	}
+	it.SetMaxDepth(10)
+	it.EvalString("proc g {n} { if {$n == 0} { return 0 }; g [- $n 1] }")
+	if _, e := it.EvalString("g 5"); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := it.EvalString("g 20"); e == nil {
+		t.Fatal("expected g 20 to exceed a depth of 10")
+	}
+	if _, e := it.EvalString("g 5"); e != nil {
+		t.Fatalf("depth not restored after failure: %v", e)
+	}
+	for _, script := range []string{
+		"set s {eval $s}; eval $s",
+		"proc r {} { eval [list r] }; r",
+		"interp alias {} a {} a; a",
+		"interp alias {} b {} c; interp alias {} c {} b; b",
+	} {
+		it := NewInterp()
+		_, e := it.EvalString(script)
+		if e == nil || !strings.Contains(e.Error(), "too many nested evaluations") {
+			t.Errorf("%s: expected nesting error, got %v", script, e)
+		}
+	}
+}
+
+func TestAssert(t *testing.T) {
+	it := NewInterp()
+	it.SetSource("check.tcl")
+	if _, e := it.EvalString("set x 3; assert {$x == 3}"); e != nil {
+		t.Fatal(e)
+	}
+	_, e := it.EvalString("set y 4\nassert {$x + $y == 8 && $x > 0} sum")
+	if e == nil {
+		t.Fatal("expected assertion failure")
+	}
+	want := `sum: assertion "$x + $y == 8 && $x > 0" failed at check.tcl:2:8 ($x is 3, $y is 4)`
+	if e.Error() != want {
+		t.Fatalf("got %q, want %q", e.Error(), want)
+	}
+	if _, e := it.EvalString("assert {$nosuch}"); e == nil {
+		t.Fatal("expected an error for a missing variable")
+	}
+}
+
+func TestTclTest(t *testing.T) {
+	it := NewInterp()
+	var out strings.Builder
+	it.chans["stdout"] = newChan(nil, &out, nil, "none")
+	it.SetSource("suite.tcl")
+	_, e := it.EvalString(`
+		test add-1 {adding} -body { + 1 2 } -result 3
+		test add-2 {wrong result} -body { + 1 2 } -result 4
+		test err-1 {expected error} -body { error boom } -returnCodes error -result boom
+		test err-2 {unexpected error} -body { error boom } -result {}
+		test glob-1 {glob match} -setup { set v hello } -body { set v } -match glob -result h*o
+		test setup-1 {failed setup} -setup { error nope } -body { set ran 1 } -result 1
+	`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if c := it.TestCounts(); c.Passed != 3 || c.Failed != 3 {
+		t.Fatalf("expected 3 passed and 3 failed, got %+v", c)
+	}
+	if _, e := it.GetVarRaw("ran"); e == nil {
+		t.Error("the body ran after its setup failed")
+	}
+	if _, e := it.EvalString("cleanupTests"); e != nil {
+		t.Fatal(e)
+	}
+	if c := it.TestCounts(); c.Passed != 0 || c.Failed != 0 {
+		t.Fatalf("expected cleanupTests to reset the counts, got %+v", c)
+	}
+	s := out.String()
+	for _, want := range []string{
+		"==== add-2 wrong result FAILED",
+		"---- Result was:\n3\n",
+		"==== err-2 unexpected error FAILED",
+		"---- Return code was: error",
+		"==== setup-1 failed setup FAILED",
+		"Test setup failed:\nnope",
+		"suite.tcl:\tTotal\t6\tPassed\t3\tSkipped\t0\tFailed\t3\n",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("output is missing %q:\n%s", want, s)
+		}
+	}
+	if strings.Contains(s, "add-1") {
+		t.Errorf("passing tests shouldn't be reported:\n%s", s)
+	}
+}
+
+func TestTclTestClosedStdout(t *testing.T) {
+	it := NewInterp()
+	it.chans["stdout"] = newChan(nil, &strings.Builder{}, nil, "none")
+	for _, s := range []string{"test a b -body {+ 1 2} -result 4", "cleanupTests"} {
+		if _, e := it.EvalString("catch { close stdout }; " + s); e == nil || !strings.Contains(e.Error(), "stdout") {
+			t.Errorf("%q: expected an error about stdout, got %v", s, e)
+		}
+	}
+}
+
+func TestSleepCancel(t *testing.T) {
+	it := NewInterp()
+	if _, e := it.EvalString("sleep 1"); e != nil {
+		t.Fatal(e)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	it.SetContext(ctx)
+	start := time.Now()
+	_, e := it.EvalString("sleep 10000; set x 1")
+	if e == nil || !strings.Contains(e.Error(), "cancelled") {
+		t.Fatalf("expected cancellation, got %v", e)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("sleep wasn't interrupted")
+	}
+	if _, e := it.EvalString("set x 1"); e == nil {
+		t.Fatal("a cancelled interpreter shouldn't keep evaluating")
+	}
+}
+
+func TestPid(t *testing.T) {
+	it := NewInterp()
+	v, e := it.EvalString("pid")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if n, e := v.AsInt(); e != nil || n != os.Getpid() {
+		t.Fatalf("pid: expected %d, got %s", os.Getpid(), v.AsString())
+	}
+	v, e = it.EvalString("pid stdout")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if v.AsString() != "" {
+		t.Fatalf("pid stdout: expected no pids, got %q", v.AsString())
+	}
+	if _, e := it.EvalString("pid nosuchchan"); e == nil {
+		t.Fatal("expected an error for an unknown channel")
+	}
+}
+
+func TestExit(t *testing.T) {
+	dir, e := ioutil.TempDir("", "gotcl")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "out.txt")
+
+	it := NewInterp()
+	code := -1
+	it.SetExitHandler(func(c int) { code = c })
+	it.SetVarRaw("fname", FromStr(fname))
+	_, e = it.EvalString(`
+		set f [open $fname w]
+		puts -nonewline $f buffered
+		catch { exit 3 }
+		set after 1
+	`)
+	if ee, ok := e.(*ExitError); !ok || ee.Code != 3 {
+		t.Fatalf("expected exit 3 to unwind through catch, got %v", e)
+	}
+	if code != 3 {
+		t.Fatalf("exit handler got %d, want 3", code)
+	}
+	if _, e := it.GetVarRaw("after"); e == nil {
+		t.Fatal("evaluation continued after exit")
+	}
+	data, e := ioutil.ReadFile(fname)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if string(data) != "buffered" {
+		t.Fatalf("channel wasn't flushed on exit, file has %q", data)
+	}
+}
+
+// TestExitUnwinds checks that nothing that catches or converts errors
+// stops an exit.
+func TestExitUnwinds(t *testing.T) {
+	scripts := []string{
+		"proc ex {args} { exit 3 }; set x 1; trace add variable x write ex; set x 2",
+		"proc ex {args} { exit 3 }; set x 1; trace add variable x read ex; set x",
+		"proc ex {args} { exit 3 }; set x 1; trace add variable x unset ex; unset x",
+		"proc ex {args} { exit 3 }; tcl::var::reflect v ex; set v",
+		"proc ex {args} { exit 3 }; lsort -command ex {a b}",
+		"proc p {} { defer { exit 3 }; error oops }; p",
+		"generator g { exit 3 }; g",
+		"generator g { catch { yield 1 }; exit 3 }; g; g close",
+		"proc ex {args} { exit 3 }; proc p {} {}; trace add command p delete ex; rename p {}",
+		"proc ex {args} { exit 3 }; proc p {} {}; trace add command p rename ex; rename p q",
+		"proc ex {args} { exit 3 }; generator g { error boom }; trace add command g delete ex; g",
+		"test t {} -setup { exit 3 } -body {}",
+		"test t {} -body { exit 3 }",
+		"test t {} -body {} -cleanup { exit 3 }",
+	}
+	for _, s := range scripts {
+		for _, suspendable := range []bool{false, true} {
+			it := NewInterp()
+			it.SetExitHandler(func(int) {})
+			var e error
+			if suspendable {
+				_, e = it.RunSuspendable(strings.NewReader("catch { " + s + " }; set after 1"))
+			} else {
+				_, e = it.Run(strings.NewReader("catch { " + s + " }; set after 1"))
+			}
+			if ee, ok := e.(*ExitError); !ok || ee.Code != 3 {
+				t.Errorf("%q: expected exit 3, got %v", s, e)
+			}
+		}
+	}
+}
+
+func TestInfoScript(t *testing.T) {
+	dir, e := ioutil.TempDir("", "gotcl")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "inner.tcl")
+	if e := ioutil.WriteFile(fname, []byte("set inside [info script]\n"), 0666); e != nil {
+		t.Fatal(e)
+	}
+
+	it := NewInterp()
+	it.SetVarRaw("fname", FromStr(fname))
+	v, e := it.EvalString("source $fname; list $inside [info script]")
+	if e != nil {
+		t.Fatal(e)
+	}
+	l, _ := v.AsList()
+	if l[0].AsString() != fname || l[1].AsString() != "" {
+		t.Fatalf("expected {%s {}}, got %s", fname, v.AsString())
+	}
+	v, e = it.EvalString("info nameofexecutable")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if v.AsString() != os.Args[0] {
+		t.Fatalf("info nameofexecutable: expected %q, got %q", os.Args[0], v.AsString())
+	}
+}
+
+func TestStepHook(t *testing.T) {
+	it := NewInterp()
+	var seen []string
+	it.SetStepHook(func(cmd, where string) bool {
+		seen = append(seen, cmd)
+		return cmd != "breakpoint"
+	})
+	_, e := it.EvalString("set x 1\nset y 2\nbreakpoint\nset x 3")
+	if e == nil {
+		t.Fatal("expected evaluation to be aborted")
+	}
+	if len(seen) != 3 || seen[1] != "set y 2" {
+		t.Fatalf("unexpected steps: %#v", seen)
+	}
+	if v, _ := it.GetVarRaw("x"); v.AsString() != "1" {
+		t.Fatalf("command after abort was evaluated: x = %s", v.AsString())
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	it := NewInterp()
+	it.SetSource("cov.tcl")
+	it.EnableCoverage()
+	RunString(it, `set x 0
+for {set i 0} {$i < 3} {incr i} {
+    incr x
+}
+if {$x > 10} {
+    set x big
+}`)
+	cov := it.Coverage()
+	if cov["cov.tcl:1:1"] != 1 {
+		t.Errorf("expected first command to run once, got %#v", cov)
+	}
+	if cov["cov.tcl:3:5"] != 3 {
+		t.Errorf("expected loop body to run 3 times, got %#v", cov)
+	}
+	if _, ok := cov["cov.tcl:6:5"]; ok {
+		t.Errorf("dead branch recorded as covered: %#v", cov)
+	}
+}
+
+func TestChanCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	it := NewInterp()
+	it.SetVarRaw("path", FromStr(filepath.Join(dir, "out.txt")))
+	v, e := it.EvalString(`
+set f [open $path w]
+if {[chan configure $f -buffering] ne "full"} { error "files should be fully buffered" }
+chan puts $f "line one"
+puts -nonewline $f "line two"
+chan close $f
+set f [open $path]
+set first [chan gets $f]
+set rest [read $f]
+set res [list $first $rest [chan eof $f]]
+close $f
+set res`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if v.AsString() != "{line one} {line two} 1" {
+		t.Fatalf("unexpected result: %s", v.AsString())
+	}
+	if _, e := it.EvalString("chan gets $f"); e == nil {
+		t.Fatal("expected error using a closed channel")
+	}
+}
+
+func TestChanTransform(t *testing.T) {
+	it := NewInterp()
+	var out strings.Builder
+	it.chans["stdout"] = newChan(nil, &out, nil, "none")
+	v, e := it.EvalString(`
+proc upper {op ch args} {
+    if {$op eq "initialize"} { return {initialize write finalize} }
+    if {$op eq "write"} { return [string toupper [lindex $args 0]] }
+    lappend ::log "$op $ch"
+}
+# Holds everything back until flushed, then writes it bracketed.
+proc hold {op ch args} {
+    if {$op eq "initialize"} { return {initialize write flush} }
+    if {$op eq "write"} {
+        set ::held "$::held[lindex $args 0]"
+        return {}
+    }
+    if {$::held eq ""} { return {} }
+    set r "<$::held>"
+    set ::held {}
+    return $r
+}
+proc failing {op ch args} {
+    if {$op eq "initialize"} { return {initialize write} }
+    error "cannot transform"
+}
+set log {}
+set held {}
+puts plain
+chan push stdout upper
+puts shout
+chan push stdout hold
+puts -nonewline a
+puts -nonewline b
+flush stdout
+chan pop stdout
+chan pop stdout
+puts done
+chan push stdout failing
+set rc [catch { puts x } msg]
+chan pop stdout
+list $log $rc $msg [catch { chan pop stdout }]`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if want := "{{finalize stdout}} 1 {cannot transform} 1"; v.AsString() != want {
+		t.Fatalf("expected %q, got %q", want, v.AsString())
+	}
+	if want := "plain\nSHOUT\n<AB>done\n"; out.String() != want {
+		t.Fatalf("expected output %q, got %q", want, out.String())
+	}
+	if _, e := it.EvalString("chan push stdout {upper x}; puts y"); e == nil {
+		t.Fatal("expected an error from a transform that doesn't support write")
+	}
+}
+
+// TestChanTransformKeepsStack checks that a transform can't close or
+// pop its channel while another transform is stacked on it.
+func TestChanTransformKeepsStack(t *testing.T) {
+	it := NewInterp()
+	var out strings.Builder
+	it.chans["stdout"] = newChan(nil, &out, nil, "none")
+	v, e := it.EvalString(`
+proc t0 {op ch args} {
+    if {$op eq "initialize"} { return {initialize write} }
+    return [lindex $args 0]
+}
+proc t1 {op ch args} {
+    if {$op eq "initialize"} { return {initialize write} }
+    lappend ::errs [catch { close $ch } msg] $msg [catch { chan pop $ch }]
+    return [lindex $args 0]
+}
+set errs {}
+chan push stdout t0
+chan push stdout t1
+puts y
+chan pop stdout
+chan pop stdout
+set errs`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if want := "1 {can't close channel \"stdout\" while its transforms are running} 1"; v.AsString() != want {
+		t.Fatalf("expected %q, got %q", want, v.AsString())
+	}
+	if out.String() != "y\n" {
+		t.Fatalf("expected output \"y\\n\", got %q", out.String())
+	}
+}
+
+func TestChanForeach(t *testing.T) {
+	it := NewInterp()
+	it.chans["in"] = newChan(strings.NewReader("one\ntwo\r\nskip\nthree\nfour\nfive"), nil, nil, "none")
+	v, e := it.EvalString(`
+set seen {}
+chan foreach line in {
+    if {$line eq "skip"} { continue }
+    lappend seen $line
+    if {$line eq "three"} { break }
+}
+set next [gets in]
+chan foreach line in { lappend seen $line }
+list $seen $next [eof in] [catch { chan foreach line nosuch {} }]`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if want := "{one two three five} four 1 1"; v.AsString() != want {
+		t.Errorf("expected %q, got %q", want, v.AsString())
+	}
+}
+
+func TestRunSuspendable(t *testing.T) {
+	it := NewInterp()
+	v, e := it.RunSuspendable(strings.NewReader(`
+proc fetch n { return [yieldToHost $n] }
+set total 0
+foreach n {1 2 3} { incr total [fetch $n] }
+set total`))
+	var asked []string
+	for {
+		s, ok := e.(*Suspended)
+		if !ok {
+			break
+		}
+		asked = append(asked, s.Value.AsString())
+		n, _ := s.Value.AsInt()
+		v, e = s.Resume(FromInt(n * 10))
+	}
+	if e != nil {
+		t.Fatal(e)
+	}
+	if got := strings.Join(asked, " "); got != "1 2 3" {
+		t.Errorf("expected yields 1 2 3, got %s", got)
+	}
+	if v.AsString() != "60" {
+		t.Errorf("expected 60, got %s", v.AsString())
+	}
+
+	// The interpreter is usable as before once the script finishes.
+	if _, e := it.EvalString("yieldToHost"); e == nil {
+		t.Errorf("yieldToHost outside RunSuspendable succeeded")
+	}
+
+	_, e = it.RunSuspendable(strings.NewReader(`
+set log {}
+catch { yieldToHost first } msg
+lappend log $msg
+catch { yieldToHost second }
+lappend log done`))
+	s, ok := e.(*Suspended)
+	if !ok {
+		t.Fatalf("expected to be suspended, got %v", e)
+	}
+	v, e = s.Cancel()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if want := "{script cancelled by the host} done"; v.AsString() != want {
+		t.Errorf("expected %q, got %q", want, v.AsString())
+	}
+	if _, e := s.Resume(nil); e == nil {
+		t.Errorf("resumed a cancelled script")
+	}
+}
+
+func TestGoDefinesProcs(t *testing.T) {
+	it := NewInterp()
+	v, e := it.EvalString(`
+set ch [newchan]
+go [list apply {ch {
+    proc fromgo {} { return hi }
+    trace add command fromgo delete {set x}
+    sendchan $ch [fromgo]
+}} $ch]
+<- $ch`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if v.AsString() != "hi" {
+		t.Errorf("expected hi, got %s", v.AsString())
+	}
+}
+
+func TestChanNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	it := NewInterp()
+	it.SetVarRaw("path", FromStr(filepath.Join(dir, "out.txt")))
+	v, e := it.EvalString(`
+set f [open $path w]
+set open [expr {[lsearch [chan names] $f] >= 0}]
+set std [lsort [chan names std*]]
+set same [expr {[lsort [chan names]] eq [lsort [file channels]]}]
+foreach ch [chan names file*] { close $ch }
+list $open $std $same [chan names file*]`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if want := "1 {stderr stdin stdout} 1 {}"; v.AsString() != want {
+		t.Errorf("expected %q, got %q", want, v.AsString())
+	}
+}
+
+func TestOpenPipe(t *testing.T) {
+	if _, e := exec.LookPath("sh"); e != nil {
+		t.Skip("no sh to run")
+	}
+	dir, err := ioutil.TempDir("", "gotcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "out.txt")
+	it := NewInterp()
+	it.SetVarRaw("path", FromStr(fname))
+	v, e := it.EvalString(`
+set f [open "|echo hello world"]
+set got [list [gets $f] [llength [pid $f]]]
+close $f
+set f [open "|printf {b\na\n} | sort"]
+lappend got [read -nonewline $f] [llength [pid $f]]
+close $f
+set f [open [list |sh -c "tr a-z A-Z > $path"] w]
+puts $f shouted
+close $f
+set f [open "|cat" r+]
+puts $f "round trip"
+flush $f
+lappend got [gets $f]
+close $f
+set f [open "|sh -c {exit 3}"]
+lappend got [catch { close $f } msg] $msg [lindex $errorCode 0] [lindex $errorCode 2]
+lappend got [catch { open "|sh" a }] [catch { open "|echo |" }]
+lappend got [catch { open "|no-such-command-here" }]
+lappend got [catch { open "|sh -c {while :; do echo y; done} | no-such-command-here" }]`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	want := "{hello world} 1 {a\nb} 2 {round trip} 1 {child process exited abnormally} CHILDSTATUS 3 1 1 1 1"
+	if v.AsString() != want {
+		t.Fatalf("expected %q, got %q", want, v.AsString())
+	}
+	if data, _ := ioutil.ReadFile(fname); string(data) != "SHOUTED\n" {
+		t.Fatalf("expected the pipeline to write SHOUTED, got %q", data)
+	}
+}
+
+func TestReadNonewline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "conf.txt")
+	if e := ioutil.WriteFile(fname, []byte("a b\nc d\n\n"), 0666); e != nil {
+		t.Fatal(e)
+	}
+	it := NewInterp()
+	it.SetVarRaw("path", FromStr(fname))
+	v, e := it.EvalString(`
+set f [open $path]
+set whole [read -nonewline $f]
+close $f
+list $whole [readfile $path] [readfile -nonewline $path]`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	want := "{a b\nc d\n} {a b\nc d\n\n} {a b\nc d\n}"
+	if v.AsString() != want {
+		t.Fatalf("expected %q, got %q", want, v.AsString())
+	}
+	if _, e := it.EvalString("readfile " + filepath.Join(dir, "missing")); e == nil {
+		t.Fatal("expected error reading a missing file")
+	}
+}
+
+func TestGetsLineEndings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, nl := range []string{"\n", "\r\n", "\r"} {
+		fname := filepath.Join(dir, "lines.txt")
+		data := "one" + nl + nl + "three" + nl + "four"
+		if e := ioutil.WriteFile(fname, []byte(data), 0666); e != nil {
+			t.Fatal(e)
+		}
+		it := NewInterp()
+		it.SetVarRaw("path", FromStr(fname))
+		v, e := it.EvalString(`
+set f [open $path]
+set lines {}
+while {[gets $f line] >= 0} { lappend lines $line }
+close $f
+set lines`)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if v.AsString() != "one {} three four" {
+			t.Errorf("%q endings: unexpected lines %q", nl, v.AsString())
+		}
+	}
+}
+
+// TestGetsCRDoesntBlock checks that gets returns a line ending in "\r"
+// without waiting to see whether a "\n" follows.
+func TestGetsCRDoesntBlock(t *testing.T) {
+	r, w := io.Pipe()
+	it := NewInterp()
+	it.chans["in"] = newChan(r, nil, nil, "none")
+	go w.Write([]byte("one\r"))
+	done := make(chan *TclObj)
+	go func() {
+		v, _ := it.EvalString("gets in")
+		done <- v
+	}()
+	select {
+	case v := <-done:
+		if v.AsString() != "one" {
+			t.Errorf("expected \"one\", got %q", v.AsString())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("gets waited for more input after \"\\r\"")
+	}
+	go func() {
+		w.Write([]byte("\ntwo\n"))
+		w.Close()
+	}()
+	if v, e := it.EvalString("list [gets in] [gets in] [eof in]"); e != nil || v.AsString() != "two {} 1" {
+		t.Errorf("expected the \"\\n\" after \"\\r\" to be skipped, got %v, %v", v, e)
+	}
+}
+
+func TestListBuilder(t *testing.T) {
+	b := NewListBuilder()
+	for n := 0; n < 1000; n++ {
+		b.Append(FromInt(n), FromStr("row "+FromInt(n).AsString()))
+	}
+	if b.Len() != 2000 {
+		t.Fatalf("expected 2000 elements, got %d", b.Len())
+	}
+	it := NewInterp()
+	it.SetVarRaw("rows", b.Build())
+	v, e := it.EvalString("list [llength $rows] [lindex $rows 999]")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if v.AsString() != "2000 {row 499}" {
+		t.Fatalf("unexpected result %q", v.AsString())
+	}
+	if b.Len() != 0 || b.Build().AsString() != "" {
+		t.Fatal("Build should reset the builder")
+	}
+}
+
+func TestEvalStatus(t *testing.T) {
+	it := NewInterp()
+	for _, c := range []struct {
+		code   string
+		status TclStatus
+		result string
+	}{
+		{"set x 5", TclOK, "5"},
+		{"set x 6; break", TclBreak, "6"},
+		{"set x 7; continue", TclContinue, "7"},
+		{"return 8", TclReturn, "8"},
+	} {
+		v, rc := it.Eval(c.code)
+		if rc != c.status || v.AsString() != c.result {
+			t.Errorf("%s: got %q with status %d", c.code, v.AsString(), rc)
+		}
+	}
+	if v, rc := it.Eval("error boom"); v != nil || rc != TclErr || it.Err().Error() != "boom" {
+		t.Errorf("error boom: got %v, %d, %v", v, rc, it.Err())
+	}
+	if _, rc := it.Eval("set x {"); rc != TclErr || it.Err() == nil {
+		t.Errorf("expected a parse error, got status %d", rc)
+	}
+}
+
+func TestFrameVars(t *testing.T) {
+	it := NewInterp()
+	var vars map[string]*TclObj
+	var arrs map[string]map[string]*TclObj
+	it.SetCmd("snapshot", func(i *Interp, args []*TclObj) TclStatus {
+		vars, arrs = i.FrameVars(), i.FrameArrays()
+		return i.Return(kNil)
+	})
+	_, e := it.EvalString(`
+set g 1
+set arr(k) v
+proc f {x} {
+    upvar 1 g g
+    upvar 1 arr a
+    set y 2
+    snapshot
+    set y 3
+}
+f 0`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(vars) != 3 || vars["x"].AsString() != "0" || vars["y"].AsString() != "2" || vars["g"].AsString() != "1" {
+		t.Errorf("unexpected vars %v", vars)
+	}
+	if len(arrs) != 1 || arrs["a"]["k"].AsString() != "v" {
+		t.Errorf("unexpected arrays %v", arrs)
+	}
+}
+
+func TestBinaryRawBytes(t *testing.T) {
+	it := NewInterp()
+	v, e := it.EvalString("binary decode hex 00ff80")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if v.AsString() != "\x00\xff\x80" {
+		t.Fatalf("expected raw bytes, got %q", v.AsString())
+	}
+	it.SetVarRaw("raw", v)
+	if v, e = it.EvalString("binary encode base64 $raw"); e != nil || v.AsString() != "AP+A" {
+		t.Fatalf("expected AP+A, got %v, %v", v, e)
+	}
+}
+
+func TestRedefineHook(t *testing.T) {
+	var redefined []string
+	it := NewInterp()
+	it.SetRedefineHook(func(name string) { redefined = append(redefined, name) })
+	_, e := it.EvalString(`
+proc mine {} {}
+proc mine {} { return 1 }
+proc set {args} {}
+rename mine other
+proc fresh {} {}
+rename fresh other`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if got := strings.Join(redefined, " "); got != "mine set other" {
+		t.Errorf("expected hooks for mine, set and other, got %q", got)
+	}
+	it.SetRedefineHook(nil)
+	it.SetCmd("other", nil)
+	it.SetCmd("puts", func(i *Interp, args []*TclObj) TclStatus { return i.Return(kNil) })
+	if len(redefined) != 3 {
+		t.Error("hook called after being removed")
+	}
+}
+
+func TestCommandTraceSetCmd(t *testing.T) {
+	it := NewInterp()
+	_, e := it.EvalString(`
+set log {}
+proc gone {} {}
+trace add command gone delete [list lappend log]`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	it.SetCmd("gone", nil)
+	v, e := it.GetVarRaw("log")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if got := v.AsString(); got != "gone {} delete" {
+		t.Errorf("expected delete trace to fire, got %q", got)
+	}
+}
+
+func TestIntOverflowMode(t *testing.T) {
+	it := NewInterp()
+	for _, c := range []struct {
+		mode IntOverflowMode
+		want string
+	}{
+		{IntOverflowWrap, "-9223372036854775808"},
+		{IntOverflowPromote, "9.223372036854776e+18"},
+		{IntOverflowError, "error"},
+	} {
+		it.SetIntOverflowMode(c.mode)
+		got := "error"
+		v, e := it.EvalString("expr {2**63}")
+		if e == nil {
+			got = v.AsString()
+		} else if e.Error() != "integer value too large to represent" {
+			t.Errorf("mode %d: unexpected error %v", c.mode, e)
+		}
+		if got != c.want {
+			t.Errorf("mode %d: expected %s, got %s", c.mode, c.want, got)
+		}
+		if v, e := it.EvalString("expr {abs(-9223372036854775808)}"); c.mode != IntOverflowError && (e != nil || v.AsString() != c.want) {
+			t.Errorf("mode %d: expected abs to give %s, got %v, %v", c.mode, c.want, v, e)
+		}
+		if v, e := it.EvalString("expr {2**62 + 2**61}"); e != nil || v.AsString() != "6917529027641081856" {
+			t.Errorf("mode %d: in-range arithmetic gave %v, %v", c.mode, v, e)
+		}
+	}
+	it.SetIntOverflowMode(IntOverflowError)
+	for _, s := range []string{"expr {9223372036854775807 + 1}", "expr {-9223372036854775807 - 2}",
+		"expr {3037000500 * 3037000500}", "expr {-9223372036854775808 / -1}", "* 4611686018427387904 2",
+		"expr {abs(-9223372036854775808)}"} {
+		if _, e := it.EvalString(s); e == nil {
+			t.Errorf("%s: expected an overflow", s)
+		}
+	}
+	if v, _ := it.GetVarRaw("errorCode"); v.AsString() != "ARITH IOVERFLOW {integer value too large to represent}" {
+		t.Errorf("unexpected errorCode %q", v.AsString())
+	}
+}
+
+func TestByteLen(t *testing.T) {
+	for _, c := range []struct {
+		obj          *TclObj
+		runes, bytes int
+	}{
+		{FromStr(""), 0, 0},
+		{FromStr("abc"), 3, 3},
+		{FromStr("héllo 世界"), 8, 13},
+		{FromInt(-42), 3, 3},
+		{FromList([]string{"é", "b"}), 3, 4},
+	} {
+		if n := c.obj.Len(); n != c.runes {
+			t.Errorf("Len(%q) = %d, expected %d", c.obj.AsString(), n, c.runes)
+		}
+		if n := c.obj.ByteLen(); n != c.bytes {
+			t.Errorf("ByteLen(%q) = %d, expected %d", c.obj.AsString(), n, c.bytes)
+		}
+	}
+}
+
+func TestIntBoundaries(t *testing.T) {
+	if strconv.IntSize < 64 {
+		t.Skip("integers are narrower than 64 bits")
+	}
+	maxInt := -(minInt + 1)
+	it := NewInterp()
+	for _, n := range []int{maxInt, minInt, maxInt - 1, minInt + 1, 1 << 31, -1<<31 - 1, 1 << 53, 1<<53 + 1, -1, 0} {
+		want := strconv.FormatInt(int64(n), 10)
+		if s := FromInt(n).AsString(); s != want {
+			t.Errorf("FromInt(%d).AsString() = %q", n, s)
+		}
+		v, e := it.EvalString("expr {" + want + " + 0}")
+		if e != nil {
+			t.Errorf("expr of %d: %v", n, e)
+			continue
+		}
+		if s := v.AsString(); s != want {
+			t.Errorf("expr of %d gives %q", n, s)
+		}
+		if back, e := FromStr(v.AsString()).AsInt(); e != nil || back != n {
+			t.Errorf("%d round trips to %d (%v)", n, back, e)
+		}
+		hex := "0x" + strconv.FormatUint(uint64(n), 16)
+		if n < 0 {
+			hex = "-0x" + strconv.FormatUint(uint64(-n), 16)
+		}
+		if back, e := FromStr(hex).AsInt(); e != nil || back != n {
+			t.Errorf("%s parses as %d (%v)", hex, back, e)
+		}
+	}
+	if _, e := FromStr("0x8000000000000000").AsInt(); e == nil {
+		t.Errorf("0x8000000000000000 parsed as an integer")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	for _, c := range []struct {
+		a, b  *TclObj
+		equal bool
+	}{
+		{FromInt(5), FromStr("5"), true},
+		{FromStr("0x10"), FromInt(16), true},
+		{FromStr("1.0"), FromInt(1), true},
+		{FromFloat(0.5), FromStr("0.50"), true},
+		{FromStr("-0.0"), FromInt(0), true},
+		{FromStr("abc"), FromStr("abc"), true},
+		{FromList([]string{"a", "b c"}), FromStr("a {b c}"), true},
+		{FromInt(5), FromStr("5 "), true},
+		{FromInt(5), FromInt(6), false},
+		{FromStr("abc"), FromStr("ABC"), false},
+		{FromStr("5"), FromStr("five"), false},
+		{FromInt(9007199254740993), FromFloat(9007199254740992), false},
+		{FromStr("NaN"), FromStr("NaN"), false},
+	} {
+		if got := c.a.Equal(c.b); got != c.equal {
+			t.Errorf("%q.Equal(%q) = %v", c.a.AsString(), c.b.AsString(), got)
+		}
+		if got := c.b.Equal(c.a); got != c.equal {
+			t.Errorf("%q.Equal(%q) = %v", c.b.AsString(), c.a.AsString(), got)
+		}
+		if c.equal && c.a.HashKey() != c.b.HashKey() {
+			t.Errorf("%q and %q are equal but have keys %q and %q", c.a.AsString(), c.b.AsString(), c.a.HashKey(), c.b.HashKey())
+		}
+	}
+	cache := map[string]int{FromInt(5).HashKey(): 1}
+	if cache[FromStr("5").HashKey()] != 1 {
+		t.Error("FromStr(\"5\") doesn't find FromInt(5) in a map")
+	}
+}
+
+func TestCommandCache(t *testing.T) {
+	it := NewInterp()
+	script := FromStr("which")
+	it.SetCmd("which", func(i *Interp, args []*TclObj) TclStatus { return i.Return(FromStr("first")) })
+	if it.EvalObj(script); it.retval.AsString() != "first" {
+		t.Fatalf("expected first, got %s", it.retval.AsString())
+	}
+	it.SetCmd("which", func(i *Interp, args []*TclObj) TclStatus { return i.Return(FromStr("second")) })
+	if it.EvalObj(script); it.retval.AsString() != "second" {
+		t.Errorf("redefinition not seen, got %s", it.retval.AsString())
+	}
+	// An interpreter sharing the commands sees changes made by the
+	// other, and one with its own commands doesn't use the cache.
+	shared := NewInterpFrom(it)
+	shared.SetCmd("which", func(i *Interp, args []*TclObj) TclStatus { return i.Return(FromStr("third")) })
+	if it.EvalObj(script); it.retval.AsString() != "third" {
+		t.Errorf("change by a sharing interpreter not seen, got %s", it.retval.AsString())
+	}
+	other := NewInterp()
+	if rc := other.EvalObj(script); rc != kTclErr {
+		t.Errorf("another interpreter found the cached command")
+	}
+	it.SetCmd("which", nil)
+	if rc := it.EvalObj(script); rc != kTclErr {
+		t.Errorf("deleted command still called")
+	}
+}
+
+func TestDup(t *testing.T) {
+	o := FromList([]string{"a", "b"})
+	if d := o.Dup(); d.AsString() != "a b" {
+		t.Errorf("expected a copy of \"a b\", got %q", d.AsString())
+	}
+	d := o.Dup()
+	d.setElem(0, FromStr("z"))
+	if l, _ := o.AsList(); l[0].AsString() != "a" {
+		t.Error("modifying the copy's list changed the original")
+	}
+	if d.AsString() != "z b" {
+		t.Errorf("expected the copy to read \"z b\" after changing its list, got %q", d.AsString())
+	}
+	if o.AsString() != "a b" {
+		t.Errorf("expected the original to still read \"a b\", got %q", o.AsString())
+	}
+	i := FromStr("5")
+	i.AsList()
+	i.AsInt()
+	di := i.Dup()
+	di.setElem(0, FromStr("6"))
+	if di.AsString() != "6" {
+		t.Errorf("expected a copy of a one-element list to follow its list, got %q", di.AsString())
+	}
+	s := FromStr("  a   {b}  ")
+	s.AsList()
+	if d := s.Dup(); d.AsString() != "  a   {b}  " {
+		t.Errorf("expected a copy to keep its string, got %q", d.AsString())
+	}
+	n := FromInt(7).Dup()
+	n.intval = 8
+	if FromInt(7).AsString() != "7" {
+		t.Error("a copy of a small integer aliases it")
+	}
+}
+
+func TestPrecisionIsShared(t *testing.T) {
+	a, b := NewInterp(), NewInterp()
+	defer a.EvalString("set tcl_precision 0")
+	if _, e := a.EvalString("set tcl_precision 3"); e != nil {
+		t.Fatal(e)
+	}
+	v, e := b.EvalString("list $tcl_precision [expr {1.0 / 3}]")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if v.AsString() != "3 0.333" {
+		t.Fatalf("expected another interpreter to see precision 3, got %q", v.AsString())
+	}
+}
+
+// TestSharedSmallInts uses the shared small integer objects from
+// several interpreters at once. Run it with -race to check that doing
+// so never writes to them.
+func TestSharedSmallInts(t *testing.T) {
+	done := make(chan error)
+	for g := 0; g < 4; g++ {
+		go func() {
+			it := NewInterp()
+			_, e := it.EvalString(`
+set v [expr {5 + 0}]
+llength $v
+string length $v
+expr $v
+catch { eval $v }
+catch { set $v }
+expr {[lindex $v 0] + 1}
+lindex [list $v] 0
+string equal [lindex $v 0] $v
+expr $v`)
+			done <- e
+		}()
+	}
+	for g := 0; g < 4; g++ {
+		if e := <-done; e != nil {
+			t.Error(e)
+		}
+	}
+	for n := range smallInts {
+		if o := &smallInts[n]; o.value == nil || o.listval == nil || o.cmdsval == nil || o.exprval == nil {
+			t.Fatalf("small integer %d isn't fully cached", n)
+		}
+		if o := &smallInts[n]; o.listval[0] != o || o.exprval.(*tliteral).tval != o {
+			t.Fatalf("small integer %d has nested objects of its own", n)
+		}
+	}
+	if l, err := kNil.AsList(); err != nil || len(l) != 0 {
+		t.Fatalf("empty string isn't an empty list: %v", l)
+	}
+}
+
+// TestSharedErrorCodes fails in the same ways from several
+// interpreters at once. Run it with -race to check that the errorCode
+// values they get aren't shared.
+func TestSharedErrorCodes(t *testing.T) {
+	done := make(chan error)
+	for g := 0; g < 4; g++ {
+		go func() {
+			it := NewInterp()
+			_, e := it.EvalString(`
+				for {set n 0} {$n < 50} {incr n} {
+					catch {error x}
+					lindex $::errorCode 0
+					string length $::errorCode
+					catch {expr {1/0}}
+					string length $::errorCode
+					lindex $::errorCode 1
+					catch {expr {round(1e19)}}
+					string length $::errorCode
+					lindex $::errorCode 1
+				}`)
+			done <- e
+		}()
+	}
+	for g := 0; g < 4; g++ {
+		if e := <-done; e != nil {
+			t.Error(e)
+		}
+	}
+}
+
 func RunString(it *Interp, s string) {
 	var r io.Reader = strings.NewReader(s)
 	_, e := it.Run(r)
@@ -86,6 +1187,26 @@ proc fib2 {n} {
 	runCmd(fib2, "fib2 70", b)
 }
 
+func Benchmark_EvalList(b *testing.B) {
+	runCmd("proc noop {args} {}", "foreach n {1 2 3 4 5 6 7 8} { eval [list noop $n {a b}] }", b)
+}
+
+func Benchmark_EvalString(b *testing.B) {
+	runCmd("proc noop {args} {}", `foreach n {1 2 3 4 5 6 7 8} { eval "noop $n {a b}" }`, b)
+}
+
+func Benchmark_ProcCall(b *testing.B) {
+	runCmd("proc noop {} {}", "noop; noop; noop; noop; noop; noop; noop; noop", b)
+}
+
+func Benchmark_StringEqual(b *testing.B) {
+	setup := `
+set words {}
+for {set k 0} {$k < 50} {incr k} { lappend words key$k $k }
+`
+	runCmd(setup, `foreach w $words { string equal $w key7; string compare $w 7; string equal $w $w }`, b)
+}
+
 func BenchmarkSumTo(b *testing.B) {
 	sumto := `
 proc sum_to {n} {
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/zyedidia/gotcl"
+)
+
+// RunRepl reads commands from in and passes each to fn, printing the
+// result to out. A command may span several lines, if it has unclosed
+// braces, brackets or quotes. Before reading each line, it calls
+// prompt, with cont set if the line continues a command.
+func RunRepl(in io.Reader, out io.Writer, prompt func(cont bool), fn func(string) (string, error)) {
+	inbuf := bufio.NewReader(in)
+	cmd := ""
+	for {
+		prompt(cmd != "")
+		ln, err := inbuf.ReadString('\n')
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintln(out, err.Error())
+			}
+			break
+		}
+		if len(ln) == 0 {
+			continue
+		}
+		if cmd += ln; !complete(cmd) {
+			continue
+		}
+		res, rerr := fn(cmd)
+		cmd = ""
+		if rerr != nil {
+			fmt.Fprintln(out, "Error: "+rerr.Error())
+		} else {
+			if len(res) != 0 {
+				fmt.Fprintln(out, res)
+			}
+		}
+	}
+}
+
+func RunTclRepl(in io.Reader, out io.Writer) {
+	i := gotcl.NewInterp()
+	i.SetAllowAbbrev(true)
+	setArgs(i, flag.Args(), true)
+	prompt := func(cont bool) { tclPrompt(i, out, cont) }
+	RunRepl(in, out, prompt, func(ln string) (string, error) {
+		res, e := i.EvalString(ln)
+		i.ClearError()
+		if e != nil {
+			return "", e
+		}
+		return res.AsString(), e
+	})
+}
+
+// complete reports whether s is a whole command, with no unclosed
+// braces, brackets or quotes and no backslash before its final
+// newline.
+func complete(s string) bool {
+	braces, brackets, quoted := 0, 0, false
+	for k := 0; k < len(s); k++ {
+		switch s[k] {
+		case '\\':
+			if k++; k == len(s)-1 && s[k] == '\n' {
+				return false
+			}
+		case '{':
+			if !quoted {
+				braces++
+			}
+		case '}':
+			if !quoted && braces > 0 {
+				braces--
+			}
+		case '[':
+			if braces == 0 {
+				brackets++
+			}
+		case ']':
+			if braces == 0 && brackets > 0 {
+				brackets--
+			}
+		case '"':
+			if braces == 0 {
+				quoted = !quoted
+			}
+		}
+	}
+	return braces == 0 && brackets == 0 && !quoted
+}
+
+// tclPrompt prints the prompt for the next line, by running the
+// script in tcl_prompt1, or in tcl_prompt2 for a line that continues
+// a command. If the variable isn't set, or the script fails, it
+// prints a default prompt instead: "> ", or nothing for a
+// continuation.
+func tclPrompt(i *gotcl.Interp, out io.Writer, cont bool) {
+	name, def := "tcl_prompt1", "> "
+	if cont {
+		name, def = "tcl_prompt2", ""
+	}
+	if script, e := i.GetVarRaw(name); e == nil {
+		_, e = i.EvalString(script.AsString())
+		if e == nil {
+			return
+		}
+		i.ClearError()
+		fmt.Fprintln(out, "Error in "+name+": "+e.Error())
+	}
+	fmt.Fprint(out, def)
+}
+
+func setArgs(i *gotcl.Interp, args []string, interactive bool) {
+	i.SetVarRaw("argc", gotcl.FromInt(len(args)-1))
+	if len(args) > 0 {
+		i.SetVarRaw("argv0", gotcl.FromStr(args[0]))
+		i.SetVarRaw("argv", gotcl.FromList(args[1:]))
+	} else {
+		i.SetVarRaw("argv", gotcl.FromList(args))
+	}
+	i.SetVarRaw("tcl_interactive", gotcl.FromBool(interactive))
+}
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 1 {
+		filename := args[0]
+		file, e := os.Open(filename)
+		if e != nil {
+			panic(e.Error())
+		}
+		defer file.Close()
+		i := gotcl.NewInterp()
+		setArgs(i, args, false)
+		_, err := i.Run(file)
+		if err != nil {
+			fmt.Println("Error: " + err.Error())
+		}
+	} else {
+		RunTclRepl(os.Stdin, os.Stdout)
+	}
+}
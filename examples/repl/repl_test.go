@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/zyedidia/gotcl"
+)
+
+// newPipedInterp returns an interpreter whose stdout channel writes to
+// the returned file, so that what a prompt script prints can be read
+// back once the file is closed.
+func newPipedInterp(t *testing.T) (*gotcl.Interp, *os.File, *os.File) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	return gotcl.NewInterp(), r, w
+}
+
+func TestPrompt(t *testing.T) {
+	i, r, w := newPipedInterp(t)
+	var out bytes.Buffer
+	tclPrompt(i, &out, false)
+	if out.String() != "> " {
+		t.Errorf("expected the default prompt, got %q", out.String())
+	}
+	out.Reset()
+	i.SetVarRaw("tcl_prompt1", gotcl.FromStr(`puts -nonewline "tcl% "`))
+	i.SetVarRaw("tcl_prompt2", gotcl.FromStr(`puts -nonewline "more> "`))
+	RunRepl(strings.NewReader("set x {a\nb}\nset y 1\n"), &out, func(cont bool) { tclPrompt(i, &out, cont) },
+		func(ln string) (string, error) {
+			res, e := i.EvalString(ln)
+			if e != nil {
+				return "", e
+			}
+			return res.AsString(), nil
+		})
+	w.Close()
+	printed, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(printed); got != "tcl% more> tcl% tcl% " {
+		t.Errorf("unexpected prompts %q", got)
+	}
+	if got := out.String(); got != "a\nb\n1\n" {
+		t.Errorf("unexpected output %q", got)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	for s, want := range map[string]bool{
+		"set x 1\n":         true,
+		"proc f {} {\n":     false,
+		"set x [list a\n":   false,
+		"set x \"a\n":       false,
+		"set x {\"}\n":      true,
+		"set x \"{\"\n":     true,
+		"set x 1 \\\n":      false,
+		"set x \\\\\n":      true,
+		"if 1 {\n} else {}": true,
+	} {
+		if got := complete(s); got != want {
+			t.Errorf("complete(%q) = %v, expected %v", s, got, want)
+		}
+	}
+}
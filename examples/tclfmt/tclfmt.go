@@ -0,0 +1,39 @@
+// tclfmt formats the Tcl scripts named on the command line, or
+// standard input if there are none, writing the result to standard
+// output.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/zyedidia/gotcl"
+)
+
+func format(src []byte) {
+	out, e := gotcl.FormatScript(string(src))
+	if e != nil {
+		fmt.Fprintln(os.Stderr, "Error: "+e.Error())
+		os.Exit(1)
+	}
+	fmt.Print(out)
+}
+
+func main() {
+	if len(os.Args) == 1 {
+		src, e := ioutil.ReadAll(os.Stdin)
+		if e != nil {
+			panic(e.Error())
+		}
+		format(src)
+		return
+	}
+	for _, filename := range os.Args[1:] {
+		src, e := ioutil.ReadFile(filename)
+		if e != nil {
+			panic(e.Error())
+		}
+		format(src)
+	}
+}
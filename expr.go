@@ -1,8 +1,12 @@
 package gotcl
 
 import (
+	"fmt"
 	"io"
+	"math"
 	"math/rand"
+	"strconv"
+	"strings"
 	"unicode"
 )
 
@@ -22,8 +26,10 @@ type unOpNode struct {
 }
 
 type funcNode struct {
-	name string
-	args []eterm
+	name    string
+	cmdname string
+	cmdobj  *TclObj // cmdname, the first of the call's words
+	args    []eterm
 }
 
 type exprFunc struct {
@@ -48,38 +54,197 @@ func binOpFold(op *binaryOp) TclCmd {
 }
 
 func randFn(i *Interp, args []*TclObj) TclStatus {
-	return i.Return(FromInt(rand.Int()))
+	if len(args) != 0 {
+		return i.FailStr("wrong # args")
+	}
+	return i.Return(FromFloat(i.random().Float64()))
+}
+
+// srandFn reseeds the interpreter's generator and returns
+// the first number from the new sequence.
+func srandFn(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 1 {
+		return i.FailStr("wrong # args")
+	}
+	seed, e := args[0].AsInt()
+	if e != nil {
+		return i.Fail(e)
+	}
+	i.rng = rand.New(rand.NewSource(int64(seed)))
+	return i.Return(FromFloat(i.rng.Float64()))
+}
+
+func intFn(i *Interp, args []*TclObj) TclStatus {
+	if _, e := args[0].AsInt(); e == nil {
+		return i.Return(args[0])
+	}
+	f, e := args[0].AsFloat()
+	if e != nil {
+		return i.Fail(e)
+	}
+	r := math.Trunc(f)
+	if math.IsNaN(r) {
+		return i.FailStr("domain error: argument not in valid range")
+	}
+	if r < minInt || r >= -minInt {
+		return i.Fail(intOverflowError())
+	}
+	return i.Return(FromInt(int(r)))
+}
+
+func boolFn(i *Interp, args []*TclObj) TclStatus {
+	b, e := args[0].asBool()
+	if e != nil {
+		return i.Fail(e)
+	}
+	return i.Return(FromBool(b))
+}
+
+func doubleFn(i *Interp, args []*TclObj) TclStatus {
+	f, e := args[0].AsFloat()
+	if e != nil {
+		return i.Fail(e)
+	}
+	return i.Return(FromFloat(f))
 }
 
 func powFn(i *Interp, args []*TclObj) TclStatus {
-	res := 1
-	x, y, e := asInts(args[0], args[1])
+	r, e := i.checkOverflow(powOp.action(args[0], args[1]))
 	if e != nil {
 		return i.Fail(e)
 	}
-	for y > 0 {
-		res *= x
-		y--
+	return i.Return(r)
+}
+
+// floatFn makes a function of one float argument from f. A NaN result
+// from a number is reported as a domain error, as log(-1) is in Tcl.
+func floatFn(f func(float64) float64) TclCmd {
+	return func(i *Interp, args []*TclObj) TclStatus {
+		x, e := args[0].AsFloat()
+		if e != nil {
+			return i.Fail(e)
+		}
+		return floatResult(i, f(x), x)
+	}
+}
+
+// floatFn2 is like floatFn for functions of two arguments.
+func floatFn2(f func(float64, float64) float64) TclCmd {
+	return func(i *Interp, args []*TclObj) TclStatus {
+		x, e := args[0].AsFloat()
+		if e != nil {
+			return i.Fail(e)
+		}
+		y, e := args[1].AsFloat()
+		if e != nil {
+			return i.Fail(e)
+		}
+		return floatResult(i, f(x, y), x+y)
+	}
+}
+
+func floatResult(i *Interp, r, in float64) TclStatus {
+	if math.IsNaN(r) && !math.IsNaN(in) {
+		return i.FailStr("domain error: argument not in valid range")
+	}
+	return i.Return(FromFloat(r))
+}
+
+// roundFn rounds half away from zero, returning an integer. There's no
+// bignum type, so a value outside the range of an int is an error.
+func roundFn(i *Interp, args []*TclObj) TclStatus {
+	if _, e := args[0].AsInt(); e == nil {
+		return i.Return(args[0])
+	}
+	f, e := args[0].AsFloat()
+	if e != nil {
+		return i.Fail(e)
+	}
+	r := math.Round(f)
+	if math.IsNaN(r) {
+		return i.FailStr("domain error: argument not in valid range")
+	}
+	if r < minInt || r >= -minInt {
+		return i.Fail(intOverflowError())
+	}
+	return i.Return(FromInt(int(r)))
+}
+
+func absFn(i *Interp, args []*TclObj) TclStatus {
+	if n, e := args[0].AsInt(); e == nil {
+		if n == minInt {
+			r, e := i.checkOverflow(nil, &overflowError{FromInt(n), FromFloat(-float64(n))})
+			if e != nil {
+				return i.Fail(e)
+			}
+			return i.Return(r)
+		}
+		if n < 0 {
+			return i.Return(FromInt(-n))
+		}
+		return i.Return(args[0])
 	}
-	return i.Return(FromInt(res))
+	return floatFn(math.Abs)(i, args)
 }
 
 var mathFuncs = map[string]*exprFunc{
-	"min":  {1, 100, binOpFold(ltOp)},
-	"max":  {1, 100, binOpFold(gtOp)},
-	"rand": {0, 0, randFn},
-	"pow":  {2, 2, powFn},
+	"min":    {1, 100, binOpFold(ltOp)},
+	"max":    {1, 100, binOpFold(gtOp)},
+	"rand":   {0, 0, randFn},
+	"srand":  {1, 1, srandFn},
+	"int":    {1, 1, intFn},
+	"entier": {1, 1, intFn},
+	"wide":   {1, 1, intFn},
+	"bool":   {1, 1, boolFn},
+	"double": {1, 1, doubleFn},
+	"pow":    {2, 2, powFn},
+	"round":  {1, 1, roundFn},
+	"abs":    {1, 1, absFn},
+	"floor":  {1, 1, floatFn(math.Floor)},
+	"ceil":   {1, 1, floatFn(math.Ceil)},
+	"sqrt":   {1, 1, floatFn(math.Sqrt)},
+	"exp":    {1, 1, floatFn(math.Exp)},
+	"log":    {1, 1, floatFn(math.Log)},
+	"log10":  {1, 1, floatFn(math.Log10)},
+	"sin":    {1, 1, floatFn(math.Sin)},
+	"cos":    {1, 1, floatFn(math.Cos)},
+	"tan":    {1, 1, floatFn(math.Tan)},
+	"asin":   {1, 1, floatFn(math.Asin)},
+	"acos":   {1, 1, floatFn(math.Acos)},
+	"atan":   {1, 1, floatFn(math.Atan)},
+	"sinh":   {1, 1, floatFn(math.Sinh)},
+	"cosh":   {1, 1, floatFn(math.Cosh)},
+	"tanh":   {1, 1, floatFn(math.Tanh)},
+	"fmod":   {2, 2, floatFn2(math.Mod)},
+	"hypot":  {2, 2, floatFn2(math.Hypot)},
+	"atan2":  {2, 2, floatFn2(math.Atan2)},
+}
+
+// mathfuncPrefix is prepended to a function name in an expression to
+// get the name of the command that implements it, so scripts can add
+// functions by defining procs like tcl::mathfunc::square.
+const mathfuncPrefix = "tcl::mathfunc::"
+
+func (ef *exprFunc) makeCmd() TclCmd {
+	return func(i *Interp, args []*TclObj) TclStatus {
+		if len(args) < ef.argmin || len(args) > ef.argmax {
+			return i.FailStr("wrong # args")
+		}
+		return ef.fn(i, args)
+	}
 }
 
 func (f *funcNode) Eval(i *Interp) TclStatus {
-	fn, ok := mathFuncs[f.name]
+	fn, ok := i.cmds[f.cmdname]
 	if !ok {
 		return i.FailStr("unknown function: \"" + f.name + "\"")
 	}
-	if len(f.args) < fn.argmin || len(f.args) > fn.argmax {
-		return i.FailStr("wrong # args")
-	}
-	args := make([]*TclObj, len(f.args))
+	// The command's words are its name and then its arguments, so one
+	// slice holds both, and the only allocation is the one the
+	// arguments need anyway.
+	words := make([]*TclObj, len(f.args)+1)
+	words[0] = f.cmdobj
+	args := words[1:]
 	for ix, a := range f.args {
 		rc := a.Eval(i)
 		if rc != kTclOK {
@@ -87,7 +252,8 @@ func (f *funcNode) Eval(i *Interp) TclStatus {
 		}
 		args[ix] = i.retval
 	}
-	return fn.fn(i, args)
+	i.callWords = words
+	return fn(i, args)
 }
 
 func (f *funcNode) String() string {
@@ -109,7 +275,11 @@ func (u *unOpNode) Eval(i *Interp) TclStatus {
 		return rc
 	}
 	if u.op == '!' {
-		return i.Return(FromBool(!i.retval.AsBool()))
+		b, e := i.retval.asBool()
+		if e != nil {
+			return i.Fail(e)
+		}
+		return i.Return(FromBool(!b))
 	} else if u.op == '~' {
 		iv, e := i.retval.AsInt()
 		if e != nil {
@@ -136,14 +306,15 @@ func (bb *binOpNode) Eval(i *Interp) TclStatus {
 	if bb.op.special != nil {
 		return bb.op.special(i, bb.a, bb.b)
 	}
-	bb.a.Eval(i)
+	if rc := bb.a.Eval(i); rc != kTclOK {
+		return rc
+	}
 	a := i.retval
-	bb.b.Eval(i)
-	b := i.retval
-	if i.err != nil {
-		return i.Fail(i.err)
+	if rc := bb.b.Eval(i); rc != kTclOK {
+		return rc
 	}
-	r, e := bb.op.action(a, b)
+	b := i.retval
+	r, e := i.checkOverflow(bb.op.action(a, b))
 	if e != nil {
 		return i.Fail(e)
 	}
@@ -158,118 +329,425 @@ func (bb *binOpNode) String() string {
 type binOpAct func(*TclObj, *TclObj) (*TclObj, error)
 type binaryOp struct {
 	name       string
-	precedence int
+	precedence int  // higher binds tighter, as in Tcl's expr
+	rightAssoc bool // as ** is, so 2**3**2 is 2**(3**2)
 	action     func(*TclObj, *TclObj) (*TclObj, error)
 	special    func(*Interp, eterm, eterm) TclStatus
 }
 
 var binOps = [...]*binaryOp{
-	plusOp, minusOp, timesOp, xorOp, divideOp, lshiftOp, rshiftOp,
-	equalsOp, notEqualsOp, andOp, orOp, gtOp, gteOp, ltOp, lteOp,
+	plusOp, minusOp, timesOp, powOp, xorOp, divideOp, modOp, lshiftOp, rshiftOp,
+	equalsOp, notEqualsOp, eqOp, neOp, andOp, orOp, gtOp, gteOp, ltOp, lteOp,
 }
 
-var plusOp = &binaryOp{name: "+", precedence: 2,
+// An IntOverflowMode says what expr does when integer arithmetic
+// overflows. It applies to +, -, *, /, ** and pow() when both operands
+// are integers and the result doesn't fit in an int; with a float
+// operand the arithmetic is done in floating point, which can't
+// overflow this way.
+type IntOverflowMode int
+
+const (
+	// IntOverflowWrap keeps the low bits of the result, as Go and C
+	// do. It's the default.
+	IntOverflowWrap IntOverflowMode = iota
+	// IntOverflowError fails with errorCode {ARITH IOVERFLOW ...}.
+	IntOverflowError
+	// IntOverflowPromote computes the result as a double instead. As
+	// there's no bignum type, this is the nearest thing to Tcl's
+	// promotion, and loses precision beyond 53 bits.
+	IntOverflowPromote
+)
+
+const minInt = -1 << (strconv.IntSize - 1)
+const maxInt = -(minInt + 1)
+
+// An overflowError is returned by an arithmetic operator whose
+// integer result overflowed, with the results for IntOverflowWrap and
+// IntOverflowPromote. checkOverflow picks one.
+type overflowError struct {
+	wrapped, promoted *TclObj
+}
+
+func (e *overflowError) Error() string {
+	return "integer value too large to represent"
+}
+
+// intOverflowError is the error for an integer result that doesn't
+// fit. Like divZeroError, each is new.
+func intOverflowError() error {
+	return &codedError{FromList([]string{"ARITH", "IOVERFLOW", "integer value too large to represent"}), "integer value too large to represent"}
+}
+
+// checkOverflow resolves an overflowError from an operator according
+// to i's overflow mode. Other results pass through.
+func (i *Interp) checkOverflow(r *TclObj, e error) (*TclObj, error) {
+	oe, ok := e.(*overflowError)
+	if !ok {
+		return r, e
+	}
+	switch i.intOverflow {
+	case IntOverflowWrap:
+		return oe.wrapped, nil
+	case IntOverflowPromote:
+		return oe.promoted, nil
+	}
+	return nil, intOverflowError()
+}
+
+// arith applies iop if a and b are both integers, and otherwise
+// applies fop to them as floats. iop also reports whether its result
+// fit; if not, arith returns an overflowError.
+func arith(a, b *TclObj, iop func(int, int) (int, bool), fop func(float64, float64) float64) (*TclObj, error) {
+	if i1, i2, e := asInts(a, b); e == nil {
+		r, ok := iop(i1, i2)
+		if !ok {
+			return nil, &overflowError{FromInt(r), FromFloat(fop(float64(i1), float64(i2)))}
+		}
+		return FromInt(r), nil
+	}
+	f1, f2, e := asFloats(a, b)
+	if e != nil {
+		return nil, e
+	}
+	return FromFloat(fop(f1, f2)), nil
+}
+
+// numCompare is like arith, for comparisons. If either value isn't
+// a number, they're compared as strings instead, by applying icmp to
+// the result of strings.Compare and 0.
+func numCompare(a, b *TclObj, icmp func(int, int) bool, fcmp func(float64, float64) bool) (*TclObj, error) {
+	if i1, i2, e := asInts(a, b); e == nil {
+		return FromBool(icmp(i1, i2)), nil
+	}
+	if f1, f2, e := asFloats(a, b); e == nil {
+		return FromBool(fcmp(f1, f2)), nil
+	}
+	return FromBool(icmp(strings.Compare(a.AsString(), b.AsString()), 0)), nil
+}
+
+func addInts(x, y int) (int, bool) {
+	r := x + y
+	return r, (r > x) == (y > 0)
+}
+
+func subInts(x, y int) (int, bool) {
+	r := x - y
+	return r, (r < x) == (y > 0)
+}
+
+func mulInts(x, y int) (int, bool) {
+	r := x * y
+	if x == 0 {
+		return r, true
+	}
+	return r, r/x == y && !(x == -1 && y == minInt)
+}
+
+// powInts raises x to the power y, which isn't negative, by squaring.
+func powInts(x, y int) (int, bool) {
+	r, ok := 1, true
+	for y > 0 {
+		var k bool
+		if y&1 != 0 {
+			r, k = mulInts(r, x)
+			ok = ok && k
+		}
+		if y >>= 1; y > 0 {
+			x, k = mulInts(x, x)
+			ok = ok && k
+		}
+	}
+	return r, ok
+}
+
+var plusOp = &binaryOp{name: "+", precedence: 8,
 	action: func(a, b *TclObj) (*TclObj, error) {
-		i1, i2, e := asInts(a, b)
-		return FromInt(i1 + i2), e
+		return arith(a, b, addInts,
+			func(x, y float64) float64 { return x + y })
 	},
 }
-var minusOp = &binaryOp{name: "-", precedence: 2,
+var minusOp = &binaryOp{name: "-", precedence: 8,
 	action: func(a, b *TclObj) (*TclObj, error) {
-		i1, i2, e := asInts(a, b)
-		return FromInt(i1 - i2), e
+		return arith(a, b, subInts,
+			func(x, y float64) float64 { return x - y })
 	},
 }
-var timesOp = &binaryOp{name: "*", precedence: 3,
+var timesOp = &binaryOp{name: "*", precedence: 9,
 	action: func(a, b *TclObj) (*TclObj, error) {
-		i1, i2, e := asInts(a, b)
-		return FromInt(i1 * i2), e
+		return arith(a, b, mulInts,
+			func(x, y float64) float64 { return x * y })
 	}}
-var divideOp = &binaryOp{name: "/", precedence: 3,
+
+// An integer to a negative power is 0 unless the base is 1 or -1, as
+// in Tcl, and 0 to a negative power is an error.
+var powOp = &binaryOp{name: "**", precedence: 10, rightAssoc: true,
+	action: func(a, b *TclObj) (*TclObj, error) {
+		if x, y, e := asInts(a, b); e == nil && y < 0 {
+			switch x {
+			case 0:
+				return nil, &codedError{FromList([]string{"ARITH", "DOMAIN", "exponentiation of zero by negative power"}), "exponentiation of zero by negative power"}
+			case 1:
+				return FromInt(1), nil
+			case -1:
+				return FromInt(1 - 2*(y&1)), nil
+			}
+			return FromInt(0), nil
+		}
+		return arith(a, b, powInts, math.Pow)
+	}}
+
+// divZeroError is the error from dividing an integer by zero. Each is
+// new, as its errorCode may be used from any interpreter.
+func divZeroError() error {
+	return &codedError{FromList([]string{"ARITH", "DIVZERO", "divide by zero"}), "divide by zero"}
+}
+
+// Integer division rounds towards negative infinity, and the
+// remainder takes the sign of the divisor, so that
+// a == (a/b)*b + a%b always holds. Only integer division by zero
+// is an error; floats follow IEEE rules.
+func floorDiv(x, y int) int {
+	q := x / y
+	if (x%y != 0) && ((x < 0) != (y < 0)) {
+		q--
+	}
+	return q
+}
+
+func floorMod(x, y int) int {
+	return x - floorDiv(x, y)*y
+}
+
+var divideOp = &binaryOp{name: "/", precedence: 9,
+	action: func(a, b *TclObj) (*TclObj, error) {
+		if i1, i2, e := asInts(a, b); e == nil {
+			if i2 == 0 {
+				return nil, divZeroError()
+			}
+			if i1 == minInt && i2 == -1 {
+				return nil, &overflowError{FromInt(i1), FromFloat(-float64(i1))}
+			}
+			return FromInt(floorDiv(i1, i2)), nil
+		}
+		return arith(a, b, nil,
+			func(x, y float64) float64 { return x / y })
+	}}
+var modOp = &binaryOp{name: "%", precedence: 9,
 	action: func(a, b *TclObj) (*TclObj, error) {
 		i1, i2, e := asInts(a, b)
-		return FromInt(i1 / i2), e
+		if e != nil {
+			return nil, e
+		}
+		if i2 == 0 {
+			return nil, divZeroError()
+		}
+		return FromInt(floorMod(i1, i2)), nil
 	}}
 var xorOp = &binaryOp{name: "^", precedence: 3,
 	action: func(a, b *TclObj) (*TclObj, error) {
 		i1, i2, e := asInts(a, b)
 		return FromInt(i1 ^ i2), e
 	}}
-var lshiftOp = &binaryOp{name: "<<", precedence: 4,
+var lshiftOp = &binaryOp{name: "<<", precedence: 7,
 	action: func(a, b *TclObj) (*TclObj, error) {
 		i1, i2, e := asInts(a, b)
 		return FromInt(i1 << uint(i2)), e
 	}}
-var rshiftOp = &binaryOp{name: ">>", precedence: 4,
+var rshiftOp = &binaryOp{name: ">>", precedence: 7,
 	action: func(a, b *TclObj) (*TclObj, error) {
 		i1, i2, e := asInts(a, b)
 		return FromInt(i1 >> uint(i2)), e
 	}}
-var equalsOp = &binaryOp{name: "==", precedence: 1,
+var equalsOp = &binaryOp{name: "==", precedence: 5,
+	action: func(a, b *TclObj) (*TclObj, error) {
+		return numCompare(a, b,
+			func(x, y int) bool { return x == y },
+			func(x, y float64) bool { return x == y })
+	}}
+var notEqualsOp = &binaryOp{name: "!=", precedence: 5,
+	action: func(a, b *TclObj) (*TclObj, error) {
+		return numCompare(a, b,
+			func(x, y int) bool { return x != y },
+			func(x, y float64) bool { return x != y })
+	}}
+
+// eq and ne always compare as strings, so "1.0 eq 1" is false.
+var eqOp = &binaryOp{name: "eq", precedence: 5,
 	action: func(a, b *TclObj) (*TclObj, error) {
 		return FromBool(a.AsString() == b.AsString()), nil
 	}}
-var notEqualsOp = &binaryOp{name: "!=", precedence: 1,
+var neOp = &binaryOp{name: "ne", precedence: 5,
 	action: func(a, b *TclObj) (*TclObj, error) {
 		return FromBool(a.AsString() != b.AsString()), nil
 	}}
-var andOp = &binaryOp{name: "&&", precedence: 0,
+
+// asBools parses a and b as booleans, for && and ||.
+func asBools(a, b *TclObj) (ab, bb bool, e error) {
+	if ab, e = a.asBool(); e != nil {
+		return
+	}
+	bb, e = b.asBool()
+	return
+}
+
+// evalBool evaluates e, an operand of a logical operator, as a
+// boolean.
+func evalBool(i *Interp, e eterm) (v bool, rc TclStatus) {
+	if rc = e.Eval(i); rc != kTclOK {
+		return false, rc
+	}
+	v, err := i.retval.asBool()
+	if err != nil {
+		return false, i.Fail(err)
+	}
+	return v, kTclOK
+}
+
+// The logical operators always give 0 or 1, whatever their operands
+// are, and only evaluate the second operand if the first doesn't
+// decide the result.
+var andOp = &binaryOp{name: "&&", precedence: 1,
 	action: func(a, b *TclObj) (*TclObj, error) {
-		return FromBool(a.AsBool() && b.AsBool()), nil
+		x, y, e := asBools(a, b)
+		return FromBool(x && y), e
 	},
 	special: func(i *Interp, a, b eterm) TclStatus {
-		if rc := a.Eval(i); rc != kTclOK {
-			return rc
+		v, rc := evalBool(i, a)
+		if rc == kTclOK && v {
+			v, rc = evalBool(i, b)
 		}
-		if !i.retval.AsBool() {
-			return i.Return(kFalse)
-		}
-		if rc := b.Eval(i); rc != kTclOK {
+		if rc != kTclOK {
 			return rc
 		}
-		return i.Return(FromBool(i.retval.AsBool()))
+		return i.Return(FromBool(v))
 	}}
 var orOp = &binaryOp{
 	name: "||", precedence: 0,
 	action: func(a, b *TclObj) (*TclObj, error) {
-		return FromBool(a.AsBool() || b.AsBool()), nil
+		x, y, e := asBools(a, b)
+		return FromBool(x || y), e
 	},
 	special: func(i *Interp, a, b eterm) TclStatus {
-		if rc := a.Eval(i); rc != kTclOK {
-			return rc
-		}
-		if i.retval.AsBool() {
-			return i.Return(kTrue)
+		v, rc := evalBool(i, a)
+		if rc == kTclOK && !v {
+			v, rc = evalBool(i, b)
 		}
-		if rc := b.Eval(i); rc != kTclOK {
+		if rc != kTclOK {
 			return rc
 		}
-		return i.Return(FromBool(i.retval.AsBool()))
+		return i.Return(FromBool(v))
 	}}
 var gtOp = &binaryOp{
-	name: ">", precedence: -1,
+	name: ">", precedence: 6,
 	action: func(a, b *TclObj) (*TclObj, error) {
-		i1, i2, e := asInts(a, b)
-		return FromBool(i1 > i2), e
+		return numCompare(a, b,
+			func(x, y int) bool { return x > y },
+			func(x, y float64) bool { return x > y })
 	}}
 var gteOp = &binaryOp{
-	name: ">=", precedence: -1,
+	name: ">=", precedence: 6,
 	action: func(a, b *TclObj) (*TclObj, error) {
-		i1, i2, e := asInts(a, b)
-		return FromBool(i1 >= i2), e
+		return numCompare(a, b,
+			func(x, y int) bool { return x >= y },
+			func(x, y float64) bool { return x >= y })
 	}}
 
-var ltOp = &binaryOp{name: "<", precedence: -1,
+var ltOp = &binaryOp{name: "<", precedence: 6,
 	action: func(a, b *TclObj) (*TclObj, error) {
-		i1, i2, e := asInts(a, b)
-		return FromBool(i1 < i2), e
+		return numCompare(a, b,
+			func(x, y int) bool { return x < y },
+			func(x, y float64) bool { return x < y })
 	}}
-var lteOp = &binaryOp{name: "<=", precedence: -1,
+var lteOp = &binaryOp{name: "<=", precedence: 6,
 	action: func(a, b *TclObj) (*TclObj, error) {
-		i1, i2, e := asInts(a, b)
-		return FromBool(i1 <= i2), e
+		return numCompare(a, b,
+			func(x, y int) bool { return x <= y },
+			func(x, y float64) bool { return x <= y })
 	}}
 
+// mathopCmd returns the prefix command form of op, as in
+// Tcl's tcl::mathop. The arithmetic operators are variadic and
+// the comparisons chain, so "< 1 2 3" is true.
+func mathopCmd(op *binaryOp) TclCmd {
+	switch op {
+	case plusOp, xorOp:
+		return foldCmd(op, FromInt(0), false)
+	case timesOp:
+		return foldCmd(op, FromInt(1), false)
+	case minusOp:
+		// "- x" is 0-x
+		return foldCmd(op, FromInt(0), true)
+	case divideOp:
+		// "/ x" is 1.0/x
+		return foldCmd(op, FromFloat(1), true)
+	case powOp:
+		return powCmd
+	case ltOp, lteOp, gtOp, gteOp, equalsOp, notEqualsOp, eqOp, neOp:
+		return chainCmd(op)
+	}
+	return MakeCmd(op.action)
+}
+
+// foldCmd applies op left to right across its args. A single arg
+// is combined with identity; with no args, identity is the result,
+// unless needArg is set.
+func foldCmd(op *binaryOp, identity *TclObj, needArg bool) TclCmd {
+	return func(i *Interp, args []*TclObj) TclStatus {
+		if len(args) == 0 {
+			if needArg {
+				return i.FailStr("wrong # args: should be \"" + op.name + " value ?value ...?\"")
+			}
+			return i.Return(identity)
+		}
+		acc := identity
+		if len(args) > 1 {
+			acc, args = args[0], args[1:]
+		}
+		for _, a := range args {
+			r, e := i.checkOverflow(op.action(acc, a))
+			if e != nil {
+				return i.Fail(e)
+			}
+			acc = r
+		}
+		return i.Return(acc)
+	}
+}
+
+// powCmd is ** in prefix form, which groups from the right, so
+// "** 2 3 2" is 2**(3**2). With no args it's 1.
+func powCmd(i *Interp, args []*TclObj) TclStatus {
+	if len(args) == 0 {
+		return i.Return(FromInt(1))
+	}
+	acc := args[len(args)-1]
+	for k := len(args) - 2; k >= 0; k-- {
+		r, e := i.checkOverflow(powOp.action(args[k], acc))
+		if e != nil {
+			return i.Fail(e)
+		}
+		acc = r
+	}
+	return i.Return(acc)
+}
+
+// chainCmd is true if op holds between each adjacent pair of args.
+func chainCmd(op *binaryOp) TclCmd {
+	return func(i *Interp, args []*TclObj) TclStatus {
+		for ind := 1; ind < len(args); ind++ {
+			r, e := op.action(args[ind-1], args[ind])
+			if e != nil {
+				return i.Fail(e)
+			}
+			if !r.AsBool() {
+				return i.Return(kFalse)
+			}
+		}
+		return i.Return(kTrue)
+	}
+}
+
 func gbalance(b eterm) eterm {
 	bb, ok := b.(*binOpNode)
 	if ok {
@@ -281,13 +759,13 @@ func gbalance(b eterm) eterm {
 func balance(b *binOpNode) eterm {
 	switch bb := b.b.(type) {
 	case *binOpNode:
-		if b.op.precedence >= bb.op.precedence {
+		if b.op.precedence > bb.op.precedence || b.op.precedence == bb.op.precedence && !b.op.rightAssoc {
 			return &binOpNode{bb.op,
-				&binOpNode{b.op, gbalance(b.a), gbalance(bb.a)},
+				balance(&binOpNode{b.op, gbalance(b.a), bb.a}),
 				gbalance(bb.b)}
 		}
 	case *ternaryIfNode:
-		return &ternaryIfNode{&binOpNode{b.op, gbalance(b.a), bb.cond},
+		return &ternaryIfNode{balance(&binOpNode{b.op, gbalance(b.a), bb.cond}),
 			gbalance(bb.yes), gbalance(bb.no)}
 	}
 	return b
@@ -388,16 +866,24 @@ func (p *parser) parseFunc(name string) *funcNode {
 		}
 	}
 	p.advance()
-	return &funcNode{name: name, args: fargs}
+	cmdobj := FromStr(mathfuncPrefix + name)
+	freeze(cmdobj)
+	return &funcNode{name: name, cmdname: cmdobj.AsString(), cmdobj: cmdobj, args: fargs}
 }
 
 func (p *parser) parseBinOp() *binaryOp {
 	c := p.advance()
 	switch c {
 	case '*':
+		if p.ch == '*' {
+			p.advance()
+			return powOp
+		}
 		return timesOp
 	case '/':
 		return divideOp
+	case '%':
+		return modOp
 	case '+':
 		return plusOp
 	case '-':
@@ -407,10 +893,10 @@ func (p *parser) parseBinOp() *binaryOp {
 		return orOp
 	case 'e':
 		p.consumeRune('q')
-		return equalsOp
+		return eqOp
 	case 'n':
 		p.consumeRune('e')
-		return notEqualsOp
+		return neOp
 	case '&':
 		p.consumeRune('&')
 		return andOp
@@ -472,5 +958,77 @@ func tclExpr(i *Interp, args []*TclObj) TclStatus {
 	if err != nil {
 		return i.Fail(err)
 	}
-	return expr.Eval(i)
+	if rc := expr.Eval(i); rc != kTclOK {
+		return rc
+	}
+	// A lone operand comes back as written, so an integer in another
+	// base, or with a sign or leading zeros, is put in the decimal form
+	// arithmetic would give it.
+	if r := i.retval; r.value != nil {
+		if n, e := r.AsInt(); e == nil && *r.value != strconv.Itoa(n) {
+			i.retval = FromInt(n)
+		}
+	}
+	return kTclOK
+}
+
+// exprVars appends the scalar variables referenced in e to vars.
+func exprVars(e eterm, vars []varRef) []varRef {
+	switch t := e.(type) {
+	case varRef:
+		if t.arrind == nil {
+			vars = append(vars, t)
+		}
+	case *binOpNode:
+		vars = exprVars(t.b, exprVars(t.a, vars))
+	case *unOpNode:
+		vars = exprVars(t.v, vars)
+	case *parenNode:
+		vars = exprVars(t.term, vars)
+	case *ternaryIfNode:
+		vars = exprVars(t.no, exprVars(t.yes, exprVars(t.cond, vars)))
+	case *funcNode:
+		for _, a := range t.args {
+			vars = exprVars(a, vars)
+		}
+	}
+	return vars
+}
+
+// assert expr ?message?
+//
+// Fails if expr is false, describing where the assertion is and
+// the values of the variables it uses.
+func tclAssert(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 1 && len(args) != 2 {
+		return i.FailStr("wrong # args: should be \"assert expr ?message?\"")
+	}
+	expr, err := args[0].asExpr()
+	if err != nil {
+		return i.Fail(err)
+	}
+	if rc := expr.Eval(i); rc != kTclOK {
+		return rc
+	}
+	if i.retval.AsBool() {
+		return i.Return(kNil)
+	}
+	msg := fmt.Sprintf("assertion \"%s\" failed at %v", args[0].AsString(), args[0].loc)
+	if len(args) == 2 {
+		msg = args[1].AsString() + ": " + msg
+	}
+	var vals []string
+	seen := make(map[string]bool)
+	for _, v := range exprVars(expr, nil) {
+		if name := v.String(); !seen[name] {
+			seen[name] = true
+			if val, e := i.getVar(v); e == nil {
+				vals = append(vals, name+" is "+val.AsString())
+			}
+		}
+	}
+	if len(vals) != 0 {
+		msg += " (" + strings.Join(vals, ", ") + ")"
+	}
+	return i.FailStr(msg)
 }
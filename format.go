@@ -0,0 +1,256 @@
+package gotcl
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// format formatString ?arg ...?
+//
+// Supports the usual conversions (d i u o x X b c s f e E g G and %%),
+// the flags "-", "+", " ", "0" and "#", field widths and precisions
+// (either of which may be "*"), and XPG3 positional specifiers like
+// "%2$s".
+//
+// As an extension, the "'" flag groups the digits of a decimal integer
+// in threes with commas, so "%'d" formats 1234567 as 1,234,567. With
+// this flag the field is padded with spaces, even if "0" is given.
+
+type convKind int
+
+const (
+	convInt convKind = iota
+	convFloat
+	convString
+	convChar
+)
+
+// formatConvs maps each conversion character to the kind of argument
+// it takes and the fmt verb that renders it.
+var formatConvs = map[byte]struct {
+	kind convKind
+	verb byte
+}{
+	'd': {convInt, 'd'},
+	'i': {convInt, 'd'},
+	'u': {convInt, 'd'},
+	'o': {convInt, 'o'},
+	'x': {convInt, 'x'},
+	'X': {convInt, 'X'},
+	'b': {convInt, 'b'},
+	'c': {convChar, 'c'},
+	's': {convString, 's'},
+	'f': {convFloat, 'f'},
+	'e': {convFloat, 'e'},
+	'E': {convFloat, 'E'},
+	'g': {convFloat, 'g'},
+	'G': {convFloat, 'G'},
+}
+
+// A formatSpec is one parsed "%..." field.
+type formatSpec struct {
+	flags     string
+	group     bool
+	width     string
+	precision string
+	conv      byte
+}
+
+type formatter struct {
+	args       []*TclObj
+	next       int
+	positional bool
+}
+
+func (f *formatter) arg(pos int) (*TclObj, error) {
+	if pos == 0 {
+		if f.positional {
+			return nil, errors.New("cannot mix \"%\" and \"%n$\" conversion specifiers")
+		}
+		pos = f.next + 1
+		f.next++
+	}
+	if pos > len(f.args) {
+		if f.positional {
+			return nil, errors.New("\"%n$\" argument index out of range")
+		}
+		return nil, errors.New("not enough arguments for all format specifiers")
+	}
+	return f.args[pos-1], nil
+}
+
+// star reads a "*" width or precision from the arguments.
+func (f *formatter) star() (string, error) {
+	a, e := f.arg(0)
+	if e != nil {
+		return "", e
+	}
+	n, e := a.AsInt()
+	if e != nil {
+		return "", e
+	}
+	return strconv.Itoa(n), nil
+}
+
+func takeDigits(s string) (string, string) {
+	ind := 0
+	for ind < len(s) && s[ind] >= '0' && s[ind] <= '9' {
+		ind++
+	}
+	return s[:ind], s[ind:]
+}
+
+// parseSpec parses the field starting just after a "%", returning it
+// and the rest of the format string.
+func (f *formatter) parseSpec(s string) (spec formatSpec, pos int, rest string, err error) {
+	if d, r := takeDigits(s); d != "" && strings.HasPrefix(r, "$") {
+		if f.next > 0 {
+			return spec, 0, "", errors.New("cannot mix \"%\" and \"%n$\" conversion specifiers")
+		}
+		f.positional = true
+		pos, _ = strconv.Atoi(d)
+		s = r[1:]
+	} else if f.positional {
+		return spec, 0, "", errors.New("cannot mix \"%\" and \"%n$\" conversion specifiers")
+	}
+	for len(s) > 0 && strings.IndexByte("-+ 0#'", s[0]) >= 0 {
+		if s[0] == '\'' {
+			spec.group = true
+		} else {
+			spec.flags += s[:1]
+		}
+		s = s[1:]
+	}
+	if strings.HasPrefix(s, "*") {
+		if spec.width, err = f.star(); err != nil {
+			return
+		}
+		if strings.HasPrefix(spec.width, "-") {
+			spec.flags += "-"
+			spec.width = spec.width[1:]
+		}
+		s = s[1:]
+	} else {
+		spec.width, s = takeDigits(s)
+	}
+	if strings.HasPrefix(s, ".") {
+		s = s[1:]
+		if strings.HasPrefix(s, "*") {
+			if spec.precision, err = f.star(); err != nil {
+				return
+			}
+			s = s[1:]
+		} else {
+			spec.precision, s = takeDigits(s)
+			if spec.precision == "" {
+				spec.precision = "0"
+			}
+		}
+		spec.precision = "." + spec.precision
+	}
+	// Size modifiers make no difference here.
+	for len(s) > 0 && (s[0] == 'l' || s[0] == 'h') {
+		s = s[1:]
+	}
+	if s == "" {
+		return spec, 0, "", errors.New("format string ended in middle of field specifier")
+	}
+	spec.conv, rest = s[0], s[1:]
+	return
+}
+
+// groupDigits puts commas between each group of three digits in s,
+// which is an optionally signed decimal integer.
+func groupDigits(s string) string {
+	sign := ""
+	if s != "" && (s[0] == '-' || s[0] == '+' || s[0] == ' ') {
+		sign, s = s[:1], s[1:]
+	}
+	var b strings.Builder
+	for ind, c := range s {
+		if ind > 0 && (len(s)-ind)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	return sign + b.String()
+}
+
+func (spec formatSpec) render(a *TclObj) (string, error) {
+	c := formatConvs[spec.conv]
+	var val interface{}
+	switch c.kind {
+	case convInt, convChar:
+		n, e := a.AsInt()
+		if e != nil {
+			return "", e
+		}
+		val = n
+		if c.kind == convChar {
+			val = rune(n)
+		} else if strings.IndexByte("uoxXb", spec.conv) >= 0 {
+			// As in C, these treat the value as unsigned.
+			val = uint64(n)
+		}
+	case convFloat:
+		x, e := a.AsFloat()
+		if e != nil {
+			return "", e
+		}
+		val = x
+	case convString:
+		val = a.AsString()
+	}
+	if spec.group && c.verb == 'd' {
+		s := fmt.Sprintf("%"+strings.Replace(spec.flags, "0", "", -1)+spec.precision+"d", val)
+		return fmt.Sprintf("%"+strings.Replace(spec.flags, "0", "", -1)+spec.width+"s", groupDigits(s)), nil
+	}
+	return fmt.Sprintf("%"+spec.flags+spec.width+spec.precision+string(c.verb), val), nil
+}
+
+func tclFormat(i *Interp, args []*TclObj) TclStatus {
+	if len(args) == 0 {
+		return i.FailStr("wrong # args: should be \"format formatString ?arg ...?\"")
+	}
+	f := &formatter{args: args[1:]}
+	s := args[0].AsString()
+	var out strings.Builder
+	for {
+		ind := strings.IndexByte(s, '%')
+		if ind < 0 {
+			out.WriteString(s)
+			break
+		}
+		out.WriteString(s[:ind])
+		s = s[ind+1:]
+		if strings.HasPrefix(s, "%") {
+			out.WriteByte('%')
+			s = s[1:]
+			continue
+		}
+		spec, pos, rest, e := f.parseSpec(s)
+		if e != nil {
+			return i.Fail(e)
+		}
+		s = rest
+		if _, ok := formatConvs[spec.conv]; !ok {
+			return i.FailStr("bad field specifier \"" + string(spec.conv) + "\"")
+		}
+		a, e := f.arg(pos)
+		if e != nil {
+			return i.Fail(e)
+		}
+		r, e := spec.render(a)
+		if e != nil {
+			return i.Fail(e)
+		}
+		out.WriteString(r)
+	}
+	return i.Return(FromStr(out.String()))
+}
+
+func init() {
+	RegisterDefaultCmd("format", tclFormat)
+}
@@ -0,0 +1,141 @@
+package gotcl
+
+// generator name body
+// yield ?value?
+//
+// Creates the command name, which runs body a piece at a time: each
+// call resumes body until it calls yield, and returns the value
+// yielded. When body finishes, the call returns with a break instead,
+// so a loop like
+//
+//	while 1 { set row [name]; ... }
+//
+// stops at the end of the stream, and name is deleted. An error in
+// body is returned from the call that resumed it, and also ends it.
+// body runs in a frame of its own, like a proc called from the global
+// level, and may yield from inside procs it calls.
+//
+// body runs in a goroutine, handing control back and forth with the
+// caller so that only one of them uses the interpreter at a time. A
+// generator that's abandoned before it finishes keeps its goroutine
+// until "name close", which makes the pending yield, and any after it,
+// fail with "generator closed" so that body unwinds, then deletes
+// name.
+
+type generator struct {
+	resume  chan bool // false asks body to unwind
+	yield   chan TclStatus
+	frame   *stackframe
+	depth   int
+	running bool
+	closing bool
+	done    bool
+}
+
+func tclGenerator(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 2 {
+		return i.FailStr("wrong # args: should be \"generator name body\"")
+	}
+	cmds, e := args[1].asCmds()
+	if e != nil {
+		return i.Fail(e)
+	}
+	global := i.frame
+	for global.next != nil {
+		global = global.next
+	}
+	g := &generator{
+		resume: make(chan bool),
+		yield:  make(chan TclStatus),
+		frame:  newstackframe(global),
+		depth:  1,
+	}
+	g.frame.call = &frameInfo{i.callWords, i.callLoc}
+	go func() {
+		rc := kTclOK
+		if <-g.resume {
+			rc = i.evalCmds(cmds)
+			if i.frame.deferred != nil {
+				rc = i.runDeferred(rc)
+			}
+		}
+		g.done = true
+		g.yield <- rc
+	}()
+	i.SetCmd(args[0].AsString(), g.call)
+	return i.Return(kNil)
+}
+
+// switchTo runs g until it yields or finishes, sending it keepGoing.
+func (g *generator) switchTo(i *Interp, keepGoing bool) TclStatus {
+	frame, depth, cur := i.frame, i.depth, i.gen
+	i.frame, i.depth, i.gen = g.frame, g.depth, g
+	g.running = true
+	g.resume <- keepGoing
+	rc := <-g.yield
+	g.running = false
+	g.frame, g.depth = i.frame, i.depth
+	i.frame, i.depth, i.gen = frame, depth, cur
+	return rc
+}
+
+func (g *generator) call(i *Interp, args []*TclObj) TclStatus {
+	name := i.callWords[0].AsString()
+	if g.running {
+		return i.FailStr("generator \"" + name + "\" is already running")
+	}
+	if len(args) == 1 && args[0].AsString() == "close" {
+		g.closing = true
+		rc := g.switchTo(i, false)
+		if e := i.deleteCmd(name); e != nil {
+			return i.Fail(e)
+		}
+		if rc == kTclErr && isExit(i.err) {
+			return rc
+		}
+		return i.Return(kNil)
+	}
+	if len(args) != 0 {
+		return i.FailStr("wrong # args: should be \"" + name + " ?close?\"")
+	}
+	rc := g.switchTo(i, true)
+	if !g.done {
+		return rc
+	}
+	if e := i.deleteCmd(name); e != nil {
+		return i.Fail(e)
+	}
+	if rc == kTclErr {
+		return rc
+	}
+	i.retval = kNil
+	return kTclBreak
+}
+
+func tclYield(i *Interp, args []*TclObj) TclStatus {
+	if len(args) > 1 {
+		return i.FailStr("wrong # args: should be \"yield ?value?\"")
+	}
+	g := i.gen
+	if g == nil {
+		return i.FailStr("yield can only be called in a generator")
+	}
+	if g.closing {
+		return i.FailStr("generator closed")
+	}
+	v := kNil
+	if len(args) == 1 {
+		v = args[0]
+	}
+	i.retval = v
+	g.yield <- kTclOK
+	if !<-g.resume {
+		return i.FailStr("generator closed")
+	}
+	return i.Return(kNil)
+}
+
+func init() {
+	RegisterDefaultCmd("generator", tclGenerator)
+	RegisterDefaultCmd("yield", tclYield)
+}
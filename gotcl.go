@@ -3,11 +3,18 @@ package gotcl
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"io"
+	"math"
+	"math/rand"
 	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unicode/utf8"
 )
 
 // Simple struct for embedding in every
@@ -85,6 +92,21 @@ func (b *block) Eval(i *Interp) TclStatus {
 	return i.Return(b.tval)
 }
 
+// # ...
+//
+// Comments are only kept by parsers with keepComments set, for tools
+// working on the parse tree, so the interpreter never evaluates them.
+type comment struct {
+	notExpand
+	text string
+	loc  loc
+}
+
+func (c *comment) String() string { return c.text }
+func (c *comment) Eval(i *Interp) TclStatus {
+	return i.Return(kNil)
+}
+
 // {*}{...}
 type expandTok struct {
 	subject tclTok
@@ -104,6 +126,16 @@ func (e *expandTok) String() string {
 	return "{*}" + e.subject.String()
 }
 
+// expandError describes the failure to expand t, whose value isn't a
+// valid list.
+func expandError(t tclTok, err error) string {
+	where := ""
+	if et, ok := t.(*expandTok); ok && et.loc.file != "" {
+		where = " at " + et.loc.String()
+	}
+	return "can't expand " + t.String() + where + ": " + strings.TrimSpace(err.Error())
+}
+
 // "..."
 type strlit struct {
 	notExpand
@@ -200,7 +232,7 @@ func toVarRef(s string) varRef {
 		s = s[2:]
 		global = true
 	}
-	if s[len(s)-1] == ')' {
+	if len(s) > 0 && s[len(s)-1] == ')' {
 		ri := strings.IndexRune(s, '(')
 		if ri > 0 {
 			ind := &tliteral{strval: s[ri+1 : len(s)-1]}
@@ -213,7 +245,40 @@ func toVarRef(s string) varRef {
 
 type simpleCall struct {
 	cmdname string
+	words   []*TclObj
 	args    []*TclObj
+	cache   atomic.Value // *cachedCmd
+}
+
+// cmdGen counts the changes made by SetCmd, in every interpreter, so
+// that a cached command lookup can tell whether it's still good. It
+// can't be kept per interpreter, since interpreters may share their
+// commands, as with NewInterpFrom.
+var cmdGen uint64
+
+// A cachedCmd is the command a simpleCall found in i while cmdGen was
+// gen.
+type cachedCmd struct {
+	i   *Interp
+	gen uint64
+	f   TclCmd
+}
+
+// lookup finds the command sc calls, or nil if there isn't one,
+// reusing the last lookup until the commands change. Parsed scripts
+// can be run by several interpreters at once, so the cache is only
+// accessed atomically.
+func (sc *simpleCall) lookup(i *Interp) TclCmd {
+	gen := atomic.LoadUint64(&cmdGen)
+	if c, _ := sc.cache.Load().(*cachedCmd); c != nil && c.i == i && c.gen == gen {
+		return c.f
+	}
+	f, ok := i.cmds[sc.cmdname]
+	if !ok {
+		return nil
+	}
+	sc.cache.Store(&cachedCmd{i, gen, f})
+	return f
 }
 
 // w1 w2...
@@ -221,8 +286,17 @@ type command struct {
 	words     []tclTok
 	no_expand bool
 	simple    *simpleCall
+	loc       loc
+	// Parsers with keepComments set also record where each word is in
+	// the input and the line the command ends on, for tools that
+	// rewrite the source.
+	spans   []span
+	endLine int
 }
 
+// span is the byte offsets of a word in a script, end exclusive.
+type span struct{ start, end int }
+
 // a simpleTok is a token that won't change.
 // As such, we can get it's TclObj value without
 // regard to interpreter state. This is used to
@@ -231,7 +305,7 @@ type simpleTok interface {
 	AsTclObj() *TclObj
 }
 
-func makeCommand(words []tclTok) command {
+func makeCommand(words []tclTok, loc loc) command {
 	all_simpletok := true
 	has_expand := false
 	var simple *simpleCall
@@ -249,9 +323,9 @@ func makeCommand(words []tclTok) command {
 		for i := range args {
 			args[i] = words[i].(simpleTok).AsTclObj()
 		}
-		simple = &simpleCall{cmdname: args[0].AsString(), args: args[1:]}
+		simple = &simpleCall{cmdname: args[0].AsString(), words: args, args: args[1:]}
 	}
-	return command{words: words, simple: simple, no_expand: !has_expand}
+	return command{words: words, simple: simple, no_expand: !has_expand, loc: loc}
 }
 
 func (c *command) String() string {
@@ -284,15 +358,33 @@ const (
 	kTclContinue
 )
 
+// The statuses a command or script can complete with, for embedders
+// acting on the status returned by Eval.
+const (
+	TclOK       = kTclOK
+	TclErr      = kTclErr
+	TclReturn   = kTclReturn
+	TclBreak    = kTclBreak
+	TclContinue = kTclContinue
+)
+
 type framelink struct {
 	frame *stackframe
 	name  string
+	elem  *tliteral // the element of array name linked to, if any
 }
 
 type varEntry struct {
-	obj     *TclObj
-	link    *framelink
-	arrdata map[string]*TclObj
+	obj        *TclObj
+	link       *framelink
+	arrdata    map[string]*TclObj
+	onset      func(*TclObj) error // validates and applies writes, if set
+	onget      func() *TclObj      // supplies the value on reads, if set
+	immutable  bool                // set by const; writes and unsets fail
+	traces     []*tclTrace
+	tracing    bool        // set while traces run, so they don't fire themselves
+	reflect    *varReflect // computes the value, for tcl::var::reflect
+	defaultVal *TclObj     // for an array, the value of missing elements
 }
 
 type varMap map[string]*varEntry
@@ -300,21 +392,63 @@ type varMap map[string]*varEntry
 type stackframe struct {
 	vars varMap
 	next *stackframe
+	call *frameInfo // nil for the global frame
+
+	deferred []*TclObj // scripts registered by defer
+}
+
+// frameInfo records the command that pushed a stack frame.
+type frameInfo struct {
+	words []*TclObj
+	loc   loc
 }
 
 func newstackframe(tail *stackframe) *stackframe {
-	return &stackframe{make(varMap), tail}
+	return &stackframe{vars: make(varMap), next: tail}
+}
+
+// level is the number of frames below f, so the global frame is 0.
+func (f *stackframe) level() int {
+	n := 0
+	for ; f.next != nil; f = f.next {
+		n++
+	}
+	return n
 }
 
 type Interp struct {
 	cmds     map[string]TclCmd
-	chans    map[string]interface{}
+	procs    map[string]bool // the commands defined by proc
+	aliases  map[string]bool // the commands defined by interp alias
+	classes  map[string]*tclClass
+	chans    map[string]*tclChan
 	frame    *stackframe
 	retval   *TclObj
 	err      error
 	cmdcount int
 	file     string
 	loc      loc
+
+	allowAbbrev bool
+	stepHook    StepHook
+	coverage    map[loc]int
+	rng         *rand.Rand
+	depth       int
+	maxDepth    int
+	intOverflow IntOverflowMode
+	tests       TestCounts
+	ctx         context.Context
+	exitHandler func(code int)
+	redefHook   func(name string)
+	cmdTraces   map[string][]*tclTrace // added by trace add command
+	gen         *generator             // the generator whose body is running
+	host        *hostTask              // the RunSuspendable script running, if any
+
+	settingErrorCode bool // Fail is setting errorCode
+
+	// the command being invoked, for info level and info frame
+	callWords []*TclObj
+	callLoc   loc
 }
 
 func (i *Interp) Return(val *TclObj) TclStatus {
@@ -324,23 +458,87 @@ func (i *Interp) Return(val *TclObj) TclStatus {
 
 func (i *Interp) Fail(err error) TclStatus {
 	// i.err = fmt.Errorf("%v: %v", i.loc, err)
+	// The code is a new object each time, as errorCode's value may be
+	// used, and its caches filled in, by any interpreter.
+	code := FromStr("NONE")
+	if ce, ok := err.(*codedError); ok {
+		code = ce.code
+	}
+	// errorCode is set like any other variable, so its traces and
+	// links see it. Failing to set it, say because a trace on it
+	// fails, leaves it alone rather than failing again.
+	if !i.settingErrorCode {
+		i.settingErrorCode = true
+		retval := i.retval
+		i.setVar(errorCodeRef, code)
+		i.retval = retval
+		i.settingErrorCode = false
+	}
 	i.err = err
 	return kTclErr
 }
 
+var errorCodeRef = toVarRef("::errorCode")
+
 func (i *Interp) FailStr(msg string) TclStatus {
 	return i.Fail(errors.New(msg))
 }
 
+// FailCode fails with msg, setting errorCode to code. By convention
+// the first element of code names a family of errors, like ARITH or
+// POSIX, and the rest get more specific.
+func (i *Interp) FailCode(code []string, msg string) TclStatus {
+	return i.Fail(&codedError{FromList(code), msg})
+}
+
+// A codedError is an error with a Tcl errorCode.
+type codedError struct {
+	code *TclObj
+	msg  string
+}
+
+func (e *codedError) Error() string { return e.msg }
+
+var posixNames = map[syscall.Errno]string{
+	syscall.EACCES:  "EACCES",
+	syscall.EEXIST:  "EEXIST",
+	syscall.EISDIR:  "EISDIR",
+	syscall.ENOENT:  "ENOENT",
+	syscall.ENOTDIR: "ENOTDIR",
+	syscall.EPERM:   "EPERM",
+}
+
+// posixError gives err an errorCode of the form {POSIX errName msg}
+// if it came from a system call.
+func posixError(err error) error {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return err
+	}
+	name, ok := posixNames[errno]
+	if !ok {
+		name = "E" + strconv.Itoa(int(errno))
+	}
+	return &codedError{FromList([]string{"POSIX", name, errno.Error()}), err.Error()}
+}
+
+// A TclObj is a value along with cached internal representations of it
+// as an integer, float, list and so on. Objects are shared freely, so
+// the value must never change once created: commands that modify a
+// value, like lappend and lset, build a new object, and the only
+// writes to an existing one fill in a cache. Use Dup to get an object
+// whose list can be modified without affecting others.
 type TclObj struct {
-	value      *string
-	intval     int
-	has_intval bool
-	listval    []*TclObj
-	cmdsval    []command
-	vrefval    *varRef
-	exprval    eterm
-	loc        loc
+	value        *string
+	intval       int
+	has_intval   bool
+	floatval     float64
+	has_floatval bool
+	listval      []*TclObj
+	cmdsval      []command
+	vrefval      *varRef
+	exprval      eterm
+	loc          loc
 }
 
 func (t *TclObj) AsString() string {
@@ -348,6 +546,9 @@ func (t *TclObj) AsString() string {
 		if t.has_intval {
 			v := strconv.Itoa(t.intval)
 			t.value = &v
+		} else if t.has_floatval {
+			v := formatFloat(t.floatval)
+			t.value = &v
 		} else if t.listval != nil {
 			var str bytes.Buffer
 			for ind, i := range t.listval {
@@ -373,11 +574,26 @@ func (t *TclObj) AsString() string {
 	return *t.value
 }
 
+// Len returns the number of characters in t's string form, as string
+// length does.
+func (t *TclObj) Len() int {
+	return utf8.RuneCountInString(t.AsString())
+}
+
+// ByteLen returns the number of bytes in t's string form when encoded
+// as UTF-8, as string bytelength does. This is the size to use for
+// binary data and buffers; it differs from Len when t has non-ASCII
+// characters.
+func (t *TclObj) ByteLen() int {
+	return len(t.AsString())
+}
+
 func (t *TclObj) AsInt() (int, error) {
 	if !t.has_intval {
-		v, e := strconv.Atoi(*t.value)
+		s := t.AsString()
+		v, e := parseInt(s)
 		if e != nil {
-			return 0, errors.New("expected integer but got \"" + *t.value + "\"")
+			return 0, errors.New("expected integer but got \"" + s + "\"")
 		}
 		t.has_intval = true
 		t.intval = v
@@ -385,9 +601,106 @@ func (t *TclObj) AsInt() (int, error) {
 	return t.intval, nil
 }
 
+// parseInt parses a decimal integer, or a hexadecimal, octal or
+// binary one with a 0x, 0o or 0b prefix. Surrounding whitespace is
+// ignored, as in Tcl.
+func parseInt(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	sign, digits, base := splitInt(s)
+	if base == 10 {
+		return strconv.Atoi(s)
+	}
+	v, e := strconv.ParseInt(sign+digits, base, strconv.IntSize)
+	return int(v), e
+}
+
+// splitInt splits an integer written as parseInt accepts into its
+// sign, its digits without any base prefix, and the base.
+func splitInt(s string) (sign, digits string, base int) {
+	digits = s
+	if len(digits) > 0 && (digits[0] == '-' || digits[0] == '+') {
+		sign, digits = digits[:1], digits[1:]
+	}
+	base = 10
+	if len(digits) > 2 && digits[0] == '0' {
+		switch digits[1] {
+		case 'x', 'X':
+			base = 16
+		case 'o', 'O':
+			base = 8
+		case 'b', 'B':
+			base = 2
+		}
+		if base != 10 {
+			digits = digits[2:]
+		}
+	}
+	return sign, digits, base
+}
+
+func (t *TclObj) AsFloat() (float64, error) {
+	if !t.has_floatval {
+		if t.has_intval {
+			return float64(t.intval), nil
+		}
+		s := t.AsString()
+		v, e := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if e != nil {
+			return 0, errors.New("expected floating-point number but got \"" + s + "\"")
+		}
+		t.has_floatval = true
+		t.floatval = v
+	}
+	return t.floatval, nil
+}
+
+// floatPrecision is the number of significant digits used to render
+// floats, or 0 for the shortest form that reads back as the same value.
+// Floats render to strings without an interpreter at hand, and objects
+// are shared between interpreters, so like Tcl's it's process-wide:
+// every interpreter's tcl_precision variable reads and sets this one
+// value, and setting it in one changes it for all.
+var floatPrecision int32
+
+func getPrecision() *TclObj {
+	return FromInt(int(atomic.LoadInt32(&floatPrecision)))
+}
+
+func setPrecision(v *TclObj) error {
+	p, e := v.AsInt()
+	if e != nil {
+		return e
+	}
+	if p < 0 || p > 17 {
+		return errors.New("improper value for precision")
+	}
+	atomic.StoreInt32(&floatPrecision, int32(p))
+	return nil
+}
+
+// formatFloat renders f the way Tcl does, always marked as a float.
+func formatFloat(f float64) string {
+	prec := int(atomic.LoadInt32(&floatPrecision))
+	if prec == 0 {
+		prec = -1
+	}
+	s := strconv.FormatFloat(f, 'g', prec, 64)
+	if s == "+Inf" {
+		return "Inf"
+	}
+	if strings.IndexAny(s, ".eIN") == -1 {
+		s += ".0"
+	}
+	return s
+}
+
 func (t *TclObj) asCmds() ([]command, error) {
 	if t.cmdsval == nil {
-		c, e := parseCommands(strings.NewReader(t.AsString()), loc{"<cmds>", 0, 0})
+		l := t.loc
+		if l.file == "" {
+			l = loc{"<cmds>", 0, 0}
+		}
+		c, e := parseCommands(strings.NewReader(t.AsString()), l)
 		if e != nil {
 			return nil, e
 		}
@@ -396,13 +709,29 @@ func (t *TclObj) asCmds() ([]command, error) {
 	return t.cmdsval, nil
 }
 
+// AsBool interprets t as a boolean. Strings that aren't booleans are
+// true.
 func (t *TclObj) AsBool() bool {
-	iv, err := t.AsInt()
-	if err != nil {
-		s := t.AsString()
-		return s != "false" && s != "no"
+	b, err := t.asBool()
+	return b || err != nil
+}
+
+// asBool parses t as a boolean: a number, which is true unless it's 0,
+// or one of true, false, yes, no, on and off in any case.
+func (t *TclObj) asBool() (bool, error) {
+	if iv, err := t.AsInt(); err == nil {
+		return iv != 0, nil
 	}
-	return iv != 0
+	if fv, err := t.AsFloat(); err == nil {
+		return fv != 0, nil
+	}
+	switch strings.ToLower(t.AsString()) {
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off":
+		return false, nil
+	}
+	return false, errors.New("expected boolean value but got \"" + t.AsString() + "\"")
 }
 
 func (t *TclObj) asVarRef() varRef {
@@ -422,16 +751,48 @@ func FromStr(s string) *TclObj {
 }
 
 var kTrue, kFalse *TclObj
+
+// smallInts are shared by every interpreter, so init fills in all
+// their cached representations up front, and using them from several
+// goroutines at once never writes to them.
 var smallInts [256]TclObj
 
 func init() {
 	for i := range smallInts {
 		smallInts[i] = TclObj{intval: i, has_intval: true}
+		freeze(&smallInts[i])
 	}
+	freeze(kNil)
 	kTrue = FromInt(1)
 	kFalse = FromInt(0)
 }
 
+// freeze computes every cached representation of t that it has, so
+// later uses of t only read it. Where those hold an object for t's own
+// string, as its list's one element or a literal in its expr and
+// command forms do, they're made to hold t, so that nothing reachable
+// from t has a cache left to fill in.
+func freeze(t *TclObj) {
+	t.AsString()
+	if l, _ := t.AsList(); len(l) == 1 {
+		t.listval = []*TclObj{t}
+	}
+	t.asVarRef()
+	if e, _ := t.asExpr(); e != nil {
+		if l, ok := e.(*tliteral); ok {
+			l.tval = t
+		}
+	}
+	if cmds, _ := t.asCmds(); len(cmds) == 1 && len(cmds[0].words) == 1 {
+		if l, ok := cmds[0].words[0].(*tliteral); ok {
+			l.tval = t
+		}
+		if sc := cmds[0].simple; sc != nil {
+			sc.words[0] = t
+		}
+	}
+}
+
 func FromInt(i int) *TclObj {
 	if i >= 0 && i < len(smallInts) {
 		return &smallInts[i]
@@ -439,6 +800,10 @@ func FromInt(i int) *TclObj {
 	return &TclObj{intval: i, has_intval: true}
 }
 
+func FromFloat(f float64) *TclObj {
+	return &TclObj{floatval: f, has_floatval: true}
+}
+
 func FromList(l []string) *TclObj {
 	vl := make([]*TclObj, len(l))
 	for i, s := range l {
@@ -455,6 +820,36 @@ func FromIntList(l []int) *TclObj {
 	return fromList(vl)
 }
 
+// ListBuilder collects list elements one at a time and produces a
+// single list object at the end, so that host code building a large
+// list never has the partial list serialized. The zero value is an
+// empty builder ready to use.
+type ListBuilder struct {
+	items []*TclObj
+}
+
+func NewListBuilder() *ListBuilder {
+	return &ListBuilder{}
+}
+
+// Append adds items to the end of the list.
+func (b *ListBuilder) Append(items ...*TclObj) {
+	b.items = append(b.items, items...)
+}
+
+// Len returns the number of elements appended so far.
+func (b *ListBuilder) Len() int {
+	return len(b.items)
+}
+
+// Build returns the list and resets the builder, so that later
+// appends don't alter the returned object.
+func (b *ListBuilder) Build() *TclObj {
+	l := fromList(b.items)
+	b.items = nil
+	return l
+}
+
 var kNil = FromStr("")
 
 func FromBool(b bool) *TclObj {
@@ -477,6 +872,81 @@ func (t *TclObj) AsList() ([]*TclObj, error) {
 	return t.listval, nil
 }
 
+// Dup returns a copy of t that shares no modifiable state with it. The
+// elements of its list are the same objects, but in a new slice, which
+// setElem can change; the copy keeps t's string until it does.
+func (t *TclObj) Dup() *TclObj {
+	d := &TclObj{value: t.value, loc: t.loc}
+	if t.listval != nil {
+		d.listval = make([]*TclObj, len(t.listval))
+		copy(d.listval, t.listval)
+	}
+	if t.has_intval {
+		d.intval, d.has_intval = t.intval, true
+	}
+	if t.has_floatval {
+		d.floatval, d.has_floatval = t.floatval, true
+	}
+	return d
+}
+
+// setElem sets element ind of t's list to v, appending it if ind is
+// the list's length, and drops every other form of t, which no longer
+// match. t must be a list that nothing else holds, as one fresh from
+// Dup is.
+func (t *TclObj) setElem(ind int, v *TclObj) {
+	if ind == len(t.listval) {
+		t.listval = append(t.listval, v)
+	} else {
+		t.listval[ind] = v
+	}
+	t.value = nil
+	t.has_intval, t.has_floatval = false, false
+	t.cmdsval, t.exprval, t.vrefval = nil, nil, nil
+}
+
+// Equal reports whether t and o have the same value. If both are
+// numbers they compare by value, so FromInt(5) equals FromStr("5"),
+// "0x10" equals 16 and "1.0" equals 1; otherwise their string forms
+// are compared, as eq does. A NaN equals nothing, not even itself.
+// Comparing the pointers isn't enough, since equal values are often
+// different objects with different representations.
+func (t *TclObj) Equal(o *TclObj) bool {
+	k1, num1 := t.numKey()
+	k2, num2 := o.numKey()
+	if num1 && num2 {
+		return k1 == k2 && k1 != "NaN"
+	}
+	return t.AsString() == o.AsString()
+}
+
+// HashKey returns a string that is the same for values that are
+// Equal, for use as a Go map key. It is a number's canonical form, or
+// else the string form. All NaNs have the same key.
+func (t *TclObj) HashKey() string {
+	if k, ok := t.numKey(); ok {
+		return k
+	}
+	return t.AsString()
+}
+
+// numKey returns a canonical form of t's value if it's a number:
+// decimal for an integer, or a float with an integer value that fits
+// in an int, and otherwise the shortest form of the float.
+func (t *TclObj) numKey() (string, bool) {
+	if n, e := t.AsInt(); e == nil {
+		return strconv.Itoa(n), true
+	}
+	f, e := t.AsFloat()
+	if e != nil {
+		return "", false
+	}
+	if f == math.Trunc(f) && f >= float64(minInt) && f < -float64(minInt) {
+		return strconv.Itoa(int(f)), true
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64), true
+}
+
 func (t *TclObj) asExpr() (eterm, error) {
 	if t.exprval == nil {
 		ev, err := parseExpr(strings.NewReader(t.AsString()), t.loc)
@@ -501,6 +971,9 @@ func parseList(txt string) ([]*TclObj, error) {
 }
 
 func (i *Interp) EvalObj(obj *TclObj) TclStatus {
+	if obj.isPureList() {
+		return i.evalWords(obj.listval)
+	}
 	cmds, e := obj.asCmds()
 	if e != nil {
 		return i.Fail(e)
@@ -508,12 +981,82 @@ func (i *Interp) EvalObj(obj *TclObj) TclStatus {
 	return i.evalCmds(cmds)
 }
 
+// isPureList reports whether t was made as a list and hasn't needed
+// a string form yet.
+func (t *TclObj) isPureList() bool {
+	return t.value == nil && t.listval != nil
+}
+
+// evalWords runs a single command whose words are already known, as
+// EvalObj does for a pure list. This gives the same result as parsing
+// the list's string form, without making that string and parsing it
+// back, and keeps each element one word even if it has characters the
+// string form doesn't quote.
+func (i *Interp) evalWords(words []*TclObj) TclStatus {
+	if len(words) == 0 {
+		return i.Return(kNil)
+	}
+	if i.ctx != nil && i.ctx.Err() != nil {
+		return i.FailStr("evaluation cancelled: " + i.ctx.Err().Error())
+	}
+	if i.stepHook != nil && !i.stepHook(fromList(words).AsString(), i.loc.String()) {
+		return i.FailStr("evaluation aborted at " + i.loc.String())
+	}
+	i.cmdcount++
+	i.callLoc = i.loc
+	args := make([]*TclObj, len(words))
+	copy(args, words)
+	return i.invoke(args)
+}
+
 type argsig struct {
 	name string
 	def  *TclObj
+	typ  argType
+}
+
+// argType is the type an argument was annotated with in a proc
+// signature, as in {n int}. Arguments of type argAny are not checked.
+type argType int
+
+const (
+	argAny argType = iota
+	argInt
+	argDouble
+	argBool
+	argList
+)
+
+var argTypes = map[string]argType{
+	"string": argAny,
+	"int":    argInt,
+	"double": argDouble,
+	"bool":   argBool,
+	"list":   argList,
+}
+
+// check makes sure v is a valid value of type t, leaving the parsed
+// value cached in v.
+func (t argType) check(v *TclObj) error {
+	var e error
+	switch t {
+	case argInt:
+		_, e = v.AsInt()
+	case argDouble:
+		_, e = v.AsFloat()
+	case argBool:
+		_, e = v.asBool()
+	case argList:
+		_, e = v.AsList()
+	}
+	return e
 }
 
 func (i *Interp) bindArgs(vnames []argsig, args []*TclObj) error {
+	if len(vnames) == 1 && vnames[0].name == "args" {
+		i.frame.vars["args"] = &varEntry{obj: fromList(args)}
+		return nil
+	}
 	lastind := len(vnames) - 1
 	var vr varRef
 	for ix, vn := range vnames {
@@ -521,49 +1064,119 @@ func (i *Interp) bindArgs(vnames []argsig, args []*TclObj) error {
 		if ix == lastind && vn.name == "args" {
 			i.setVar(vr, fromList(args[ix:]))
 			return nil
-		} else if ix >= len(args) {
-			if vn.def == nil {
-				return errors.New("arg count mismatch")
-			}
-			i.setVar(vr, vn.def)
-		} else {
-			i.setVar(vr, args[ix])
 		}
+		v := vn.def
+		if ix < len(args) {
+			v = args[ix]
+		} else if v == nil {
+			return i.argCountError(vnames, args)
+		}
+		if e := vn.typ.check(v); e != nil {
+			return errors.New("bad argument \"" + vn.name + "\": " + e.Error())
+		}
+		i.setVar(vr, v)
+	}
+	if len(args) > len(vnames) {
+		return i.argCountError(vnames, args)
 	}
 	return nil
 }
 
+// argCountError reports a call with the wrong number of args, showing
+// how it should have been made. The words of the call before args,
+// such as a proc's name or an object and method, come from the frame.
+func (i *Interp) argCountError(vnames []argsig, args []*TclObj) error {
+	var usage []string
+	if c := i.frame.call; c != nil && len(c.words) >= len(args) {
+		for _, w := range c.words[:len(c.words)-len(args)] {
+			usage = append(usage, w.AsString())
+		}
+	}
+	for ix, vn := range vnames {
+		switch {
+		case ix == len(vnames)-1 && vn.name == "args":
+			usage = append(usage, "?arg ...?")
+		case vn.def != nil:
+			usage = append(usage, "?"+vn.name+"?")
+		default:
+			usage = append(usage, vn.name)
+		}
+	}
+	return errors.New("wrong # args: should be \"" + strings.Join(usage, " ") + "\"")
+}
+
+// makeArgSigs parses a proc signature. Besides a plain name, an
+// argument may be {name default}, {name type} or {name type default},
+// where type is one of the keys of argTypes. A two-element spec whose
+// second element is not a type keyword is a default value.
 func makeArgSigs(sig []*TclObj) []argsig {
 	sigs := make([]argsig, len(sig))
 	for i, a := range sig {
 		sl, lerr := a.AsList()
-		if lerr == nil && len(sl) == 2 {
-			sigs[i] = argsig{sl[0].AsString(), sl[1]}
-		} else {
+		if lerr != nil || len(sl) < 2 || len(sl) > 3 {
+			sigs[i] = argsig{name: a.AsString()}
+			continue
+		}
+		t, typed := argTypes[sl[1].AsString()]
+		switch {
+		case typed && len(sl) == 3:
+			sigs[i] = argsig{sl[0].AsString(), sl[2], t}
+		case typed:
+			sigs[i] = argsig{name: sl[0].AsString(), typ: t}
+		case len(sl) == 2:
+			sigs[i] = argsig{name: sl[0].AsString(), def: sl[1]}
+		default:
 			sigs[i] = argsig{name: a.AsString()}
 		}
 	}
 	return sigs
 }
 
-func makeProc(sig []*TclObj, body *TclObj) TclCmd {
+// procBody is the parsed form of a proc: its argument
+// signature and the commands of its body.
+type procBody struct {
+	sigs []argsig
+	cmds []command
+}
+
+func newProcBody(sig []*TclObj, body *TclObj) (*procBody, error) {
 	cmds, ce := body.asCmds()
+	if ce != nil {
+		return nil, ce
+	}
+	return &procBody{sigs: makeArgSigs(sig), cmds: cmds}, nil
+}
+
+// call runs the body in a new stack frame. If setup is non-nil,
+// it is called in the new frame before the arguments are bound.
+func (p *procBody) call(i *Interp, args []*TclObj, setup func(*Interp)) TclStatus {
+	i.frame = newstackframe(i.frame)
+	i.frame.call = &frameInfo{i.callWords, i.callLoc}
+	if setup != nil {
+		setup(i)
+	}
+	if be := i.bindArgs(p.sigs, args); be != nil {
+		i.frame = i.frame.next
+		return i.Fail(be)
+	}
+	rc := i.evalCmds(p.cmds)
+	if rc == kTclReturn {
+		rc = kTclOK
+	}
+	if i.frame.deferred != nil {
+		rc = i.runDeferred(rc)
+	}
+	i.frame = i.frame.next
+	return rc
+}
+
+func makeProc(sig []*TclObj, body *TclObj) TclCmd {
+	p, ce := newProcBody(sig, body)
 	if ce != nil {
 		return func(i *Interp, args []*TclObj) TclStatus { return i.Fail(ce) }
 	}
-	sigs := makeArgSigs(sig)
 	return func(i *Interp, args []*TclObj) TclStatus {
-		i.frame = newstackframe(i.frame)
-		if be := i.bindArgs(sigs, args); be != nil {
-			i.frame = i.frame.next
-			return i.Fail(be)
-		}
-		rc := i.evalCmds(cmds)
-		if rc == kTclReturn {
-			rc = kTclOK
-		}
-		i.frame = i.frame.next
-		return rc
+		return p.call(i, args, nil)
 	}
 }
 
@@ -575,58 +1188,238 @@ func tclProc(i *Interp, args []*TclObj) TclStatus {
 	if err != nil {
 		return i.Fail(err)
 	}
-	i.SetCmd(args[0].AsString(), makeProc(sig, args[2]))
+	// Parse the body now, so that a malformed one is reported where the
+	// proc is defined instead of at its first call.
+	p, ce := newProcBody(sig, args[2])
+	if ce != nil {
+		where := ""
+		if l := args[2].loc; l.file != "" {
+			where = " at " + l.String()
+		}
+		return i.FailStr("in body of proc \"" + args[0].AsString() + "\"" + where + ": " + strings.TrimSpace(ce.Error()))
+	}
+	i.SetCmd(args[0].AsString(), func(i *Interp, args []*TclObj) TclStatus {
+		return p.call(i, args, nil)
+	})
+	i.procs[args[0].AsString()] = true
 	return i.Return(kNil)
 }
 
+// error message ?info? ?code?
+func tclError(i *Interp, args []*TclObj) TclStatus {
+	if len(args) < 1 || len(args) > 3 {
+		return i.FailStr("wrong # args: should be \"error message ?errorInfo? ?errorCode?\"")
+	}
+	if len(args) == 3 {
+		return i.Fail(&codedError{args[2], args[0].AsString()})
+	}
+	return i.FailStr(args[0].AsString())
+}
+
 var tclStdin = bufio.NewReader(os.Stdin)
 
 func NewInterp() *Interp {
 	i := new(Interp)
 	i.cmds = make(map[string]TclCmd)
+	i.procs = make(map[string]bool)
+	i.aliases = make(map[string]bool)
+	i.cmdTraces = make(map[string][]*tclTrace)
+	i.classes = make(map[string]*tclClass)
 	i.frame = newstackframe(nil)
-	i.chans = make(map[string]interface{})
-	i.chans["stdin"] = tclStdin
-	i.chans["stdout"] = os.Stdout
-	i.chans["stderr"] = os.Stderr
+	i.maxDepth = kDefaultMaxDepth
+	i.initGlobals()
+	i.initChans()
 
 	for n, f := range tclBasicCmds {
 		i.SetCmd(n, f)
 	}
 
 	i.SetCmd("proc", tclProc)
-	i.SetCmd("error", func(ni *Interp, args []*TclObj) TclStatus { return i.FailStr(args[0].AsString()) })
+	i.SetCmd("error", tclError)
 	return i
 }
 
 func NewInterpFrom(old *Interp) *Interp {
 	i := new(Interp)
 	i.cmds = old.cmds
+	i.procs = old.procs
+	i.aliases = old.aliases
+	i.cmdTraces = old.cmdTraces
+	i.classes = old.classes
 	i.frame = newstackframe(nil)
-	i.chans = make(map[string]interface{})
-	i.chans["stdin"] = tclStdin
-	i.chans["stdout"] = os.Stdout
-	i.chans["stderr"] = os.Stderr
+	i.maxDepth = kDefaultMaxDepth
+	i.initGlobals()
+	i.initChans()
 	return i
 }
 
+func (i *Interp) initChans() {
+	i.chans = make(map[string]*tclChan)
+	i.chans["stdin"] = newChan(tclStdin, nil, nil, "none")
+	i.chans["stdout"] = newChan(nil, os.Stdout, nil, "none")
+	i.chans["stderr"] = newChan(nil, os.Stderr, nil, "none")
+}
+
+func (i *Interp) initGlobals() {
+	i.frame.vars["tcl_precision"] = &varEntry{obj: getPrecision(), onset: setPrecision, onget: getPrecision}
+}
+
 func (i *Interp) SetSource(file string) {
 	i.file = file
 }
 
+// SetAllowAbbrev controls whether a command name may be abbreviated
+// to any unambiguous prefix of an existing command, as in an
+// interactive tclsh. It is off by default.
+func (i *Interp) SetAllowAbbrev(allow bool) {
+	i.allowAbbrev = allow
+}
+
+const kDefaultMaxDepth = 1000
+
+// SetMaxDepth limits how deeply commands may nest: a proc, eval or if
+// and each command it runs count as a level apiece. A call beyond the
+// limit fails with "too many nested evaluations" rather than
+// exhausting the Go stack. The default is 1000.
+func (i *Interp) SetMaxDepth(n int) {
+	i.maxDepth = n
+}
+
+// SetIntOverflowMode sets what expr does when integer arithmetic
+// overflows. The default is IntOverflowWrap.
+func (i *Interp) SetIntOverflowMode(m IntOverflowMode) {
+	i.intOverflow = m
+}
+
+// random returns the interpreter's random number generator.
+// Unless seeded with srand, it is seeded from the clock on first use.
+func (i *Interp) random() *rand.Rand {
+	if i.rng == nil {
+		i.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return i.rng
+}
+
+func (i *Interp) cmdNames() []string {
+	names := make([]string, 0, len(i.cmds))
+	for n := range i.cmds {
+		names = append(names, n)
+	}
+	return names
+}
+
 type TclCmd func(*Interp, []*TclObj) TclStatus
 
 func (i *Interp) SetCmd(name string, cmd TclCmd) {
+	atomic.AddUint64(&cmdGen, 1)
+	delete(i.procs, name)
+	delete(i.aliases, name)
+	delete(i.classes, name)
 	if cmd == nil {
-		delete(i.cmds, name)
-	} else {
-		i.cmds[name] = cmd
+		i.deleteCmd(name)
+		return
+	}
+	if _, ok := i.cmds[name]; ok && i.redefHook != nil {
+		i.redefHook(name)
+	}
+	i.cmds[name] = cmd
+}
+
+// deleteCmd removes the command name and runs its delete traces,
+// returning the error from exit if one of them calls it. SetCmd with a
+// nil command does the same but drops that error.
+func (i *Interp) deleteCmd(name string) error {
+	atomic.AddUint64(&cmdGen, 1)
+	delete(i.procs, name)
+	delete(i.aliases, name)
+	delete(i.classes, name)
+	delete(i.cmds, name)
+	if ts, ok := i.cmdTraces[name]; ok {
+		delete(i.cmdTraces, name)
+		return i.fireCmdTraces(ts, name, "", traceDelete)
+	}
+	return nil
+}
+
+// SetRedefineHook sets a function to call with the name of a command
+// whenever SetCmd, a proc or a rename replaces an existing command,
+// before the replacement. The builtins installed by NewInterp don't
+// count. A nil h removes it.
+func (i *Interp) SetRedefineHook(h func(name string)) {
+	i.redefHook = h
+}
+
+// A StepHook is called before each command of a script is evaluated,
+// with the text of the command and its source location. Returning
+// false aborts evaluation with an error.
+type StepHook func(cmd string, where string) bool
+
+// SetStepHook installs h as the interpreter's step hook.
+// A nil h removes it.
+func (i *Interp) SetStepHook(h StepHook) {
+	i.stepHook = h
+}
+
+// SetExitHandler sets the function that the exit command calls,
+// after flushing the interpreter's channels. If it returns, the
+// script unwinds with an *ExitError. By default, the process exits.
+func (i *Interp) SetExitHandler(h func(code int)) {
+	i.exitHandler = h
+}
+
+// An ExitError is the error from evaluating exit when the exit
+// handler returns.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return "exit " + strconv.Itoa(e.Code)
+}
+
+// isExit reports whether err is from exit. Commands that catch errors,
+// or turn them into errors of their own, pass it on unchanged, so that
+// nothing short of the host stops the script from unwinding.
+func isExit(err error) bool {
+	_, ok := err.(*ExitError)
+	return ok
+}
+
+// SetContext makes evaluation stop with an error once ctx is
+// done. It's checked before each command, and commands that block,
+// like sleep, return early. A nil ctx removes it.
+func (i *Interp) SetContext(ctx context.Context) {
+	i.ctx = ctx
+}
+
+// EnableCoverage starts recording how many times each command is
+// evaluated. Coverage is off by default.
+func (i *Interp) EnableCoverage() {
+	if i.coverage == nil {
+		i.coverage = make(map[loc]int)
 	}
 }
 
+// Coverage returns the number of times each command has been
+// evaluated since EnableCoverage was called, keyed by the command's
+// source location in "file:line:col" form.
+func (i *Interp) Coverage() map[string]int {
+	res := make(map[string]int, len(i.coverage))
+	for l, n := range i.coverage {
+		res[l.String()] = n
+	}
+	return res
+}
+
 func (i *Interp) evalCmds(cmds []command) TclStatus {
 	res := kTclOK
 	for ind := 0; ind < len(cmds) && res == kTclOK; ind++ {
+		if i.ctx != nil && i.ctx.Err() != nil {
+			return i.FailStr("evaluation cancelled: " + i.ctx.Err().Error())
+		}
+		if i.stepHook != nil && !i.stepHook(cmds[ind].String(), cmds[ind].loc.String()) {
+			return i.FailStr("evaluation aborted at " + cmds[ind].loc.String())
+		}
 		res = cmds[ind].eval(i)
 	}
 	return res
@@ -648,8 +1441,12 @@ func (i *Interp) LinkVar(level int, theirs, mine string) {
 		theirf = theirf.next
 		level--
 	}
+	link := &framelink{frame: theirf, name: theirs}
+	if r := toVarRef(theirs); r.arrind != nil {
+		link.name, link.elem = r.name, r.arrind.(*tliteral)
+	}
 	m := i.getVarMap(false)
-	m[mine] = &varEntry{link: &framelink{theirf, theirs}}
+	m[mine] = &varEntry{link: link}
 }
 
 func (i *Interp) SetVarRaw(name string, val *TclObj) {
@@ -659,14 +1456,26 @@ func (i *Interp) SetVarRaw(name string, val *TclObj) {
 func (i *Interp) setVar(vr varRef, val *TclObj) TclStatus {
 	m := i.getVarMap(vr.is_global)
 	if val == nil {
-		delete(m, vr.name)
-		return kTclOK
+		return i.unsetVar(m, vr, false)
+	}
+	sind := ""
+	if vr.arrind != nil {
+		if rc := vr.arrind.Eval(i); rc != kTclOK {
+			return rc
+		}
+		sind = i.retval.AsString()
 	}
 	n := vr.name
 	old, ok := m[n]
 	for ok && old != nil && old.link != nil {
 		m = old.link.frame.vars
 		n = old.link.name
+		if elem := old.link.elem; elem != nil {
+			if vr.arrind != nil {
+				return i.FailStr("can't set \"" + vr.name + "(" + sind + ")\": variable isn't array")
+			}
+			vr.name, vr.arrind, sind = n, elem, elem.strval
+		}
 		old, ok = m[n]
 	}
 	if old == nil {
@@ -676,34 +1485,163 @@ func (i *Interp) setVar(vr varRef, val *TclObj) TclStatus {
 			old.arrdata = make(map[string]*TclObj)
 		}
 	} else {
+		if old.immutable {
+			return i.FailStr("can't set: variable is a constant")
+		}
 		if vr.arrind != nil && old.arrdata == nil {
-			return i.FailStr("can't set: variable is not an array")
+			return i.FailStr("can't set \"" + vr.name + "(" + sind + ")\": variable isn't array")
+		}
+		if vr.arrind == nil && old.arrdata != nil {
+			return i.FailStr("can't set \"" + vr.name + "\": variable is array")
+		}
+		if old.onset != nil && vr.arrind == nil {
+			if e := old.onset(val); e != nil {
+				return i.Fail(e)
+			}
+		}
+		if old.reflected() && vr.arrind == nil {
+			if old.reflect.set == nil {
+				return i.FailStr("can't set \"" + vr.name + "\": variable is read-only")
+			}
+			if _, e := i.callReflect(old, old.reflect.set, val); e != nil {
+				return i.Fail(varError("can't set \""+vr.name+"\"", e))
+			}
 		}
 	}
 	if vr.arrind != nil {
-		rc := vr.arrind.Eval(i)
-		if rc != kTclOK {
-			return rc
-		}
-		sind := i.retval.AsString()
 		old.arrdata[sind] = val
+		if e := i.fireTraces(old, vr.name, sind, traceWrite); e != nil {
+			return i.Fail(varError("can't set \""+vr.name+"("+sind+")\"", e))
+		}
 	} else {
-		old.obj = val
+		if !old.reflected() {
+			old.obj = val
+		}
+		if e := i.fireTraces(old, vr.name, "", traceWrite); e != nil {
+			return i.Fail(varError("can't set \""+vr.name+"\"", e))
+		}
 	}
 	i.retval = val
 	return kTclOK
 }
 
+// unsetVar removes the variable vr names from m, or just an element
+// if vr names one. Unsetting a variable linked in by upvar removes
+// only the link. If complain is false, it's not an error for the
+// variable or element not to exist.
+func (i *Interp) unsetVar(m varMap, vr varRef, complain bool) TclStatus {
+	name := vr.name
+	sind := ""
+	if vr.arrind != nil {
+		if rc := vr.arrind.Eval(i); rc != kTclOK {
+			return rc
+		}
+		sind = i.retval.AsString()
+		name += "(" + sind + ")"
+	}
+	old, ok := m[vr.name]
+	if !ok {
+		if complain {
+			return i.FailStr("can't unset \"" + name + "\": no such variable")
+		}
+		return kTclOK
+	}
+	if old.immutable {
+		return i.FailStr("can't unset: variable is a constant")
+	}
+	if vr.arrind == nil {
+		delete(m, vr.name)
+		if old.link == nil {
+			if e := i.fireTraces(old, vr.name, "", traceUnset); isExit(e) {
+				return i.Fail(e)
+			}
+		}
+		return kTclOK
+	}
+	v := resolveLink(old)
+	if v == nil || v.arrdata == nil {
+		return i.FailStr("can't unset \"" + name + "\": variable isn't array")
+	}
+	if _, ok := v.arrdata[sind]; !ok {
+		if !complain {
+			return kTclOK
+		}
+		return i.FailStr("can't unset \"" + name + "\": no such element in array")
+	}
+	delete(v.arrdata, sind)
+	if e := i.fireTraces(v, vr.name, sind, traceUnset); isExit(e) {
+		return i.Fail(e)
+	}
+	return kTclOK
+}
+
+// varError is err from a trace or reflection command, as the error from
+// what was being done to a variable.
+func varError(what string, err error) error {
+	if isExit(err) {
+		return err
+	}
+	return errors.New(what + ": " + err.Error())
+}
+
 func (i *Interp) GetVarRaw(name string) (*TclObj, error) {
 	return i.getVar(toVarRef(name))
 }
 
+// resolveLink follows v through any upvar or global links, returning
+// the entry that holds its value, or nil if a link leads nowhere or
+// to an array element.
+func resolveLink(v *varEntry) *varEntry {
+	for v != nil && v.link != nil {
+		if v.link.elem != nil {
+			return nil
+		}
+		v = v.link.frame.vars[v.link.name]
+	}
+	return v
+}
+
+// FrameVars returns a snapshot of the scalar variables visible in the
+// current frame, with linked variables showing the values they link to.
+// The map is a copy and is safe to keep after the interpreter moves on.
+func (i *Interp) FrameVars() map[string]*TclObj {
+	vars := make(map[string]*TclObj)
+	for n, v := range i.frame.vars {
+		if v = resolveLink(v); v != nil && v.arrdata == nil && v.obj != nil {
+			vars[n] = v.obj
+			if v.onget != nil {
+				vars[n] = v.onget()
+			}
+		}
+	}
+	return vars
+}
+
+// FrameArrays is like FrameVars but for array variables, mapping each
+// array name to a copy of its elements.
+func (i *Interp) FrameArrays() map[string]map[string]*TclObj {
+	arrs := make(map[string]map[string]*TclObj)
+	for n, v := range i.frame.vars {
+		if v = resolveLink(v); v != nil && v.arrdata != nil {
+			elts := make(map[string]*TclObj, len(v.arrdata))
+			for k, e := range v.arrdata {
+				elts[k] = e
+			}
+			arrs[n] = elts
+		}
+	}
+	return arrs
+}
+
 func (i *Interp) getArray(vr varRef) (*varEntry, error) {
 	v, ok := i.getVarMap(vr.is_global)[vr.name]
 	if !ok {
 		return nil, errors.New("variable not found: " + vr.String())
 	}
 	for v.link != nil {
+		if v.link.elem != nil {
+			return nil, errors.New("not an array")
+		}
 		v, ok = v.link.frame.vars[v.link.name]
 		if !ok {
 			return nil, errors.New("variable not found: " + vr.String())
@@ -715,37 +1653,86 @@ func (i *Interp) getArray(vr varRef) (*varEntry, error) {
 	return v, nil
 }
 
+// A missingVarError is the error getVar gives for a variable or
+// element that doesn't exist, as opposed to one used the wrong way.
+type missingVarError struct{ msg string }
+
+func (e *missingVarError) Error() string { return e.msg }
+
 func (i *Interp) getVar(vr varRef) (*TclObj, error) {
 	v, ok := i.getVarMap(vr.is_global)[vr.name]
 	if !ok {
-		return nil, errors.New("variable not found: " + vr.String())
+		return nil, &missingVarError{"variable not found: " + vr.String()}
 	}
 	for v.link != nil {
-		v, ok = v.link.frame.vars[v.link.name]
-		if !ok {
-			return nil, errors.New("variable not found: " + vr.String())
+		l := v.link
+		if v, ok = l.frame.vars[l.name]; !ok {
+			return nil, &missingVarError{"variable not found: " + vr.String()}
+		}
+		if l.elem != nil {
+			if vr.arrind != nil {
+				if rc := vr.arrind.Eval(i); rc != kTclOK {
+					return nil, i.err
+				}
+				return nil, errors.New("can't read \"" + vr.name + "(" + i.retval.AsString() + ")\": variable isn't array")
+			}
+			vr = varRef{name: l.name, arrind: l.elem}
 		}
 	}
 	if vr.arrind != nil {
-		if v.arrdata == nil {
-			return nil, errors.New("can't get: variable isn't array")
-		}
 		if rc := vr.arrind.Eval(i); rc != kTclOK {
 			return nil, i.err
 		}
 		sind := i.retval.AsString()
+		if v.arrdata == nil {
+			return nil, errors.New("can't read \"" + vr.name + "(" + sind + ")\": variable isn't array")
+		}
+		if e := i.fireTraces(v, vr.name, sind, traceRead); e != nil {
+			return nil, varError("can't read \""+vr.name+"("+sind+")\"", e)
+		}
 		elt, ok := v.arrdata[sind]
+		if !ok && v.defaultVal != nil {
+			return v.defaultVal, nil
+		}
 		if !ok {
-			return nil, errors.New("can't read " + sind + ": no such element in array")
+			return nil, &missingVarError{"can't read \"" + vr.name + "(" + sind + ")\": no such element in array"}
 		}
 		return elt, nil
 	}
 	if v.arrdata != nil {
-		return nil, errors.New("can't get: variable is array")
+		return nil, errors.New("can't read \"" + vr.name + "\": variable is array")
+	}
+	if e := i.fireTraces(v, vr.name, "", traceRead); e != nil {
+		return nil, varError("can't read \""+vr.name+"\"", e)
+	}
+	if v.reflected() {
+		val, e := i.callReflect(v, v.reflect.get)
+		if e != nil {
+			return nil, varError("can't read \""+vr.name+"\"", e)
+		}
+		return val, nil
+	}
+	if v.onget != nil {
+		return v.onget(), nil
+	}
+	if v.obj == nil {
+		return nil, errors.New("can't read \"" + vr.name + "\": no value")
 	}
 	return v.obj, nil
 }
 
+// getVarIfExists is getVar for commands like incr and lappend, which
+// create a variable that's missing: it returns nil and no error if vr
+// doesn't exist, but still fails if it's misused.
+func (i *Interp) getVarIfExists(vr varRef) (*TclObj, error) {
+	v, e := i.getVar(vr)
+	var me *missingVarError
+	if errors.As(e, &me) {
+		return nil, nil
+	}
+	return v, e
+}
+
 func evalArgs(i *Interp, toks []tclTok, no_expand bool) ([]*TclObj, TclStatus) {
 	res := make([]*TclObj, 0, len(toks))
 	rc := kTclOK
@@ -759,8 +1746,7 @@ func evalArgs(i *Interp, toks []tclTok, no_expand bool) ([]*TclObj, TclStatus) {
 		} else {
 			rlist, e := i.retval.AsList()
 			if e != nil {
-				i.err = e
-				return nil, kTclErr
+				return nil, i.FailStr(expandError(t, e))
 			}
 			res = append(res, rlist...)
 		}
@@ -770,45 +1756,98 @@ func evalArgs(i *Interp, toks []tclTok, no_expand bool) ([]*TclObj, TclStatus) {
 
 func (i *Interp) ClearError() { i.err = nil }
 
+// Err returns the error of the last evaluation that failed.
+func (i *Interp) Err() error { return i.err }
+
 func (cmd command) eval(i *Interp) TclStatus {
 	i.cmdcount++
+	if i.coverage != nil {
+		i.coverage[cmd.loc]++
+	}
 	if len(cmd.words) == 0 {
 		return i.Return(kNil)
 	}
 	if cmd.simple != nil {
-		if f, ok := i.cmds[cmd.simple.cmdname]; ok {
-			return f(i, cmd.simple.args)
+		if f := cmd.simple.lookup(i); f != nil {
+			i.callWords, i.callLoc = cmd.simple.words, cmd.loc
+			return i.callCmd(f, cmd.simple.args)
 		}
 	}
 	args, rc := evalArgs(i, cmd.words, cmd.no_expand)
 	if rc != kTclOK {
 		return rc
 	}
+	i.callLoc = cmd.loc
+	return i.invoke(args)
+}
+
+// invoke calls the command named by args[0] with the rest of args,
+// falling back to "unknown" if there is no such command.
+func (i *Interp) invoke(args []*TclObj) TclStatus {
+	i.callWords = args
 	fname := args[0].AsString()
 	if f, ok := i.cmds[fname]; ok {
-		return f(i, args[1:])
+		return i.callCmd(f, args[1:])
+	}
+	if i.allowAbbrev {
+		if m := prefixMatches(i.cmdNames(), fname); len(m) == 1 {
+			return i.callCmd(i.cmds[m[0]], args[1:])
+		} else if len(m) > 1 {
+			return i.FailStr("ambiguous command name \"" + fname + "\": " + formatNames(m))
+		}
 	}
 	if f, ok := i.cmds["unknown"]; ok {
-		return f(i, args)
+		return i.callCmd(f, args)
 	}
 	return i.FailStr("command not found: " + fname)
 }
 
+// callCmd calls f, counting it as a level of nesting. Every command
+// call goes through here, so that recursion by any route, be it procs,
+// eval, aliases or a command calling itself, fails at the limit
+// rather than exhausting the Go stack.
+func (i *Interp) callCmd(f TclCmd, args []*TclObj) TclStatus {
+	if i.depth >= i.maxDepth {
+		return i.FailStr("too many nested evaluations (infinite loop?)")
+	}
+	i.depth++
+	rc := f(i, args)
+	i.depth--
+	return rc
+}
+
 func (i *Interp) EvalString(s string) (*TclObj, error) {
 	return i.Run(strings.NewReader(s))
 }
 
-func (i *Interp) Run(in io.Reader) (*TclObj, error) {
+// Eval evaluates s and returns its result along with the raw status,
+// so that callers can tell a break, continue or return apart from
+// normal completion. The result is nil when the status is TclErr, and
+// the error is then available from Err.
+func (i *Interp) Eval(s string) (*TclObj, TclStatus) {
+	return i.evalReader(strings.NewReader(s))
+}
+
+func (i *Interp) evalReader(in io.Reader) (*TclObj, TclStatus) {
 	cmds, e := parseCommands(bufio.NewReader(in), loc{i.file, 0, 0})
 	if e != nil {
-		return nil, e
+		i.err = e
+		return nil, kTclErr
 	}
 	r := i.evalCmds(cmds)
+	if r == kTclErr {
+		return nil, r
+	}
+	if i.retval == nil {
+		return kNil, r
+	}
+	return i.retval, r
+}
+
+func (i *Interp) Run(in io.Reader) (*TclObj, error) {
+	v, r := i.evalReader(in)
 	if r == kTclOK || r == kTclReturn {
-		if i.retval == nil {
-			return kNil, nil
-		}
-		return i.retval, nil
+		return v, nil
 	}
 	if i.err == nil {
 		var estr string
@@ -0,0 +1,50 @@
+package gotcl
+
+// interp alias srcPath newName targetPath targetCmd ?arg ...?
+// interp alias srcPath newName targetPath
+//
+// The first form creates the command newName, which calls targetCmd
+// with the args followed by its own arguments, so that
+//
+//	interp alias {} warn {} log warning
+//
+// makes "warn msg" the same as "log warning msg". targetCmd is looked
+// up each time newName is called, so redefining it affects the alias.
+// The second form, with no targetCmd, deletes newName, which has to be
+// an alias. There's only the one interpreter, so both paths must be {}.
+func interpAlias(i *Interp, args []*TclObj) TclStatus {
+	if len(args) < 3 {
+		return i.FailStr("wrong # args: should be \"interp alias srcPath newName targetPath ?targetCmd? ?arg ...?\"")
+	}
+	for _, p := range [...]*TclObj{args[0], args[2]} {
+		if p.AsString() != "" {
+			return i.FailStr("could not find interpreter \"" + p.AsString() + "\"")
+		}
+	}
+	name := args[1].AsString()
+	if len(args) == 3 {
+		if !i.aliases[name] {
+			return i.FailStr("alias \"" + name + "\" not found")
+		}
+		if e := i.deleteCmd(name); e != nil {
+			return i.Fail(e)
+		}
+		return i.Return(kNil)
+	}
+	prefix := make([]*TclObj, len(args)-3)
+	copy(prefix, args[3:])
+	i.SetCmd(name, func(i *Interp, args []*TclObj) TclStatus {
+		words := make([]*TclObj, 0, len(prefix)+len(args))
+		return i.invoke(append(append(words, prefix...), args...))
+	})
+	i.aliases[name] = true
+	return i.Return(args[1])
+}
+
+var interpEn = ensembleSpec{
+	"alias": interpAlias,
+}
+
+func init() {
+	RegisterDefaultCmd("interp", interpEn.makeCmd())
+}
@@ -0,0 +1,291 @@
+package gotcl
+
+import (
+	"bufio"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// lint body
+//
+// Checks body, the body of a proc, for likely mistakes without running
+// it, and returns a list with a {location message} pair for each, in
+// order. It reports
+//
+//   - calls to commands that don't exist in this interpreter and aren't
+//     defined by a proc in body
+//   - commands after a return, error, break or continue, which can
+//     never run
+//   - variables that are set but never used
+//
+// The bodies of if, while, for, foreach, catch and the like are
+// checked as well, and those of procs defined in body, each as a
+// separate proc. Since a script can build commands and variable names
+// at run time, only literal names are checked, and variables aren't
+// checked at all in a body that uses eval, uplevel, subst or info,
+// which could use them in ways lint can't see.
+
+// A LintFinding is a problem Lint found in a script.
+type LintFinding struct {
+	Pos Pos
+	Msg string
+}
+
+// Lint checks src, the body of a proc, as lint does, attributing
+// positions to filename.
+func (i *Interp) Lint(src, filename string) ([]LintFinding, error) {
+	return i.lint(src, loc{filename, 0, 0})
+}
+
+func (i *Interp) lint(src string, at loc) ([]LintFinding, error) {
+	cmds, e := parseTree(bufio.NewReader(strings.NewReader(src)), at)
+	if e != nil {
+		return nil, e
+	}
+	l := &linter{i: i, procs: make(map[string]bool)}
+	script := exportCommands(cmds)
+	l.findProcs(script)
+	l.proc(script, nil)
+	sort.SliceStable(l.findings, func(x, y int) bool {
+		px, py := l.findings[x].Pos, l.findings[y].Pos
+		return px.Line < py.Line || px.Line == py.Line && px.Col < py.Col
+	})
+	return l.findings, nil
+}
+
+type linter struct {
+	i        *Interp
+	procs    map[string]bool // defined by proc somewhere in the script
+	findings []LintFinding
+}
+
+// A lintScope tracks the variables of one proc body.
+type lintScope struct {
+	set     map[string]Pos // where each variable was first set
+	used    map[string]bool
+	dynamic bool // the body may use variables in ways lint can't see
+}
+
+// lintDynamic are the commands that make variable use impossible to
+// follow.
+var lintDynamic = map[string]bool{"eval": true, "uplevel": true, "subst": true, "info": true}
+
+// lintStops are the commands that never let the next one run.
+var lintStops = map[string]bool{"return": true, "error": true, "break": true, "continue": true}
+
+// bodies returns the words of c that are scripts it runs, leaving
+// out the body of a proc, which is checked separately.
+func bodies(c Command) []Token {
+	if len(c.Words) == 0 {
+		return nil
+	}
+	texts := make([]string, len(c.Words))
+	for ind, w := range c.Words {
+		texts[ind], _ = literalWord(w)
+	}
+	if texts[0] == "proc" {
+		return nil
+	}
+	var res []Token
+	scripts := scriptPositions(texts)
+	for ind, w := range c.Words {
+		if scripts[ind] {
+			res = append(res, w)
+		}
+	}
+	return res
+}
+
+func literalWord(t Token) (string, bool) {
+	if t.Kind == LiteralToken || t.Kind == BlockToken {
+		return t.Text, true
+	}
+	return "", false
+}
+
+// parseBlock parses the script in the block t, reporting it if it
+// can't be parsed.
+func (l *linter) parseBlock(t Token) ([]Command, bool) {
+	if t.Kind != BlockToken {
+		return nil, false
+	}
+	cmds, e := parseTree(bufio.NewReader(strings.NewReader(t.Text)), loc{t.Pos.File, t.Pos.Line - 1, t.Pos.Col})
+	if e != nil {
+		l.report(t.Pos, strings.TrimSpace(e.Error()))
+		return nil, false
+	}
+	return exportCommands(cmds), true
+}
+
+func (l *linter) report(p Pos, msg string) {
+	l.findings = append(l.findings, LintFinding{p, msg})
+}
+
+// findProcs records the names of the procs defined in script, so
+// calls to them aren't reported whichever comes first.
+func (l *linter) findProcs(script []Command) {
+	for _, c := range script {
+		if len(c.Words) == 0 {
+			continue
+		}
+		name, _ := literalWord(c.Words[0])
+		if name == "proc" && len(c.Words) == 4 {
+			if pn, ok := literalWord(c.Words[1]); ok {
+				l.procs[pn] = true
+			}
+			if body, ok := l.parseBlockQuiet(c.Words[3]); ok {
+				l.findProcs(body)
+			}
+		}
+		for _, w := range bodies(c) {
+			if body, ok := l.parseBlockQuiet(w); ok {
+				l.findProcs(body)
+			}
+		}
+	}
+}
+
+func (l *linter) parseBlockQuiet(t Token) ([]Command, bool) {
+	if t.Kind != BlockToken {
+		return nil, false
+	}
+	cmds, e := parseTree(bufio.NewReader(strings.NewReader(t.Text)), loc{})
+	return exportCommands(cmds), e == nil
+}
+
+// proc checks the body of a proc whose parameters are params.
+func (l *linter) proc(body []Command, params []string) {
+	sc := &lintScope{set: make(map[string]Pos), used: make(map[string]bool)}
+	for _, p := range params {
+		sc.used[p] = true
+	}
+	l.script(body, sc)
+	if sc.dynamic {
+		return
+	}
+	for name, p := range sc.set {
+		if !sc.used[name] {
+			l.report(p, "variable \""+name+"\" is set but never used")
+		}
+	}
+}
+
+func (l *linter) script(cmds []Command, sc *lintScope) {
+	stop := ""
+	for _, c := range cmds {
+		if len(c.Words) == 0 || c.Words[0].Kind == CommentToken {
+			continue
+		}
+		if stop != "" {
+			l.report(c.Pos, "unreachable code after "+stop)
+			stop = ""
+		}
+		l.command(c, sc)
+		if name, _ := literalWord(c.Words[0]); lintStops[name] {
+			stop = name
+		}
+	}
+}
+
+var varRefPattern = regexp.MustCompile(`\$(?:::)?(\w+|\{[^}]*\})`)
+
+func (l *linter) command(c Command, sc *lintScope) {
+	// An empty command substitution, as in [], has no words.
+	if len(c.Words) == 0 {
+		return
+	}
+	name, literal := literalWord(c.Words[0])
+	// Every word that could name a variable, or refer to one, counts
+	// as a use of it, except the name that set assigns to.
+	for ind, w := range c.Words {
+		if _, ok := literalWord(w); ok && ind == 1 && name == "set" && len(c.Words) == 3 {
+			continue
+		}
+		Walk([]Command{{Words: []Token{w}}}, func(t *Token) bool {
+			switch t.Kind {
+			case VarToken:
+				sc.used[strings.TrimPrefix(t.Text, "::")] = true
+			case LiteralToken:
+				sc.used[t.Text] = true
+			case BlockToken:
+				for _, m := range varRefPattern.FindAllStringSubmatch(t.Text, -1) {
+					sc.used[strings.Trim(m[1], "{}")] = true
+				}
+			case SubcommandToken:
+				l.command(*t.Cmd, sc)
+				return false
+			}
+			return true
+		})
+	}
+	if !literal {
+		return
+	}
+	if lintDynamic[name] {
+		sc.dynamic = true
+	}
+	if !l.known(name) {
+		l.report(c.Pos, "unknown command \""+name+"\"")
+	}
+	if name == "set" && len(c.Words) == 3 {
+		if vn, ok := literalWord(c.Words[1]); ok && !strings.Contains(vn, "(") {
+			if _, seen := sc.set[vn]; !seen {
+				sc.set[vn] = c.Words[1].Pos
+			}
+		}
+	}
+	if name == "proc" && len(c.Words) == 4 {
+		if body, ok := l.parseBlock(c.Words[3]); ok {
+			var params []string
+			if sig, e := FromStr(c.Words[2].Text).AsList(); e == nil {
+				for _, p := range sig {
+					if pl, e := p.AsList(); e == nil && len(pl) > 0 {
+						params = append(params, pl[0].AsString())
+					}
+				}
+			}
+			l.proc(body, params)
+		}
+	}
+	for _, w := range bodies(c) {
+		if body, ok := l.parseBlock(w); ok {
+			l.script(body, sc)
+		}
+	}
+}
+
+// known reports whether name is a command that will exist when the
+// script runs.
+func (l *linter) known(name string) bool {
+	if _, ok := l.i.cmds[name]; ok || l.procs[name] {
+		return true
+	}
+	if _, ok := l.i.cmds["unknown"]; ok {
+		return true
+	}
+	return l.i.allowAbbrev && len(prefixMatches(l.i.cmdNames(), name)) == 1
+}
+
+func tclLint(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 1 {
+		return i.FailStr("wrong # args: should be \"lint body\"")
+	}
+	at := args[0].loc
+	if at.file == "" {
+		at.file = "<lint>"
+	}
+	findings, e := i.lint(args[0].AsString(), at)
+	if e != nil {
+		return i.Fail(e)
+	}
+	res := make([]*TclObj, len(findings))
+	for ind, f := range findings {
+		res[ind] = FromList([]string{f.Pos.String(), f.Msg})
+	}
+	return i.Return(fromList(res))
+}
+
+func init() {
+	RegisterDefaultCmd("lint", tclLint)
+}
@@ -0,0 +1,69 @@
+package gotcl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	src := `set unused 1
+set total 0
+foreach x {1 2 3} {
+    set total [expr {$total + $x}]
+    if {$x > 2} {
+        nosuchcmd $x
+        break
+        puts "never"
+    }
+}
+helper $total
+proc helper {n} {
+    set tmp $n
+    return [string length $tmp]
+    set late 1
+}
+while 1 { missing; return }
+return $total
+puts after`
+	findings, err := NewInterp().Lint(src, "t.tcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range findings {
+		got = append(got, f.Pos.String()+" "+f.Msg)
+	}
+	want := []string{
+		`t.tcl:1:5 variable "unused" is set but never used`,
+		`t.tcl:6:9 unknown command "nosuchcmd"`,
+		`t.tcl:8:9 unreachable code after break`,
+		`t.tcl:15:5 unreachable code after return`,
+		`t.tcl:15:9 variable "late" is set but never used`,
+		`t.tcl:17:11 unknown command "missing"`,
+		`t.tcl:19:1 unreachable code after return`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestLintDynamic(t *testing.T) {
+	it := NewInterp()
+	findings, err := it.Lint(`set name 1
+eval {set other 2}
+set x [info exists name]`, "t.tcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 0 {
+		t.Errorf("expected no findings in a body using eval and info, got %v", findings)
+	}
+	for _, src := range []string{"set x []\nputs $x", "puts [ ]", "if {1} { puts [] }"} {
+		if findings, err := it.Lint(src, "t.tcl"); err != nil || len(findings) != 0 {
+			t.Errorf("%q: expected no findings, got %v, %v", src, findings, err)
+		}
+	}
+	if _, err := it.Lint("set x {", "t.tcl"); err == nil {
+		t.Error("expected an error for an unparseable body")
+	}
+}
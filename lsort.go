@@ -0,0 +1,381 @@
+package gotcl
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// lsort ?options? list
+//
+// Options:
+//
+//	-ascii           compare as strings by code point (the default)
+//	-nocase          with -ascii, compare as if both strings were
+//	                 lowercase; the elements themselves are kept as is
+//	-dictionary      compare with dictCompare
+//	-integer         compare as integers
+//	-real            compare as floating-point numbers, so integers
+//	                 and floats can be mixed
+//	-command cmd     compare by calling cmd with two elements; it
+//	                 returns a negative, zero or positive integer
+//	-increasing      sort in ascending order (the default)
+//	-decreasing      sort in descending order
+//	-index ind       compare element ind of each element
+//	-stride n        treat the list as records of n elements, sorted
+//	                 by their first element, or element ind of them
+//	                 with -index
+//	-unique          keep only the last of each run of equal elements
+//	-indices         return the positions the elements had in list,
+//	                 in sorted order, instead of the elements; with
+//	                 -stride, the position of each record's first
+//	                 element
+//
+// The sort is stable.
+type sortOpts struct {
+	compare    func(a, b *TclObj) (int, error)
+	decreasing bool
+	unique     bool
+	indices    bool
+	index      *TclObj
+	stride     int
+}
+
+// sortAbort carries an error out of a comparison, since the sort
+// package gives comparators no way to return one.
+type sortAbort struct{ err error }
+
+func compareStrings(a, b *TclObj) (int, error) {
+	return strings.Compare(a.AsString(), b.AsString()), nil
+}
+
+func compareNocase(a, b *TclObj) (int, error) {
+	return strings.Compare(strings.ToLower(a.AsString()), strings.ToLower(b.AsString())), nil
+}
+
+func compareDict(a, b *TclObj) (int, error) {
+	return dictCompare(a.AsString(), b.AsString()), nil
+}
+
+// dictCompare orders strings the way Tcl's "lsort -dictionary" does.
+// Letters compare case-insensitively, and runs of digits compare as
+// numbers, so "file9" < "File10". Differences in case or in leading
+// zeros only decide between strings that are otherwise equal, with
+// uppercase and fewer zeros first.
+func dictCompare(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	secondary := 0
+	x, y := 0, 0
+	for x < len(ra) && y < len(rb) {
+		if unicode.IsDigit(ra[x]) && unicode.IsDigit(rb[y]) {
+			zx, zy := x, y
+			for x < len(ra)-1 && ra[x] == '0' && unicode.IsDigit(ra[x+1]) {
+				x++
+			}
+			for y < len(rb)-1 && rb[y] == '0' && unicode.IsDigit(rb[y+1]) {
+				y++
+			}
+			if secondary == 0 {
+				secondary = (x - zx) - (y - zy)
+			}
+			// Equal-length digit runs compare by their first difference;
+			// otherwise the longer one is bigger.
+			diff := 0
+			for {
+				dx := x < len(ra) && unicode.IsDigit(ra[x])
+				dy := y < len(rb) && unicode.IsDigit(rb[y])
+				if !dx && !dy {
+					break
+				}
+				if !dx {
+					return -1
+				}
+				if !dy {
+					return 1
+				}
+				if diff == 0 {
+					diff = int(ra[x]) - int(rb[y])
+				}
+				x++
+				y++
+			}
+			if diff != 0 {
+				return sign(diff)
+			}
+			continue
+		}
+		ca, cb := ra[x], rb[y]
+		if la, lb := unicode.ToLower(ca), unicode.ToLower(cb); la != lb {
+			return sign(int(la) - int(lb))
+		}
+		if secondary == 0 && ca != cb {
+			if unicode.IsUpper(ca) {
+				secondary = -1
+			} else {
+				secondary = 1
+			}
+		}
+		x++
+		y++
+	}
+	if x < len(ra) {
+		return 1
+	}
+	if y < len(rb) {
+		return -1
+	}
+	return sign(secondary)
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+func compareInts(a, b *TclObj) (int, error) {
+	i1, i2, e := asInts(a, b)
+	if e != nil {
+		return 0, e
+	}
+	switch {
+	case i1 < i2:
+		return -1, nil
+	case i1 > i2:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func compareFloats(a, b *TclObj) (int, error) {
+	f1, f2, e := asFloats(a, b)
+	if e != nil {
+		return 0, e
+	}
+	switch {
+	case f1 < f2:
+		return -1, nil
+	case f1 > f2:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func commandComparator(i *Interp, prefix []*TclObj) func(a, b *TclObj) (int, error) {
+	return func(a, b *TclObj) (int, error) {
+		words := make([]*TclObj, 0, len(prefix)+2)
+		words = append(append(words, prefix...), a, b)
+		if rc := i.invoke(words); rc != kTclOK {
+			if rc == kTclErr {
+				return 0, i.err
+			}
+			return 0, errors.New("-command returned non-integer result")
+		}
+		r, e := i.retval.AsInt()
+		if e != nil {
+			return 0, errors.New("-command returned non-integer result")
+		}
+		return r, nil
+	}
+}
+
+func (so *sortOpts) key(v *TclObj) *TclObj {
+	if so.index == nil {
+		return v
+	}
+	l, e := v.AsList()
+	if e != nil {
+		panic(sortAbort{e})
+	}
+	ind, e := parseIndex(so.index, len(l))
+	if e != nil {
+		panic(sortAbort{e})
+	}
+	if ind < 0 || ind >= len(l) {
+		panic(sortAbort{errors.New("element " + so.index.AsString() +
+			" missing from sublist \"" + v.AsString() + "\"")})
+	}
+	return l[ind]
+}
+
+func (so *sortOpts) cmp(a, b *TclObj) int {
+	r, e := so.compare(so.key(a), so.key(b))
+	if e != nil {
+		panic(sortAbort{e})
+	}
+	if so.decreasing {
+		return -r
+	}
+	return r
+}
+
+// sort returns the positions of items in sorted order, leaving out
+// those -unique drops, or the first error raised by a comparison.
+func (so *sortOpts) sort(items []*TclObj) (order []int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			sa, ok := r.(sortAbort)
+			if !ok {
+				panic(r)
+			}
+			order, err = nil, sa.err
+		}
+	}()
+	order = make([]int, len(items))
+	for ind := range order {
+		order[ind] = ind
+	}
+	sort.SliceStable(order, func(x, y int) bool {
+		return so.cmp(items[order[x]], items[order[y]]) < 0
+	})
+	if so.unique && len(order) > 0 {
+		out := order[:0]
+		for ind, v := range order {
+			if ind+1 < len(order) && so.cmp(items[v], items[order[ind+1]]) == 0 {
+				continue
+			}
+			out = append(out, v)
+		}
+		order = out
+	}
+	return order, nil
+}
+
+var lsortOptions = []string{"-ascii", "-command", "-decreasing", "-dictionary", "-increasing",
+	"-index", "-indices", "-integer", "-nocase", "-real", "-stride", "-unique"}
+
+// parseSortOpts reads the sorting options in opts for lsort or dict
+// sort, accepting only those in names, which are listed in the error
+// for any other.
+func parseSortOpts(i *Interp, opts []*TclObj, names []string) (*sortOpts, error) {
+	so := &sortOpts{compare: compareStrings}
+	ascii, nocase := true, false
+	for len(opts) > 0 {
+		opt := opts[0].AsString()
+		opts = opts[1:]
+		known := false
+		for _, n := range names {
+			known = known || n == opt
+		}
+		if !known {
+			return nil, errors.New("bad option \"" + opt + "\": must be " + formatNames(names))
+		}
+		switch opt {
+		case "-ascii":
+			so.compare, ascii = compareStrings, true
+		case "-dictionary":
+			so.compare, ascii = compareDict, false
+		case "-integer":
+			so.compare, ascii = compareInts, false
+		case "-real":
+			so.compare, ascii = compareFloats, false
+		case "-nocase":
+			nocase = true
+		case "-increasing":
+			so.decreasing = false
+		case "-decreasing":
+			so.decreasing = true
+		case "-unique":
+			so.unique = true
+		case "-indices":
+			so.indices = true
+		case "-key":
+			so.index = FromInt(0)
+		case "-value":
+			so.index = FromInt(1)
+		case "-command", "-index", "-stride":
+			if len(opts) == 0 {
+				return nil, errors.New("\"" + opt + "\" option must be followed by a value")
+			}
+			switch opt {
+			case "-index":
+				so.index = opts[0]
+			case "-stride":
+				n, e := opts[0].AsInt()
+				if e != nil {
+					return nil, e
+				}
+				if n < 2 {
+					return nil, errors.New("stride length must be at least 2")
+				}
+				so.stride = n
+			default:
+				prefix, e := opts[0].AsList()
+				if e != nil {
+					return nil, e
+				}
+				so.compare, ascii = commandComparator(i, prefix), false
+			}
+			opts = opts[1:]
+		}
+	}
+	if ascii && nocase {
+		so.compare = compareNocase
+	}
+	return so, nil
+}
+
+func tclLsort(i *Interp, args []*TclObj) TclStatus {
+	if len(args) == 0 {
+		return i.FailStr("wrong # args: should be \"lsort ?options? list\"")
+	}
+	so, e := parseSortOpts(i, args[:len(args)-1], lsortOptions)
+	if e != nil {
+		return i.Fail(e)
+	}
+	l, e := args[len(args)-1].AsList()
+	if e != nil {
+		return i.Fail(e)
+	}
+	if so.stride == 0 {
+		order, e := so.sort(l)
+		if e != nil {
+			return i.Fail(e)
+		}
+		sorted := make([]*TclObj, len(order))
+		for ind, pos := range order {
+			if so.indices {
+				sorted[ind] = FromInt(pos)
+			} else {
+				sorted[ind] = l[pos]
+			}
+		}
+		return i.Return(fromList(sorted))
+	}
+	if len(l)%so.stride != 0 {
+		return i.FailStr("list size must be a multiple of the stride length")
+	}
+	if so.index == nil {
+		so.index = FromInt(0)
+	} else if ind, e := parseIndex(so.index, so.stride); e != nil {
+		return i.Fail(e)
+	} else if ind < 0 || ind >= so.stride {
+		return i.FailStr("index \"" + so.index.AsString() + "\" out of range")
+	}
+	records := make([]*TclObj, 0, len(l)/so.stride)
+	for ind := 0; ind < len(l); ind += so.stride {
+		records = append(records, fromList(l[ind:ind+so.stride]))
+	}
+	order, e := so.sort(records)
+	if e != nil {
+		return i.Fail(e)
+	}
+	flat := make([]*TclObj, 0, len(l))
+	for _, pos := range order {
+		if so.indices {
+			flat = append(flat, FromInt(pos*so.stride))
+		} else {
+			flat = append(flat, records[pos].listval...)
+		}
+	}
+	return i.Return(fromList(flat))
+}
+
+func init() {
+	RegisterDefaultCmd("lsort", tclLsort)
+}
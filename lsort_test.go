@@ -0,0 +1,30 @@
+package gotcl
+
+import "testing"
+
+func TestDictCompare(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"file9", "file10", -1},
+		{"file10", "file9", 1},
+		{"abc", "ABD", -1},
+		{"ABC", "abc", -1},
+		{"abc", "abc", 0},
+		{"x01", "x1", 1},
+		{"x1", "x01", -1},
+		{"x01", "x2", -1},
+		{"v1.10.2", "v1.9.7", 1},
+		{"a", "ab", -1},
+		{"", "a", -1},
+		{"a2b", "a2", 1},
+		{"a12b", "a12c", -1},
+		{"Bigbox", "bigboy", -1},
+	}
+	for _, c := range cases {
+		if got := dictCompare(c.a, c.b); got != c.want {
+			t.Errorf("dictCompare(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
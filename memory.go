@@ -0,0 +1,50 @@
+package gotcl
+
+import "runtime"
+
+// memory info
+//
+// Returns a dict of statistics for finding what a script is holding
+// on to: the number of commands and procs, the number of commands
+// evaluated, the open channels, and for each frame from the global
+// one down, the number of variables and of array elements in it. The
+// go* keys come from the Go runtime and cover the whole process.
+func memoryInfo(i *Interp) *TclObj {
+	nframes := i.frame.level() + 1
+	vars := make([]*TclObj, nframes)
+	elts := make([]*TclObj, nframes)
+	ind := nframes - 1
+	for f := i.frame; f != nil; f = f.next {
+		n := 0
+		for _, v := range f.vars {
+			n += len(v.arrdata)
+		}
+		vars[ind] = FromInt(len(f.vars))
+		elts[ind] = FromInt(n)
+		ind--
+	}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return fromList([]*TclObj{
+		FromStr("commands"), FromInt(len(i.cmds)),
+		FromStr("procs"), FromInt(len(i.procs)),
+		FromStr("cmdcount"), FromInt(i.cmdcount),
+		FromStr("channels"), FromInt(len(i.chans)),
+		FromStr("frames"), FromInt(nframes),
+		FromStr("variables"), fromList(vars),
+		FromStr("arrayElements"), fromList(elts),
+		FromStr("goHeapAlloc"), FromInt(int(ms.HeapAlloc)),
+		FromStr("goHeapObjects"), FromInt(int(ms.HeapObjects)),
+		FromStr("goTotalAlloc"), FromInt(int(ms.TotalAlloc)),
+		FromStr("goSys"), FromInt(int(ms.Sys)),
+		FromStr("goNumGC"), FromInt(int(ms.NumGC)),
+	})
+}
+
+var memoryEn = ensembleSpec{
+	"info": memoryInfo,
+}
+
+func init() {
+	RegisterDefaultCmd("memory", memoryEn.makeCmd())
+}
@@ -0,0 +1,237 @@
+package gotcl
+
+import (
+	"fmt"
+)
+
+// A minimal single-inheritance object system.
+//
+//   class create Name ?body?
+//
+// defines a class and a command Name. The body is a script in which
+// these definition commands are available:
+//
+//   superclass Parent          inherit from the class Parent
+//   constructor args body      run when an object is created
+//   method name args body      define a method
+//
+// "Name new ?arg ...?" creates an object with a generated name and
+// "Name create objName ?arg ...?" creates one with the given name. In
+// both cases the constructor (if any) is called with the args, and the
+// object's name is returned. Each object is a command: "obj name ?arg ...?"
+// invokes the method name.
+//
+// Methods and constructors run like procs, with two extra locals:
+// self holds the object's command name, and this is an array holding
+// the object's instance variables.
+//
+// Method resolution: the object's class is searched first, then its
+// superclass, and so on up the chain. The first definition found is
+// used; an overriding method completely replaces the inherited one.
+// Constructors are resolved the same way. Every object also responds to
+// "destroy", which can't be overridden and deletes the object's command
+// and instance variables.
+
+type tclClass struct {
+	name    string
+	super   *tclClass
+	ctor    *procBody
+	methods map[string]*procBody
+}
+
+type tclObject struct {
+	name  string
+	class *tclClass
+	vars  *stackframe
+}
+
+func (c *tclClass) findMethod(name string) *procBody {
+	for ; c != nil; c = c.super {
+		if m, ok := c.methods[name]; ok {
+			return m
+		}
+	}
+	return nil
+}
+
+func (c *tclClass) findCtor() *procBody {
+	for ; c != nil; c = c.super {
+		if c.ctor != nil {
+			return c.ctor
+		}
+	}
+	return nil
+}
+
+func (c *tclClass) methodNames() []string {
+	seen := map[string]bool{"destroy": true}
+	names := []string{"destroy"}
+	for ; c != nil; c = c.super {
+		for n := range c.methods {
+			if !seen[n] {
+				seen[n] = true
+				names = append(names, n)
+			}
+		}
+	}
+	return names
+}
+
+func (c *tclClass) defCmds() map[string]TclCmd {
+	return map[string]TclCmd{
+		"method": func(i *Interp, args []*TclObj) TclStatus {
+			if len(args) != 3 {
+				return i.FailStr("wrong # args: should be \"method name args body\"")
+			}
+			m, e := makeMethod(args[1], args[2])
+			if e != nil {
+				return i.Fail(e)
+			}
+			c.methods[args[0].AsString()] = m
+			return i.Return(kNil)
+		},
+		"constructor": func(i *Interp, args []*TclObj) TclStatus {
+			if len(args) != 2 {
+				return i.FailStr("wrong # args: should be \"constructor args body\"")
+			}
+			m, e := makeMethod(args[0], args[1])
+			if e != nil {
+				return i.Fail(e)
+			}
+			c.ctor = m
+			return i.Return(kNil)
+		},
+		"superclass": func(i *Interp, args []*TclObj) TclStatus {
+			if len(args) != 1 {
+				return i.FailStr("wrong # args: should be \"superclass className\"")
+			}
+			sname := args[0].AsString()
+			super, ok := i.classes[sname]
+			if !ok {
+				return i.FailStr("class \"" + sname + "\" does not exist")
+			}
+			for s := super; s != nil; s = s.super {
+				if s == c {
+					return i.FailStr("attempt to form circular dependency graph")
+				}
+			}
+			c.super = super
+			return i.Return(kNil)
+		},
+	}
+}
+
+func makeMethod(sig, body *TclObj) (*procBody, error) {
+	sl, e := sig.AsList()
+	if e != nil {
+		return nil, e
+	}
+	return newProcBody(sl, body)
+}
+
+// define evaluates a class body with the definition commands
+// temporarily installed, restoring whatever they shadowed afterwards.
+func (c *tclClass) define(i *Interp, body *TclObj) TclStatus {
+	defs := c.defCmds()
+	saved := make(map[string]TclCmd, len(defs))
+	for n, f := range defs {
+		saved[n] = i.cmds[n]
+		i.SetCmd(n, f)
+	}
+	rc := i.EvalObj(body)
+	for n, f := range saved {
+		i.SetCmd(n, f)
+	}
+	return rc
+}
+
+func (c *tclClass) instantiate(i *Interp, name string, args []*TclObj) TclStatus {
+	if _, ok := i.cmds[name]; ok {
+		return i.FailStr("can't create object \"" + name + "\": command already exists with that name")
+	}
+	o := &tclObject{name: name, class: c, vars: newstackframe(nil)}
+	o.vars.vars["this"] = &varEntry{arrdata: make(map[string]*TclObj)}
+	i.SetCmd(name, o.dispatch)
+	if ctor := c.findCtor(); ctor != nil {
+		if rc := o.call(i, ctor, args); rc != kTclOK {
+			i.SetCmd(name, nil)
+			return rc
+		}
+	} else if len(args) != 0 {
+		i.SetCmd(name, nil)
+		return i.FailStr("wrong # args: class \"" + c.name + "\" has no constructor")
+	}
+	return i.Return(FromStr(name))
+}
+
+func (c *tclClass) dispatch(i *Interp, args []*TclObj) TclStatus {
+	if len(args) == 0 {
+		return i.FailStr("wrong # args: should be \"" + c.name + " new|create ?arg ...?\"")
+	}
+	switch args[0].AsString() {
+	case "new":
+		return c.instantiate(i, fmt.Sprintf("oo::obj%d", getUniqueNum()), args[1:])
+	case "create":
+		if len(args) < 2 {
+			return i.FailStr("wrong # args: should be \"" + c.name + " create objName ?arg ...?\"")
+		}
+		return c.instantiate(i, args[1].AsString(), args[2:])
+	}
+	return i.FailStr(fmt.Sprintf("unknown method \"%s\": must be create or new", args[0].AsString()))
+}
+
+// call runs m with self and this bound for o.
+func (o *tclObject) call(i *Interp, m *procBody, args []*TclObj) TclStatus {
+	return m.call(i, args, func(i *Interp) {
+		i.setVar(varRef{name: "self"}, FromStr(o.name))
+		i.frame.vars["this"] = &varEntry{link: &framelink{frame: o.vars, name: "this"}}
+	})
+}
+
+func (o *tclObject) dispatch(i *Interp, args []*TclObj) TclStatus {
+	if len(args) == 0 {
+		return i.FailStr("wrong # args: should be \"" + o.name + " method ?arg ...?\"")
+	}
+	mname := args[0].AsString()
+	if mname == "destroy" {
+		if len(args) != 1 {
+			return i.FailStr("wrong # args: should be \"" + o.name + " destroy\"")
+		}
+		e := i.deleteCmd(o.name)
+		o.vars = newstackframe(nil)
+		if e != nil {
+			return i.Fail(e)
+		}
+		return i.Return(kNil)
+	}
+	m := o.class.findMethod(mname)
+	if m == nil {
+		return i.FailStr(fmt.Sprintf("unknown method \"%s\": must be %s",
+			mname, formatNames(o.class.methodNames())))
+	}
+	return o.call(i, m, args[1:])
+}
+
+func classCreate(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 1 && len(args) != 2 {
+		return i.FailStr("wrong # args: should be \"class create name ?body?\"")
+	}
+	name := args[0].AsString()
+	c := &tclClass{name: name, methods: make(map[string]*procBody)}
+	if len(args) == 2 {
+		if rc := c.define(i, args[1]); rc != kTclOK {
+			return rc
+		}
+	}
+	i.SetCmd(name, c.dispatch)
+	i.classes[name] = c
+	return i.Return(FromStr(name))
+}
+
+var classEn = ensembleSpec{
+	"create": classCreate,
+}
+
+func init() {
+	RegisterDefaultCmd("class", classEn.makeCmd())
+}
@@ -27,7 +27,7 @@ func TestListParse(t *testing.T) {
 }
 
 func verifyParse(t *testing.T, code string) {
-	_, e := parseCommands(strings.NewReader(code))
+	_, e := parseCommands(strings.NewReader(code), loc{"<test>", 0, 0})
 	if e != nil {
 		t.Fatalf("%v should parse, but got %#v", code, e.Error())
 	}
@@ -44,7 +44,7 @@ func TestCommandParsing(t *testing.T) {
 }
 
 func TestCloseBraceExtra(t *testing.T) {
-	_, e := parseCommands(strings.NewReader("if { 1 == 1 }{ puts oh }"))
+	_, e := parseCommands(strings.NewReader("if { 1 == 1 }{ puts oh }"), loc{"<test>", 0, 0})
 	if e == nil {
 		t.Errorf("Expected error, didn't get one.")
 	}
@@ -52,7 +52,7 @@ func TestCloseBraceExtra(t *testing.T) {
 
 func (et exprtest) Run(t *testing.T, vvals map[string]string) {
 	s := et.code
-	exp, e := parseExpr(strings.NewReader(s))
+	exp, e := parseExpr(strings.NewReader(s), loc{"<test>", 0, 0})
 	if e != nil {
 		t.Errorf("%#v → %v\n", s, e)
 	} else {
@@ -141,7 +141,7 @@ func BenchmarkParsing(b *testing.B) {
 	b.StartTimer()
 	for i := 0; i < b.N; i++ {
 		reader := bytes.NewBuffer(data)
-		_, e := parseCommands(reader)
+		_, e := parseCommands(reader, loc{"<bench>", 0, 0})
 		if e != nil {
 			panic(e)
 		}
@@ -158,7 +158,7 @@ func BenchmarkListParsing(b *testing.B) {
 	b.StartTimer()
 	for i := 0; i < b.N; i++ {
 		reader := bytes.NewBuffer(data)
-		_, e := parseListInner(reader)
+		_, e := parseListInner(reader, loc{"<bench>", 0, 0})
 		if e != nil {
 			panic(e)
 		}
@@ -240,3 +240,134 @@ eat ${a b c}
 eat "It is ${a b c}."
     `)
 }
+
+func TestParseIndex(t *testing.T) {
+	cases := []struct {
+		in   string
+		n    int
+		want int
+	}{
+		{"0", 5, 0},
+		{"3", 5, 3},
+		{"-1", 5, -1},
+		{"10", 5, 10},
+		{"end", 5, 4},
+		{"end", 0, -1},
+		{"end-1", 5, 3},
+		{"end-10", 5, -6},
+		{"end+1", 5, 5},
+		{"1+2", 5, 3},
+		{"4-1", 5, 3},
+		{"-1+2", 5, 1},
+	}
+	for _, c := range cases {
+		got, e := parseIndex(FromStr(c.in), c.n)
+		if e != nil {
+			t.Errorf("parseIndex(%q, %d): %v", c.in, c.n, e)
+		} else if got != c.want {
+			t.Errorf("parseIndex(%q, %d) = %d, want %d", c.in, c.n, got, c.want)
+		}
+	}
+	for _, bad := range []string{"", "x", "end-", "end-x", "endx", "1+", "1+x", "+1+", "end--1", "1.5"} {
+		if _, e := parseIndex(FromStr(bad), 5); e == nil {
+			t.Errorf("parseIndex(%q) should fail", bad)
+		}
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	it := NewInterp()
+	it.SetSource("f.tcl")
+	s, e := it.Disassemble("set x [llength \"a $b\"] {*}$l\nputs {$x}")
+	if e != nil {
+		t.Fatal(e)
+	}
+	want := `command f.tcl:1:1 words=4
+  literal f.tcl:1:1 "set"
+  literal f.tcl:1:5 "x"
+  subcommand f.tcl:1:7
+    command f.tcl:1:7 words=2
+      literal f.tcl:1:8 "llength"
+      string f.tcl:1:16 parts=2
+        raw "a "
+        var f.tcl:1:20 "b"
+  expand f.tcl:1:24
+    var f.tcl:1:28 "l"
+command f.tcl:2:1 words=2
+  literal f.tcl:2:1 "puts"
+  block f.tcl:2:6 "$x"
+`
+	if s != want {
+		t.Errorf("got:\n%s\nwant:\n%s", s, want)
+	}
+	if _, e := it.Disassemble("set x {"); e == nil {
+		t.Error("expected a parse error")
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	cmds, e := ParseScript("set x [string length \"$::a($i)\"]; puts {*}$x", "p.tcl")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(cmds) != 2 || len(cmds[0].Words) != 3 || cmds[1].Pos.Line != 1 {
+		t.Fatalf("unexpected commands %+v", cmds)
+	}
+	sub := cmds[0].Words[2]
+	if sub.Kind != SubcommandToken || sub.Cmd.Words[0].Text != "string" {
+		t.Fatalf("unexpected subcommand %+v", sub)
+	}
+	var kinds, vars []string
+	Walk(cmds, func(t *Token) bool {
+		kinds = append(kinds, t.Kind.String())
+		if t.Kind == VarToken {
+			vars = append(vars, t.Text)
+		}
+		return t.Kind != ExpandToken
+	})
+	if got := strings.Join(kinds, " "); got != "literal literal subcommand literal literal string var var literal expand" {
+		t.Errorf("unexpected walk order %s", got)
+	}
+	if got := strings.Join(vars, " "); got != "::a i" {
+		t.Errorf("unexpected vars %s", got)
+	}
+	if _, e := ParseScript("puts [", "bad.tcl"); e == nil {
+		t.Error("expected a parse error")
+	}
+}
+
+func TestCommentRoundTrip(t *testing.T) {
+	src := `# leading comment
+set x "a $b(c) [llength {1 2}]\n" ;# trailing
+  # indented
+puts a\ b\$c {*}$::l ${odd name}
+`
+	cmds, e := ParseScript(src, "c.tcl")
+	if e != nil {
+		t.Fatal(e)
+	}
+	want := `# leading comment
+set x "a $b(c) [llength {1 2}]\n"
+# trailing
+# indented
+puts a\ b\$c {*}$::l ${odd name}
+`
+	out := ScriptString(cmds)
+	if out != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", out, want)
+	}
+	if cmds[2].Words[0].Kind != CommentToken || cmds[2].Pos.Line != 2 {
+		t.Errorf("expected a comment on line 2, got %+v", cmds[2])
+	}
+	again, e := ParseScript(out, "c.tcl")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if ScriptString(again) != out {
+		t.Errorf("round trip changed the script:\n%s", ScriptString(again))
+	}
+	v, e := NewInterp().EvalString("set b(c) B\n" + out[:strings.Index(out, "\n# trailing")])
+	if e != nil || v.AsString() != "a B 2\n" {
+		t.Errorf("emitted script evaluates to %v, %v", v, e)
+	}
+}
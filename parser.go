@@ -22,6 +22,10 @@ type parser struct {
 	tmpbuf *bytes.Buffer
 	ch     rune
 	src    loc
+	off    int // byte offset of ch in the input
+	next   int // byte offset of the rune after ch
+
+	keepComments bool // whether parseCommands returns comments and word spans
 }
 
 func newParser(input io.RuneReader, loc loc) *parser {
@@ -54,7 +58,10 @@ func (p *parser) advance() (result rune) {
 			p.fail(e.Error())
 		}
 		p.ch = -1
+		p.off = p.next
 	} else {
+		p.off = p.next
+		p.next += sz
 		p.src.col += sz
 		if r == '\n' {
 			p.src.col = 0
@@ -148,7 +155,7 @@ func (p *parser) parseSubcommand() *subcommand {
 		p.eatWhile(issepspace)
 	}
 	p.consumeRune(']')
-	return &subcommand{cmd: makeCommand(res), loc: loc}
+	return &subcommand{cmd: makeCommand(res, loc), loc: loc}
 }
 
 func (p *parser) parseBlockData() string {
@@ -191,6 +198,11 @@ func (p *parser) parseBlock() *block {
 	return &block{strval: bd, loc: loc}
 }
 
+// parseBlockOrExpand parses a word starting with a brace. A {*}
+// directly followed by more of the word expands whatever token comes
+// next, be it a variable, a [command], a quoted string or a block. As
+// in Tcl, {*} is only special at the start of a word, so inside quotes
+// or after other characters it is just text.
 func (p *parser) parseBlockOrExpand() tclTok {
 	loc := p.src
 	bd := p.parseBlockData()
@@ -311,9 +323,20 @@ func (p *parser) eatExtra() {
 	}
 }
 
-func (p *parser) parseComment() {
+// parseComment skips a comment, returning it if keepComments is set.
+func (p *parser) parseComment() *comment {
+	loc := p.src
 	p.consumeRune('#')
-	p.eatWhile(func(c rune) bool { return c != '\n' })
+	if !p.keepComments {
+		p.eatWhile(func(c rune) bool { return c != '\n' })
+		return nil
+	}
+	p.tmpbuf.Reset()
+	p.tmpbuf.WriteRune('#')
+	for p.ch != -1 && p.ch != '\n' {
+		p.tmpbuf.WriteRune(p.advance())
+	}
+	return &comment{text: p.tmpbuf.String(), loc: loc}
 }
 
 func (p *parser) parseCommands() []command {
@@ -321,7 +344,9 @@ func (p *parser) parseCommands() []command {
 	p.eatSpace()
 	for p.ch != -1 {
 		if p.ch == '#' {
-			p.parseComment()
+			if c := p.parseComment(); c != nil {
+				res = append(res, command{words: []tclTok{c}, loc: c.loc, endLine: c.loc.line})
+			}
 		} else {
 			res = append(res, p.parseCommand())
 		}
@@ -351,14 +376,25 @@ Loop:
 }
 
 func (p *parser) parseCommand() command {
+	loc := p.src
 	res := make([]tclTok, 0, 16)
-	res = append(res, p.parseToken())
-	p.eatWhile(issepspace)
-	for !isEol(p.ch) {
+	var spans []span
+	for len(res) == 0 || !isEol(p.ch) {
+		start := p.off
 		res = append(res, p.parseToken())
+		if p.keepComments {
+			spans = append(spans, span{start, p.off})
+		}
 		p.eatWhile(issepspace)
 	}
-	return makeCommand(res)
+	c := makeCommand(res, loc)
+	if p.keepComments {
+		c.spans, c.endLine = spans, p.src.line
+		if p.ch == '\n' {
+			c.endLine--
+		}
+	}
+	return c
 }
 
 func (p *parser) parseToken() tclTok {
@@ -398,3 +434,13 @@ func parseCommands(in io.RuneReader, loc loc) (cmds []command, err error) {
 	cmds = p.parseCommands()
 	return
 }
+
+// parseTree is like parseCommands, but keeps comments in the result.
+// Each is a command whose only word is the comment.
+func parseTree(in io.RuneReader, loc loc) (cmds []command, err error) {
+	p := newParser(in, loc)
+	p.keepComments = true
+	defer setError(&err)
+	cmds = p.parseCommands()
+	return
+}
@@ -0,0 +1,155 @@
+package gotcl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// open |command ?arg ...? ?| command ...? ?access?
+//
+// Runs a pipeline of commands, separated by |, and returns a channel
+// connected to it. With access r, the default, reading the channel
+// reads the standard output of the last command; with w, writing it
+// feeds the standard input of the first; r+ does both. Whatever end
+// isn't connected to the channel, along with standard error, is
+// inherited from this process. Closing the channel closes its ends of
+// the pipes and waits for the commands to exit, failing if any of them
+// didn't succeed.
+func openPipe(i *Interp, spec, mode string) TclStatus {
+	read, write := mode == "r", mode == "w"
+	if mode == "r+" {
+		read, write = true, true
+	} else if !read && !write {
+		return i.FailStr("illegal access mode \"" + mode + "\" for a command pipeline")
+	}
+	words, e := FromStr(spec).AsList()
+	if e != nil {
+		return i.Fail(e)
+	}
+	var cmds []*exec.Cmd
+	for len(words) > 0 {
+		n := 0
+		for n < len(words) && words[n].AsString() != "|" {
+			n++
+		}
+		if n == 0 {
+			return i.FailStr("illegal use of | in command")
+		}
+		args := make([]string, n)
+		for ind, w := range words[:n] {
+			args[ind] = w.AsString()
+		}
+		c := exec.Command(args[0], args[1:]...)
+		c.Stderr = os.Stderr
+		cmds = append(cmds, c)
+		if words = words[n:]; len(words) > 0 {
+			if words = words[1:]; len(words) == 0 {
+				return i.FailStr("illegal use of | in command")
+			}
+		}
+	}
+	if len(cmds) == 0 {
+		return i.FailStr("illegal use of | in command")
+	}
+	p := &pipeline{cmds: cmds}
+	var r io.Reader
+	var w io.Writer
+	for ind, c := range cmds[1:] {
+		if c.Stdin, e = cmds[ind].StdoutPipe(); e != nil {
+			return i.Fail(e)
+		}
+	}
+	if write {
+		if p.stdin, e = cmds[0].StdinPipe(); e != nil {
+			return i.Fail(e)
+		}
+		w = p.stdin
+	}
+	if read {
+		if p.stdout, e = cmds[len(cmds)-1].StdoutPipe(); e != nil {
+			return i.Fail(e)
+		}
+		r = p.stdout
+	}
+	if cmds[0].Stdin == nil {
+		cmds[0].Stdin = os.Stdin
+	}
+	if last := cmds[len(cmds)-1]; last.Stdout == nil {
+		last.Stdout = os.Stdout
+	}
+	ch := newChan(r, w, p, "full")
+	for _, c := range cmds {
+		if e := c.Start(); e != nil {
+			p.kill()
+			var ee *exec.Error
+			if errors.As(e, &ee) {
+				e = ee.Err
+			}
+			return i.Fail(posixError(fmt.Errorf("couldn't execute \"%s\": %w", c.Args[0], e)))
+		}
+		ch.pids = append(ch.pids, c.Process.Pid)
+	}
+	name := "file" + strconv.Itoa(getUniqueNum())
+	i.chans[name] = ch
+	return i.Return(FromStrLoc(name, i.loc))
+}
+
+// A pipeline is the running commands behind a channel opened with
+// open |command.
+type pipeline struct {
+	cmds   []*exec.Cmd
+	stdin  io.WriteCloser // nil unless open for writing
+	stdout io.ReadCloser  // nil unless open for reading
+}
+
+// Close closes the channel's ends of the pipes, so the commands see
+// end of file or a broken pipe, then waits for them all to exit.
+func (p *pipeline) Close() error {
+	if p.stdin != nil {
+		p.stdin.Close()
+	}
+	if p.stdout != nil {
+		p.stdout.Close()
+	}
+	var err error
+	for _, c := range p.cmds {
+		if c.Process == nil {
+			continue
+		}
+		if e := c.Wait(); e != nil && err == nil {
+			err = childError(c, e)
+		}
+	}
+	return err
+}
+
+// kill stops the commands that have started, after a later one failed
+// to, so that Close doesn't wait on ones still writing to a pipe that
+// nothing will read.
+func (p *pipeline) kill() {
+	for _, c := range p.cmds {
+		if c.Process != nil {
+			c.Process.Kill()
+		}
+	}
+	p.Close()
+}
+
+// childError describes how a command in a pipeline failed, with an
+// errorCode of {CHILDSTATUS pid code} or {CHILDKILLED pid msg} as in
+// Tcl.
+func childError(c *exec.Cmd, err error) error {
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) {
+		return err
+	}
+	pid := strconv.Itoa(c.Process.Pid)
+	if code := ee.ExitCode(); code >= 0 {
+		return &codedError{FromList([]string{"CHILDSTATUS", pid, strconv.Itoa(code)}), "child process exited abnormally"}
+	}
+	return &codedError{FromList([]string{"CHILDKILLED", pid, ee.Error()}), "child killed: " + ee.Error()}
+}
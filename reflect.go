@@ -0,0 +1,76 @@
+package gotcl
+
+// tcl::var::reflect varName getCmd ?setCmd?
+//
+// Makes varName a computed scalar: reading it runs getCmd and gives
+// its result, and writing it runs setCmd with the new value appended.
+// Without setCmd, the variable is read-only. Traces on the variable
+// still fire, read traces before getCmd runs and write traces after
+// setCmd. Unsetting the variable removes it as usual.
+//
+// While getCmd or setCmd runs, the variable acts as an ordinary one,
+// so the commands can read and write it without calling themselves
+// again; a value stored that way can serve as a cache. Reading the
+// variable then fails if nothing has been stored in it.
+
+type varReflect struct {
+	get, set *TclObj // set is nil for a read-only variable
+	busy     bool    // set while get or set runs
+}
+
+// reflected reports whether reads and writes of v go through its
+// reflection commands.
+func (v *varEntry) reflected() bool {
+	return v.reflect != nil && !v.reflect.busy
+}
+
+// callReflect runs cmd, one of v's reflection commands, with extra
+// appended.
+func (i *Interp) callReflect(v *varEntry, cmd *TclObj, extra ...*TclObj) (*TclObj, error) {
+	words, e := cmd.AsList()
+	if e != nil {
+		return nil, e
+	}
+	args := make([]*TclObj, 0, len(words)+len(extra))
+	args = append(append(args, words...), extra...)
+	v.reflect.busy = true
+	rc := i.invoke(args)
+	v.reflect.busy = false
+	if rc == kTclErr {
+		return nil, i.err
+	}
+	return i.retval, nil
+}
+
+func tclReflectVar(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 2 && len(args) != 3 {
+		return i.FailStr("wrong # args: should be \"tcl::var::reflect varName getCmd ?setCmd?\"")
+	}
+	vr := args[0].asVarRef()
+	if vr.arrind != nil {
+		return i.FailStr("can't reflect \"" + args[0].AsString() + "\": variable is an array element")
+	}
+	for ind, what := range []string{"get", "set"}[:len(args)-1] {
+		cmd, e := args[ind+1].AsList()
+		if e != nil {
+			return i.Fail(e)
+		}
+		if len(cmd) == 0 {
+			return i.FailStr("empty " + what + " command")
+		}
+	}
+	r := &varReflect{get: args[1]}
+	if len(args) == 3 {
+		r.set = args[2]
+	}
+	m := i.getVarMap(vr.is_global)
+	if old, ok := m[vr.name]; ok && old.reflect == nil {
+		return i.FailStr("can't reflect \"" + args[0].AsString() + "\": variable already exists")
+	}
+	m[vr.name] = &varEntry{reflect: r}
+	return i.Return(kNil)
+}
+
+func init() {
+	RegisterDefaultCmd("tcl::var::reflect", tclReflectVar)
+}
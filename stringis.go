@@ -0,0 +1,107 @@
+package gotcl
+
+import (
+	"math/big"
+	"strings"
+	"unicode"
+)
+
+// string is class ?-strict? string
+//
+// Reports whether string is a valid value of class. The empty string
+// is valid for every class unless -strict is given. The integer
+// classes differ in range: integer and wideinteger accept what AsInt
+// does, which is a 64-bit signed integer, while entier accepts an
+// integer of any size, so a value can be checked before it reaches
+// expr and overflows there.
+func strIs(i *Interp, args []*TclObj) TclStatus {
+	if len(args) < 2 || len(args) > 3 {
+		return i.FailStr("wrong # args: should be \"string is class ?-strict? string\"")
+	}
+	class := args[0].AsString()
+	check, ok := stringClasses[class]
+	if !ok {
+		return i.FailStr("bad class \"" + class + "\": must be " + formatNames(stringClassNames()))
+	}
+	strict := false
+	if len(args) == 3 {
+		if opt := args[1].AsString(); opt != "-strict" {
+			return i.FailStr("bad option \"" + opt + "\": must be -strict")
+		}
+		strict = true
+	}
+	v := args[len(args)-1]
+	if v.AsString() == "" {
+		return i.Return(FromBool(!strict))
+	}
+	return i.Return(FromBool(check(v)))
+}
+
+var stringClasses = map[string]func(v *TclObj) bool{
+	"integer":     isInt,
+	"wideinteger": isInt,
+	"entier":      isEntier,
+	"double": func(v *TclObj) bool {
+		_, e := v.AsFloat()
+		return e == nil
+	},
+	"boolean": func(v *TclObj) bool {
+		_, e := v.asBool()
+		return e == nil
+	},
+	"true": func(v *TclObj) bool {
+		b, e := v.asBool()
+		return e == nil && b
+	},
+	"false": func(v *TclObj) bool {
+		b, e := v.asBool()
+		return e == nil && !b
+	},
+	"list": func(v *TclObj) bool {
+		_, e := v.AsList()
+		return e == nil
+	},
+	"alpha":    runeClass(unicode.IsLetter),
+	"digit":    runeClass(unicode.IsDigit),
+	"alnum":    runeClass(func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }),
+	"space":    runeClass(unicode.IsSpace),
+	"upper":    runeClass(unicode.IsUpper),
+	"lower":    runeClass(unicode.IsLower),
+	"punct":    runeClass(unicode.IsPunct),
+	"control":  runeClass(unicode.IsControl),
+	"print":    runeClass(unicode.IsPrint),
+	"ascii":    runeClass(func(r rune) bool { return r <= unicode.MaxASCII }),
+	"xdigit":   runeClass(func(r rune) bool { return strings.ContainsRune("0123456789abcdefABCDEF", r) }),
+	"wordchar": runeClass(func(r rune) bool { return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) }),
+}
+
+func stringClassNames() []string {
+	names := make([]string, 0, len(stringClasses))
+	for n := range stringClasses {
+		names = append(names, n)
+	}
+	return names
+}
+
+// runeClass makes a class of strings whose runes all satisfy f.
+func runeClass(f func(rune) bool) func(v *TclObj) bool {
+	return func(v *TclObj) bool {
+		return strings.IndexFunc(v.AsString(), func(r rune) bool { return !f(r) }) < 0
+	}
+}
+
+func isInt(v *TclObj) bool {
+	_, e := v.AsInt()
+	return e == nil
+}
+
+// isEntier reports whether v is an integer in the syntax AsInt
+// accepts, but of any size.
+func isEntier(v *TclObj) bool {
+	if isInt(v) {
+		return true
+	}
+	sign, digits, base := splitInt(strings.TrimSpace(v.AsString()))
+	_, ok := new(big.Int).SetString(sign+digits, base)
+	return ok
+}
@@ -0,0 +1,134 @@
+package gotcl
+
+import (
+	"errors"
+	"io"
+)
+
+// yieldToHost ?value?
+//
+// Suspends a script started with RunSuspendable, which returns to the
+// host with a *Suspended error carrying value. The host can go on with
+// other work, say the rest of an event loop, and later call Resume,
+// whose argument becomes the result of yieldToHost as the script picks
+// up where it left off.
+//
+// The evaluator is recursive, so the state to resume lives on the Go
+// stack, under however many procs, loops and evals the script is in;
+// capturing it as data would mean rewriting the evaluator. Instead,
+// the script runs on a goroutine of its own, and RunSuspendable and
+// Resume hand control to it and wait until it yields or finishes, as
+// generators do with their bodies. Only one side runs at a time, so
+// the interpreter needs no locking, but while a script is suspended
+// the host must leave the interpreter alone except to Resume or Cancel
+// it. A script that's suspended and then forgotten keeps its goroutine
+// until Cancel is called.
+
+// A Suspended is the error returned by RunSuspendable and Resume when
+// the script calls yieldToHost.
+type Suspended struct {
+	Value *TclObj // the value given to yieldToHost
+	t     *hostTask
+	used  bool
+}
+
+func (s *Suspended) Error() string {
+	return "script suspended by yieldToHost"
+}
+
+type hostTask struct {
+	i      *Interp
+	prev   *hostTask // the task that was running when this one started
+	resume chan *TclObj
+	out    chan hostStep
+}
+
+// A hostStep is what a script hands back to the host: a yielded value,
+// or its result once it finishes.
+type hostStep struct {
+	v        *TclObj
+	err      error
+	finished bool
+}
+
+// RunSuspendable is like Run, but lets the script suspend itself with
+// yieldToHost.
+func (i *Interp) RunSuspendable(in io.Reader) (*TclObj, error) {
+	t := &hostTask{i: i, prev: i.host, resume: make(chan *TclObj), out: make(chan hostStep)}
+	i.host = t
+	go func() {
+		v, e := i.Run(in)
+		t.out <- hostStep{v: v, err: e, finished: true}
+	}()
+	return t.wait()
+}
+
+// wait blocks until the script yields or finishes.
+func (t *hostTask) wait() (*TclObj, error) {
+	step := <-t.out
+	if !step.finished {
+		return nil, &Suspended{Value: step.v, t: t}
+	}
+	t.i.host = t.prev
+	return step.v, step.err
+}
+
+// Resume continues the script, with v as the result of the yieldToHost
+// that suspended it, and returns as RunSuspendable does. A nil v is
+// taken as the empty string.
+func (s *Suspended) Resume(v *TclObj) (*TclObj, error) {
+	if s.used {
+		return nil, errors.New("script already resumed")
+	}
+	s.used = true
+	if v == nil {
+		v = kNil
+	}
+	s.t.resume <- v
+	return s.t.wait()
+}
+
+// Cancel ends the script instead of resuming it: yieldToHost fails
+// with an error, which the script may catch, and any later
+// yieldToHost fails too. Cancel returns the script's result once it
+// finishes.
+func (s *Suspended) Cancel() (*TclObj, error) {
+	if s.used {
+		return nil, errors.New("script already resumed")
+	}
+	s.used = true
+	close(s.t.resume)
+	for {
+		v, e := s.t.wait()
+		if next, ok := e.(*Suspended); ok {
+			s = next
+			s.used = true
+			continue
+		}
+		return v, e
+	}
+}
+
+func tclYieldToHost(i *Interp, args []*TclObj) TclStatus {
+	if len(args) > 1 {
+		return i.FailStr("wrong # args: should be \"yieldToHost ?value?\"")
+	}
+	t := i.host
+	if t == nil {
+		return i.FailStr("yieldToHost can only be called in a script run by RunSuspendable")
+	}
+	v := kNil
+	if len(args) == 1 {
+		v = args[0]
+	}
+	t.out <- hostStep{v: v}
+	r, ok := <-t.resume
+	if !ok {
+		return i.FailStr("script cancelled by the host")
+	}
+	return i.Return(r)
+}
+
+func init() {
+	RegisterDefaultCmd("yieldToHost", tclYieldToHost)
+}
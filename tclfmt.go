@@ -0,0 +1,147 @@
+package gotcl
+
+import (
+	"strings"
+)
+
+// FormatScript reformats src in a canonical layout: one command per
+// line, words separated by single spaces, script bodies of control
+// commands and procs indented by four spaces per level, and at most
+// one blank line between commands. Comments are kept, and a comment
+// that followed a command on the same line stays there. Words other
+// than script bodies are left as written, as are bodies written on a
+// single line.
+func FormatScript(src string) (string, error) {
+	var b strings.Builder
+	if e := formatScript(&b, src, 0); e != nil {
+		return "", e
+	}
+	return b.String(), nil
+}
+
+func isSpace(r rune) bool { return issepspace(r) || r == '\r' }
+
+func formatScript(b *strings.Builder, src string, depth int) error {
+	cmds, e := parseTree(strings.NewReader(src), loc{"<format>", 0, 0})
+	if e != nil {
+		return e
+	}
+	indent := strings.Repeat("    ", depth)
+	for ind, c := range cmds {
+		com := commentText(c)
+		if ind > 0 {
+			prev := cmds[ind-1]
+			if com != "" && c.loc.line == prev.endLine {
+				b.WriteString(" ;" + com)
+				continue
+			}
+			b.WriteByte('\n')
+			if c.loc.line-prev.endLine > 1 {
+				b.WriteByte('\n')
+			}
+		}
+		b.WriteString(indent)
+		if com != "" {
+			b.WriteString(com)
+		} else if e := formatCommand(b, src, c, depth); e != nil {
+			return e
+		}
+	}
+	if len(cmds) > 0 {
+		b.WriteByte('\n')
+	}
+	return nil
+}
+
+// commentText is the text of c if it's a comment, without trailing
+// space, and otherwise "".
+func commentText(c command) string {
+	if com, ok := c.words[0].(*comment); ok {
+		return strings.TrimRightFunc(com.text, isSpace)
+	}
+	return ""
+}
+
+func formatCommand(b *strings.Builder, src string, c command, depth int) error {
+	texts := make([]string, len(c.spans))
+	for ind, s := range c.spans {
+		texts[ind] = src[s.start:s.end]
+	}
+	scripts := scriptPositions(texts)
+	for ind, w := range c.words {
+		if ind > 0 {
+			b.WriteByte(' ')
+		}
+		bl, ok := w.(*block)
+		if !ok || !scripts[ind] || !strings.Contains(bl.strval, "\n") {
+			b.WriteString(texts[ind])
+			continue
+		}
+		b.WriteString("{\n")
+		if e := formatScript(b, bl.strval, depth+1); e != nil {
+			return e
+		}
+		b.WriteString(strings.Repeat("    ", depth) + "}")
+	}
+	return nil
+}
+
+// scriptArgs gives the positions of the words holding scripts for
+// commands that take them, counting the command name as 0. Negative
+// positions count back from the last word.
+var scriptArgs = map[string][]int{
+	"proc":      {3},
+	"while":     {2},
+	"for":       {1, 3, 4},
+	"foreach":   {-1},
+	"catch":     {1},
+	"time":      {1},
+	"uplevel":   {-1},
+	"defer":     {1},
+	"generator": {2},
+}
+
+// scriptPositions reports which words of a command are scripts, given
+// the text of each.
+func scriptPositions(words []string) map[int]bool {
+	scripts := make(map[int]bool)
+	name := words[0]
+	if name == "if" {
+		ifScripts(words, scripts)
+		return scripts
+	}
+	for _, pos := range scriptArgs[name] {
+		if pos < 0 {
+			pos += len(words)
+		}
+		if pos > 0 && pos < len(words) {
+			scripts[pos] = true
+		}
+	}
+	return scripts
+}
+
+// ifScripts marks the bodies in
+// if cond ?then? body ?elseif cond ?then? body ...? ?else? ?body?
+func ifScripts(words []string, scripts map[int]bool) {
+	for pos := 1; pos < len(words); {
+		pos++ // the condition
+		if pos < len(words) && words[pos] == "then" {
+			pos++
+		}
+		scripts[pos] = true
+		if pos++; pos >= len(words) {
+			return
+		}
+		switch words[pos] {
+		case "elseif":
+			pos++
+		case "else":
+			scripts[pos+1] = true
+			return
+		default:
+			scripts[pos] = true
+			return
+		}
+	}
+}
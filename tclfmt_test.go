@@ -0,0 +1,74 @@
+package gotcl
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestFormatScript(t *testing.T) {
+	src := `# header
+proc   f {a  b} {
+  set x   [list $a    $b] ;# why
+      if {$a > 1} {
+ puts hi
+} elseif {$b} then {
+   puts b
+   } else {
+
+
+puts no
+      }
+   foreach x {1 2 3} { puts $x }
+  set d {
+   a 1
+  }
+}
+set y 1; set z 2
+`
+	want := `# header
+proc f {a  b} {
+    set x [list $a    $b] ;# why
+    if {$a > 1} {
+        puts hi
+    } elseif {$b} then {
+        puts b
+    } else {
+        puts no
+    }
+    foreach x {1 2 3} { puts $x }
+    set d {
+   a 1
+  }
+}
+set y 1
+set z 2
+`
+	got, e := FormatScript(src)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if again, _ := FormatScript(got); again != got {
+		t.Errorf("formatting isn't idempotent:\n%s", again)
+	}
+	if _, e := FormatScript("puts {"); e == nil {
+		t.Error("expected a parse error")
+	}
+}
+
+func TestFormatTestSuite(t *testing.T) {
+	src, err := ioutil.ReadFile("test.tcl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatted, err := FormatScript(string(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, e := NewInterp().Run(strings.NewReader(formatted)); e != nil {
+		t.Fatal(e)
+	}
+}
@@ -0,0 +1,169 @@
+package gotcl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A minimal version of Tcl's tcltest package.
+//
+//   test name description ?-setup script? -body script ?-cleanup script?
+//        ?-result expected? ?-returnCodes codes? ?-match exact|glob?
+//
+// runs the body in the caller's frame and compares its result (or
+// error message) to expected, and its completion code to one of codes,
+// which defaults to {ok return}. A mismatch is reported on stdout.
+// If the setup script fails, the body is skipped and the test fails.
+// "cleanupTests" prints a summary line and starts the counts again.
+// The counts are also available from Go with Interp.TestCounts.
+
+// TestCounts holds the results of the test commands run so far.
+type TestCounts struct {
+	Passed, Failed int
+}
+
+// TestCounts returns how many tests have passed and failed.
+func (i *Interp) TestCounts() TestCounts {
+	return i.tests
+}
+
+var returnCodeNames = []string{"ok", "error", "return", "break", "continue"}
+
+func parseReturnCodes(o *TclObj) (map[TclStatus]bool, error) {
+	l, e := o.AsList()
+	if e != nil {
+		return nil, e
+	}
+	codes := make(map[TclStatus]bool, len(l))
+	for _, c := range l {
+		n, e := strconv.Atoi(c.AsString())
+		if e != nil {
+			n = -1
+			for ind, name := range returnCodeNames {
+				if c.AsString() == name {
+					n = ind
+				}
+			}
+			if n < 0 {
+				return nil, fmt.Errorf("bad completion code \"%s\": must be %s, or an integer",
+					c.AsString(), strings.Join(returnCodeNames, ", "))
+			}
+		}
+		codes[TclStatus(n)] = true
+	}
+	return codes, nil
+}
+
+func tclTest(i *Interp, args []*TclObj) TclStatus {
+	if len(args) < 2 || len(args)%2 != 0 {
+		return i.FailStr("wrong # args: should be \"test name description ?-option value ...?\"")
+	}
+	name, desc := args[0].AsString(), args[1].AsString()
+	opts := map[string]*TclObj{
+		"-setup": kNil, "-body": kNil, "-cleanup": kNil, "-result": kNil,
+		"-returnCodes": FromStr("ok return"), "-match": FromStr("exact"),
+	}
+	for ind := 2; ind < len(args); ind += 2 {
+		opt := args[ind].AsString()
+		if _, ok := opts[opt]; !ok {
+			return i.FailStr("bad option \"" + opt + "\": must be -body, -cleanup, -match, -result, -returnCodes, or -setup")
+		}
+		opts[opt] = args[ind+1]
+	}
+	codes, e := parseReturnCodes(opts["-returnCodes"])
+	if e != nil {
+		return i.Fail(e)
+	}
+	match := opts["-match"].AsString()
+	if match != "exact" && match != "glob" {
+		return i.FailStr("bad -match value \"" + match + "\": must be exact or glob")
+	}
+
+	// A failed setup skips the body, and counts as a failure.
+	var problem, result string
+	rc := kTclOK
+	if src := i.EvalObj(opts["-setup"]); src == kTclErr {
+		if isExit(i.err) {
+			return src
+		}
+		problem = "Test setup failed:\n" + i.err.Error()
+	} else {
+		rc = i.EvalObj(opts["-body"])
+		if rc == kTclErr && isExit(i.err) {
+			return rc
+		}
+		if rc == kTclErr {
+			result = i.err.Error()
+		} else if i.retval != nil {
+			result = i.retval.AsString()
+		}
+	}
+	i.ClearError()
+	if crc := i.EvalObj(opts["-cleanup"]); crc == kTclErr && isExit(i.err) {
+		return crc
+	} else if crc == kTclErr && problem == "" {
+		problem = "Test cleanup failed:\n" + i.err.Error()
+	}
+	i.ClearError()
+
+	expected := opts["-result"].AsString()
+	matched := result == expected
+	if match == "glob" {
+		matched = GlobMatch(expected, result)
+	}
+	if problem == "" && !codes[rc] {
+		code := strconv.Itoa(int(rc))
+		if int(rc) < len(returnCodeNames) {
+			code = returnCodeNames[rc]
+		}
+		problem = "---- Return code was: " + code +
+			"\n---- Return code should have been one of: " + opts["-returnCodes"].AsString()
+		if rc == kTclErr {
+			problem += "\n---- errorInfo: " + result
+		}
+	} else if problem == "" && !matched {
+		problem = "---- Result was:\n" + result +
+			"\n---- Result should have been (" + match + " matching):\n" + expected
+	}
+
+	if problem == "" {
+		i.tests.Passed++
+		return i.Return(kNil)
+	}
+	i.tests.Failed++
+	report := fmt.Sprintf("\n==== %s %s FAILED\n==== Contents of test case:\n%s\n%s\n==== %s FAILED\n\n",
+		name, desc, opts["-body"].AsString(), problem, name)
+	if e := i.testOutput(report); e != nil {
+		return i.Fail(e)
+	}
+	return i.Return(kNil)
+}
+
+func tclCleanupTests(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 0 {
+		return i.FailStr("wrong # args: should be \"cleanupTests\"")
+	}
+	t := i.tests
+	summary := fmt.Sprintf("%s:\tTotal\t%d\tPassed\t%d\tSkipped\t0\tFailed\t%d\n",
+		i.file, t.Passed+t.Failed, t.Passed, t.Failed)
+	i.tests = TestCounts{}
+	if e := i.testOutput(summary); e != nil {
+		return i.Fail(e)
+	}
+	return i.Return(kNil)
+}
+
+// testOutput writes s to stdout, which the script may have closed.
+func (i *Interp) testOutput(s string) error {
+	ch, e := i.getChan("stdout")
+	if e != nil {
+		return e
+	}
+	return ch.write(s)
+}
+
+func init() {
+	RegisterDefaultCmd("test", tclTest)
+	RegisterDefaultCmd("cleanupTests", tclCleanupTests)
+}
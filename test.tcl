@@ -85,11 +85,95 @@ test {incr test} {
     assert $x == 11
 }
 
+test {incr special variables} {
+    proc bump {name} {
+        upvar $name v
+        incr v -1
+    }
+    set n 3
+    assert [bump n] == 2
+    assert $n == 2
+    set seen {}
+    proc watch {args} {
+        upvar seen s
+        lappend s [lindex $args 2]
+    }
+    set t 1
+    trace add variable t write watch
+    incr t
+    assert $t == 2
+    assert $seen == write
+    const k 1
+    assert_err { incr k }
+    assert $k == 1
+    set s abc
+    assert_err { incr s }
+    set a(i) 4
+    assert [incr a(i) -2] == 2
+    assert_err { incr a }
+}
+
 test {incr return} {
     set x 5
     assert [incr x] == 6
 }
 
+test {return from nested blocks} {
+    proc find {list want} {
+        foreach x $list {
+            if {$x == $want} {
+                return "found $x"
+            }
+        }
+        return none
+    }
+    assert [find {1 2 3} 2] eq "found 2"
+    assert [find {1 2 3} 5] eq none
+
+    proc deep {} {
+        set n 0
+        while 1 {
+            for {set k 0} {$k < 10} {incr k} {
+                foreach y {a b c} {
+                    if {$k == 3} {
+                        if {$y eq "b"} { return [list $k $y $n] }
+                    }
+                    incr n
+                }
+            }
+            return unreachable
+        }
+    }
+    assert [deep] eq {3 b 10}
+
+    proc fromcatch {} {
+        foreach x {1 2} {
+            catch { if 1 { return early } }
+        }
+        return late
+    }
+    assert [fromcatch] eq late
+
+    proc fromfor {} {
+        for {set k 0} {$k < 3} {incr k} { if {$k == 1} { return [expr {$k * 10}] } }
+        return after
+    }
+    assert [fromfor] == 10
+
+    proc afterloop {} {
+        set seen {}
+        foreach x {1 2 3} {
+            lappend seen $x
+            if {$x == 2} { return $seen }
+        }
+        lappend seen done
+    }
+    assert [afterloop] eq {1 2}
+
+    proc noreturn {} { foreach x {1 2} { set last $x } }
+    assert [noreturn] eq ""
+}
+
 test {return in string} {
     proc somereturn {} {
         set x "foo [return ok]"
@@ -206,6 +290,27 @@ test {lappend} {
     assert [llength $x] == 6
 }
 
+test {modifying copies} {
+    set a [list 1 2 3]
+    set b $a
+    lappend b 4
+    assert $a eq {1 2 3}
+    lset b 0 x
+    assert $a eq {1 2 3}
+    assert $b eq {x 2 3 4}
+    proc take {args} { lappend args extra; return $args }
+    assert [take p q] eq {p q extra}
+    assert [take p q] eq {p q extra}
+    set n 5
+    set m $n
+    incr m
+    assert $n == 5
+    set d {k 1}
+    set e $d
+    dict set e k 2
+    assert [dict get $d k] == 1
+}
+
 test {lappend more} {
     set x [list 1 2]
     set y $x
@@ -223,10 +328,10 @@ test {args} {
 }
 
 test {bad proc} {
-    proc fizzle {x} { " }
-    set ec [catch { fizzle 4 } msg]
+    set ec [catch { proc fizzle {x} { " } } msg]
     assert $ec == 1
-    assert [string index $msg end] == {"}
+    assert [string match {in body of proc "fizzle"*missing "} $msg] == 1
+    assert [has_command fizzle] == 0
 }
 
 test unset_test {
@@ -235,6 +340,42 @@ test unset_test {
     assert [catch { puts $foo }] == 1
 }
 
+test {unset -nocomplain} {
+    assert [catch { unset nosuch } msg] == 1
+    assert $msg eq {can't unset "nosuch": no such variable}
+    unset -nocomplain nosuch
+    array set nc {a 1}
+    assert_err { unset nc(b) }
+    unset -nocomplain nc(b)
+    assert [array size nc] == 1
+    assert [unset -nocomplain] eq {}
+}
+
+test {unset --} {
+    set -x 1
+    set -nocomplain 2
+    unset -- -x
+    assert [info exists -x] == 0
+    unset -nocomplain -- -nocomplain
+    assert [info exists -nocomplain] == 0
+    assert_err { unset -- nosuch2 }
+}
+
+test {unset several names} {
+    set u1 1
+    set u2 2
+    array set u3 {a 1 b 2}
+    unset u1 u2 u3(a)
+    assert [info exists u1] == 0
+    assert [info exists u2] == 0
+    assert [array get u3] eq {b 2}
+    set u4 4
+    assert [catch { unset u4 nosuch u5 } msg] == 1
+    assert [info exists u4] == 0
+    unset -nocomplain u3 nosuch u4
+    assert [array exists u3] == 0
+}
+
 test {catch sets resultvar} {
     catch { expr { 3 + 4 } } woozle
     assert [info exists woozle] == 1
@@ -307,6 +448,25 @@ test {double upvar} {
     assert $foo == 2
 }
 
+test {upvar to an array element} {
+    proc bump {vn} {
+        upvar $vn v
+        incr v
+    }
+    array set hits {a 1}
+    bump hits(a)
+    bump hits(b)
+    assert $hits(a) == 2
+    assert $hits(b) == 1
+    upvar 0 hits(a) ha
+    assert $ha == 2
+    set ha 5
+    assert $hits(a) == 5
+    assert_err { set ha(x) 1 }
+    unset ha
+    assert $hits(a) == 5
+}
+
 test {default arg} {
     proc foo { { x 1 }  { y 0 } } {
         return [+ $x $y]
@@ -317,6 +477,22 @@ test {default arg} {
 }
 
 
+test {typed args} {
+    proc typed {{n int} {x double} {b bool} {l list} {s string}} {
+        return [list [+ $n 1] $x $b [llength $l] $s]
+    }
+    assert [typed 0x10 1.5 yes {a b c} {x y}] eq {17 1.5 yes 3 {x y}}
+    assert [catch { typed abc 1.5 yes {} s } msg] == 1
+    assert $msg eq {bad argument "n": expected integer but got "abc"}
+    assert_err { typed 1 x yes {} s }
+    assert_err { typed 1 1.5 maybe {} s }
+    assert_err { typed 1 1.5 yes "\{" s }
+    proc withdef {{n int 3} {m other}} { return [+ $n [string length $m]] }
+    assert [withdef] == 8
+    assert [withdef 1 ab] == 3
+    assert_err { withdef nope }
+}
+
 test {foreach break} {
     set x {yes no no}
     set y no
@@ -350,18 +526,49 @@ test {string length} {
     assert [string bytelength "xxx"] == 3
     assert [string length "世界"] == 2
     assert [string bytelength "世界"] == 6
+    set mixed "naïve café"
+    assert [string length $mixed] == 10
+    assert [string bytelength $mixed] == 12
+}
+
+test {string insert} {
+    assert [string insert abc 0 X] eq Xabc
+    assert [string insert abc 1 X] eq aXbc
+    assert [string insert abc end X] eq abcX
+    assert [string insert abc end-1 X] eq abXc
+    assert [string insert abc 10 X] eq abcX
+    assert [string insert abc -5 X] eq Xabc
+    assert [string insert 世界 1 "-"] eq 世-界
+    assert [string insert {} 0 new] eq new
+    assert_err { string insert abc foo X }
 }
 
 test {string index} {
     assert [string index "" 4] == ""
     assert [string index "abcdefg" 0] == "a"
     assert [string index "abcdefg" 2] == "c"
+    assert [string index abc 10] eq ""
+    assert [string index abc -1] eq ""
+    assert [string index abc end+1] eq ""
+    assert [string index abc end] eq "c"
+    assert_err { string index abc nonsense }
 }
 
 test {string trim} {
     assert [string trim " X "] == "X"
     assert [string trim "  "] == ""
     assert [string trim foo] == foo
+    set nbsp [format %c 160]
+    set padded "$nbsp\t x y\n$nbsp"
+    assert [string trim $padded] eq "x y"
+    assert [string trimleft $padded] eq "x y\n$nbsp"
+    assert [string trimright $padded] eq "$nbsp\t x y"
+    assert [string trim "[format %c 0x3000]wide[format %c 0x2003]"] eq wide
+    assert [string trim xxhixx x] eq hi
+    assert [string trimleft xxhixx x] eq hixx
+    assert [string trimright "hi!?!" "?!"] eq hi
+    assert [string trim "  hi  " ""] eq "  hi  "
+    assert_err { string trim }
 }
 
 test {string match} {
@@ -394,12 +601,68 @@ test {info exists} {
     assert [info exists x] == 0
 }
 
+test {info locals} {
+    set ::infoglobal 1
+    proc locals_of {a} {
+        upvar 1 outer o
+        set b 2
+        lsort [info locals]
+    }
+    set outer 0
+    assert [locals_of 1] eq {a b}
+    assert [lsearch [info globals] infoglobal] >= 0
+    unset ::infoglobal
+}
+
+test {info procs} {
+    proc zz_one {} {}
+    proc zz_two {} {}
+    assert [lsort [info procs zz_*]] eq {zz_one zz_two}
+    assert [info procs puts] eq {}
+    assert [info commands puts] eq puts
+    rename zz_one zz_three
+    assert [lsort [info procs zz_*]] eq {zz_three zz_two}
+    rename zz_two {}
+    assert [info procs zz_*] eq zz_three
+    rename puts zz_puts
+    set found [info procs zz_*]
+    rename zz_puts puts
+    assert $found eq zz_three
+    rename zz_three {}
+    assert [lsearch [info procs] assert] >= 0
+}
+
+test {memory info} {
+    proc grow {} {
+        array set ::memarr {a 1 b 2 c 3}
+        set local 1
+        memory info
+    }
+    set m [grow]
+    assert [dict get $m frames] == [expr {[info level] + 2}]
+    assert [lindex [dict get $m variables] end] == 1
+    assert [lindex [dict get $m arrayElements] 0] >= 3
+    assert [dict get $m procs] == [llength [info procs]]
+    assert [dict get $m commands] == [llength [info commands]]
+    assert [dict get $m goHeapAlloc] > 0
+    unset ::memarr
+}
+
 test {info cmdcount} {
     set x [info cmdcount]
     set y [info cmdcount]
     assert $x < $y
 }
 
+test {info system} {
+    assert [info tclversion] eq 8.6
+    assert [string match "[info tclversion].*" [info patchlevel]] == 1
+    assert [info tclversion] >= 8.5 "feature gating compares numerically"
+    assert [string length [info hostname]] > 0
+    assert [info library] eq {}
+    assert_err { info hostname x }
+}
+
 test {lsearch} {
     assert [lsearch {a b c d} b] == 1
     assert [lsearch {a b c d} z] == -1
@@ -524,6 +787,339 @@ test {array exsits} {
     expect [array exists x] == 0
 }
 
+test {unset array element} {
+    array set ua {a 1 b 2}
+    unset ua(a)
+    assert [array size ua] == 1
+    assert [info exists ua(b)] == 1
+    assert_err { unset ua(a) }
+    array set empty {}
+    assert [array exists empty] == 1
+    assert [array size empty] == 0
+}
+
+test {variable traces} {
+    set ::log {}
+    proc logger {name elem op} { lappend ::log $op $name $elem }
+    set tv 1
+    trace add variable tv {read write unset} logger
+    set x $tv
+    set tv 2
+    unset tv
+    assert $::log eq {read tv {} write tv {} unset tv {}}
+    assert_err { trace add variable nosuch read logger }
+    assert_err { trace add variable x bogus logger }
+}
+
+test {errorCode traces} {
+    set ::log {}
+    proc ecLogger {name elem op} { lappend ::log $op $name [set ::errorCode] }
+    trace add variable ::errorCode write ecLogger
+    catch { error oops {} {MY CODE} }
+    catch { error again }
+    trace remove variable ::errorCode write ecLogger
+    catch { error quiet {} {NOT LOGGED} }
+    assert $::log eq {write errorCode {MY CODE} write errorCode NONE}
+    assert $::errorCode eq {NOT LOGGED}
+}
+
+test {scalar and array misuse} {
+    set arr(k) 1
+    set sc 1
+    set cases {
+        {set arr}           {can't read "arr": variable is array}
+        {set arr 2}         {can't set "arr": variable is array}
+        {append arr x}      {can't read "arr": variable is array}
+        {lappend arr x}     {can't read "arr": variable is array}
+        {lappend arr}       {can't read "arr": variable is array}
+        {incr arr}          {can't read "arr": variable is array}
+        {set sc(k)}         {can't read "sc(k)": variable isn't array}
+        {set sc(k) 2}       {can't set "sc(k)": variable isn't array}
+        {append sc(k) x}    {can't read "sc(k)": variable isn't array}
+        {lappend sc(k) x}   {can't read "sc(k)": variable isn't array}
+        {incr sc(k)}        {can't read "sc(k)": variable isn't array}
+        {array set sc {k 2}} {can't set "sc(k)": variable isn't array}
+        {set arr(nope)}     {can't read "arr(nope)": no such element in array}
+        {unset sc(k)}       {can't unset "sc(k)": variable isn't array}
+    }
+    foreach {script want} $cases {
+        assert [catch $script msg] == 1
+        assert $msg eq $want
+    }
+    # Nothing was changed by the failed commands
+    assert [array get arr] eq {k 1}
+    assert $sc == 1
+
+    # Missing variables and elements are still created
+    append fresh a b
+    lappend list x
+    incr arr(n)
+    append arr(s) x
+    lappend arr(l) y
+    assert $fresh eq ab
+    assert $list eq x
+    assert [lsort -stride 2 [array get arr]] eq {k 1 l y n 1 s x}
+
+    # array get and array set copy a whole array
+    array set copy [array get arr]
+    assert [lsort -stride 2 [array get copy]] eq [lsort -stride 2 [array get arr]]
+}
+
+test {array default} {
+    array default set count 0
+    foreach w {a b a c a} { incr "count($w)" }
+    assert $count(a) == 3
+    assert $count(c) == 1
+    assert $count(zzz) == 0
+    assert [array size count] == 3
+    assert [array default get count] == 0
+    assert [array default exists count] == 1
+
+    array default set seen {}
+    lappend seen(x) 1
+    lappend seen(x) 2
+    assert $seen(x) eq {1 2}
+    assert $seen(y) eq {}
+
+    # Set elements override the default
+    array set colors {sky blue}
+    array default set colors none
+    assert $colors(sky) eq blue
+    assert $colors(grass) eq none
+    set colors(grass) green
+    assert $colors(grass) eq green
+    array default unset colors
+    assert_err { set colors(sea) }
+    assert [array default exists colors] == 0
+    assert_err { array default get colors }
+
+    set scalar 1
+    assert_err { array default set scalar 0 }
+    assert [catch { array default set count(a) 0 } msg] == 1
+    assert $msg eq {can't array default set "count(a)": variable is an array element}
+    proc peek {vn} { upvar $vn v; return $v }
+    assert [peek count(zzz)] == 0
+    upvar 0 count(yyy) cy
+    assert $cy == 0
+    unset count
+    array set count {}
+    assert_err { set count(a) }
+    assert [array statistics colors] eq "2 entries in table"
+}
+
+test {array traces} {
+    proc square {name elem op} {
+        upvar 1 $name arr
+        array set arr [list $elem [expr {$elem * $elem}]]
+    }
+    array set sq {}
+    trace add variable sq read square
+    assert $sq(4) == 16
+    assert $sq(7) == 49
+    assert [array size sq] == 2
+
+    set ::log {}
+    array set ta {a 1}
+    trace add variable ta {write unset} logger
+    set ta(b) 2
+    unset ta(a)
+    unset ta
+    assert $::log eq {write ta b unset ta a unset ta {}}
+
+    proc refuse {name elem op} { error "read-only" }
+    array set ro {k v}
+    trace add variable ro write refuse
+    assert [catch { set ro(k) w } msg] == 1
+    assert $msg eq {can't set "ro(k)": read-only}
+    assert [trace info variable ro] eq {{write refuse}}
+    trace remove variable ro write refuse
+    set ro(k) w
+    assert $ro(k) eq w
+}
+
+test {defer} {
+    set ::log {}
+    proc cleanup {n} {
+        defer { lappend ::log first }
+        defer [list lappend ::log second $n]
+        lappend ::log body
+        if {$n > 0} { error "failed $n" }
+        return result
+    }
+    assert [cleanup 0] eq result
+    assert $::log eq {body second 0 first}
+
+    set ::log {}
+    assert [catch { cleanup 1 } msg] == 1
+    assert $msg eq {failed 1}
+    assert $::log eq {body second 1 first}
+
+    proc badcleanup {} {
+        defer { error "cleanup failed" }
+        return ok
+    }
+    assert [catch badcleanup msg] == 1
+    assert $msg eq {cleanup failed}
+
+    proc keepfirst {} {
+        defer { lappend ::log ran }
+        defer { error "second error" }
+        error "first error"
+    }
+    set ::log {}
+    assert [catch keepfirst msg] == 1
+    assert $msg eq {first error}
+    assert $::log eq {ran}
+
+    proc inloop {} {
+        foreach x {1 2 3} { defer [list lappend ::log $x] }
+        set local 1
+        defer { lappend ::log [info exists local] }
+    }
+    set ::log {}
+    inloop
+    assert $::log eq {1 3 2 1}
+    assert_err { uplevel #0 { defer { puts never } } }
+}
+
+test {interp alias} {
+    proc greet {greeting name} { return "$greeting, $name" }
+    assert [interp alias {} hello {} greet Hello] eq hello
+    assert [hello world] eq {Hello, world}
+    interp alias {} mylist {} list a
+    assert [mylist b c] eq {a b c}
+    interp alias {} justlist {} list
+    assert [justlist] eq {}
+    proc greet {greeting name} { return "$greeting $name!" }
+    assert [hello again] eq {Hello again!}
+    interp alias {} hello {}
+    assert [catch { hello x }] == 1
+    assert_err { interp alias {} hello {} }
+    assert_err { interp alias other x {} list }
+    assert [catch { interp alias {} puts {} } msg] == 1
+    assert $msg eq {alias "puts" not found}
+    assert [llength [info commands puts]] == 1
+    assert_err { interp alias {} greet {} }
+    interp alias {} short {} list s
+    rename short renamed
+    interp alias {} renamed {}
+    assert [llength [info commands renamed]] == 0
+    interp alias {} replaced {} list r
+    proc replaced {} {}
+    assert_err { interp alias {} replaced {} }
+}
+
+test {lint} {
+    set found [lint {
+        set x 1
+        undefined_thing $x
+        return
+        set y 2
+    }]
+    assert [llength $found] == 3
+    assert [lindex $found 0 1] eq {unknown command "undefined_thing"}
+    assert [lindex $found 1 1] eq {unreachable code after return}
+    assert [lindex $found 2 1] eq {variable "y" is set but never used}
+    assert [string match *:*:* [lindex $found 0 0]] == 1
+    assert [lint { set z 1; return $z }] eq {}
+}
+
+test {eval of pure lists} {
+    set x outer
+    assert [eval [list list {$x} {[error no]} "a b;c"]] eq [list {$x} {[error no]} "a b;c"]
+    assert [eval [list list a] [list {$x}] [list]] eq [list a {$x}]
+    assert [eval "list \$x"] eq outer
+    assert [eval [list list a] {$x}] eq {a outer}
+    assert [eval [list]] eq {}
+    set l [list set evaluated 1]
+    string length $l
+    eval $l
+    assert $evaluated == 1
+    assert [catch { eval [list nosuchcommand x] } msg] == 1
+    assert $msg eq {command not found: nosuchcommand}
+}
+
+test {reflected variables} {
+    set ::ticks 0
+    proc tick {} { incr ::ticks }
+    tcl::var::reflect now tick
+    assert $now == 1
+    assert $now == 2
+    assert [expr {$now + 10}] == 13
+    assert_err { set now 5 }
+
+    # The setter stores the value in the variable itself, which the
+    # getter reads back, doubled.
+    proc getdouble {} { upvar 1 doubled d; expr {$d * 2} }
+    proc setdouble v { upvar 1 doubled d; set d $v }
+    tcl::var::reflect doubled getdouble setdouble
+    assert_err { set doubled }
+    assert [set doubled 4] == 4
+    assert $doubled == 8
+    incr doubled
+    assert $doubled == 18
+    assert_err { tcl::var::reflect ::ticks tick }
+    tcl::var::reflect doubled tick
+    assert $doubled == 4
+    unset doubled
+    assert [info exists doubled] == 0
+    assert [catch { tcl::var::reflect empty {} } msg] == 1
+    assert $msg eq {empty get command}
+    assert [catch { tcl::var::reflect empty tick { } } msg] == 1
+    assert $msg eq {empty set command}
+    assert_err { tcl::var::reflect empty "\{" }
+    assert [info exists empty] == 0
+}
+
+test {redefining commands in a loop} {
+    proc cached {} { return old }
+    set seen {}
+    foreach n {1 2 3} {
+        lappend seen [cached]
+        if {$n == 1} { proc cached {} { return new } }
+        if {$n == 2} { rename cached gone; proc cached {} { return renamed } }
+    }
+    assert $seen eq {old new renamed}
+    assert [gone] eq new
+}
+
+test {command traces} {
+    set ::log {}
+    proc cmdlog {old new op} { lappend ::log $op $old $new }
+    proc traced {} { return ok }
+    trace add command traced {rename delete} cmdlog
+    rename traced traced2
+    assert [traced2] eq ok
+    assert [trace info command traced2] eq {{{rename delete} cmdlog}}
+    rename traced2 {}
+    assert $::log eq {rename traced traced2 delete traced2 {}}
+
+    set ::log {}
+    proc deleted {} {}
+    trace add command deleted delete cmdlog
+    rename deleted moved
+    proc moved {} { return redefined }
+    assert $::log eq {}
+    rename moved {}
+    assert $::log eq {delete moved {}}
+
+    proc quiet {} {}
+    trace add command quiet rename cmdlog
+    trace remove command quiet rename cmdlog
+    rename quiet {}
+    assert $::log eq {delete moved {}}
+    assert_err { trace add command nosuch delete cmdlog }
+    assert_err { trace add command cmdlog bogus cmdlog }
+
+    generator failing { error boom }
+    trace add command failing delete {error tr}
+    assert [catch {failing} m] == 1
+    assert $m eq boom
+    proc quiet {} {}
+    trace add command quiet delete {error tr}
+    assert [rename quiet {}] eq {}
+}
+
 test { expand syntax } {
     set ll {x yes}
     set x no
@@ -532,6 +1128,20 @@ test { expand syntax } {
     expect [list 0 {*}{1 2} 3 {*}{ 4 5 } 6] == {0 1 2 3 4 5 6}
 }
 
+test {expand command substitution} {
+    expect [list {*}[list a b c] d] == {a b c d}
+    expect [llength [list {*}[list a b c]]] == 3
+    {*}[list set y 5]
+    expect $y == 5
+}
+
+test {expand is literal mid-word} {
+    expect [llength [list "{*}[list a b]" x]] == 2
+    expect [lindex [list "{*}[list a b]"] 0] eq "{*}a b"
+    expect [list a{*}b] eq "a{*}b"
+    expect [llength "{*}{a b}"] == 2
+}
+
 test {expand with bad list} {
     assert_err {
     list {*}{ " }
@@ -539,6 +1149,14 @@ test {expand with bad list} {
     }
 }
 
+test {expand error says where} {
+    set bad [format "a %cb" 123]
+    assert [catch { list x {*}$bad } msg] == 1
+    assert [string match {can't expand {\*}$bad at *:*: *unclosed block} $msg] == 1
+    assert [llength [list {*}{} {*}[list] {*}""]] == 0
+    assert [list a {*}{} b] eq {a b}
+}
+
 test {for with bad stuff} {
     assert_err {
         for {set i 0} { $i < 10 } { error "boo" } {
@@ -547,6 +1165,27 @@ test {for with bad stuff} {
     }
 }
 
+test {logical operators give 0 or 1} {
+    assert [expr {5 && 3}] eq 1
+    assert [expr {5 && 0}] eq 0
+    assert [expr {0 || 7}] eq 1
+    assert [expr {0 || 0.0}] eq 0
+    assert [expr {2.5 || 0}] eq 1
+    assert [expr {!0}] eq 1
+    assert [expr {!42}] eq 0
+    assert [expr {!0.5}] eq 0
+    assert [expr {"yes" && "on"}] eq 1
+    assert [expr {!"false"}] eq 1
+    assert [expr {(3 && 4) + (0 || 9)}] eq 2
+    assert [&& 3 4] eq 1
+    assert [|| 0 -2] eq 1
+    assert [catch { expr {"abc" && 1} } msg] == 1
+    assert $msg eq {expected boolean value but got "abc"}
+    assert [catch { expr {0 || "abc"} }] == 1
+    assert [catch { expr {!"abc"} }] == 1
+    assert [expr {0 && "abc"}] eq 0
+}
+
 test {lazy || and && in expr} {
     set x yay
     expr { true || [set x boo] }
@@ -568,6 +1207,1101 @@ test { list parsing again } {
     }
 }
 
+test {class basics} {
+    class create Counter {
+        constructor {{start 0}} {
+            set this(n) $start
+        }
+        method incr {{by 1}} {
+            incr this(n) $by
+        }
+        method get {} {
+            return $this(n)
+        }
+    }
+    set c [Counter new 5]
+    $c incr
+    $c incr 2
+    assert [$c get] == 8
+    Counter create c2
+    c2 incr
+    assert [c2 get] == 1
+    assert [$c get] == 8 "instance vars are per-object"
+    assert_err { c2 frobnicate }
+    assert [catch { c2 get 1 } msg] == 1
+    assert $msg == {wrong # args: should be "c2 get"}
+    c2 destroy
+    assert [has_command c2] == 0
+}
+
+test {class inheritance} {
+    class create Animal {
+        constructor {name} {
+            set this(name) $name
+        }
+        method speak {} {
+            return "..."
+        }
+        method describe {} {
+            return "$this(name) says [$self speak]"
+        }
+    }
+    class create Dog {
+        superclass Animal
+        method speak {} {
+            return woof
+        }
+    }
+    set d [Dog new rex]
+    assert [$d describe] == "rex says woof"
+    assert [[Animal new cat] describe] == "cat says ..."
+    assert_err { class create Bad { superclass NoSuchClass } }
+
+    class create Base { method hi {} { return hi } }
+    rename Base Renamed
+    assert_err { class create Sub { superclass Base } }
+    class create Sub { superclass Renamed }
+    assert [[Sub new] hi] eq hi
+    rename Renamed {}
+    assert_err { class create Sub2 { superclass Renamed } }
+    proc Animal {} {}
+    assert_err { class create Cat { superclass Animal } }
+    rename Animal {}
+}
+
+test {ensemble prefixes} {
+    assert [string len abc] == 3
+    set x 1
+    assert [info ex x] == 1
+    assert [dict k {a 1 b 2}] eq {a b}
+    assert [dict si {a 1 b 2}] == 2
+    assert [catch { dict g {a 1} a } msg] == 1
+    assert [string match {unknown or ambiguous subcommand "g"*} $msg] == 1
+    assert_err { info c }
+    assert_err { string nosuchthing }
+}
+
+test {namespace ensemble create} {
+    namespace ensemble create -command calc -map {add + sub - inc {+ 1}}
+    assert [calc add 1 2] == 3
+    assert [calc s 5 3] == 2
+    assert [calc i 4] == 5
+    assert_err { calc mul 2 3 }
+}
+
+test {expr floats} {
+    assert [expr {1.5 + 1}] == 2.5
+    assert [expr {0.5 * 4}] == 2.0
+    assert [expr {1 / 4.0}] == 0.25
+    assert [expr {2.5 > 2}] == 1
+    assert [expr {int(3.9)}] == 3
+    assert [expr {double(3)}] == 3.0
+}
+
+test {rand and srand} {
+    srand 42
+    set a [expr {rand()}]
+    set b [rand]
+    srand 42
+    assert [rand] == $a "same seed, same sequence"
+    assert [expr {rand()}] == $b
+    for {set i 0} {$i < 20} {incr i} {
+        set r [expr {rand()}]
+        assert_noerr { if {($r < 0.0) || ($r >= 1.0)} { error "out of range: $r" } }
+        set n [expr {int(rand()*10)}]
+        assert_noerr { if {($n < 0) || ($n > 9)} { error "out of range: $n" } }
+    }
+    assert [expr {srand(7)}] == [srand 7]
+}
+
+test {mathop commands} {
+    assert [+] == 0
+    assert [+ 1 2 3 4] == 10
+    assert [* 2 3 4] == 24
+    assert [*] == 1
+    assert [- 10 3 2] == 5
+    assert [- 4] == -4
+    assert [/ 2] == 0.5
+    assert [/ 100 5 2] == 10
+    assert [< 1 2 3] == 1
+    assert [< 1 3 2] == 0
+    assert [>= 3 3 1] == 1
+    assert [== 2 2 2] == 1
+    assert [!= 1 2 1] == 1
+    assert [!= 1 2 2] == 0
+    assert [!=] == 1
+    assert [tcl::mathop::ne a b a] == 1
+    assert [tcl::mathop::ne a a b] == 0
+    assert [tcl::mathop::+ 1 1.5] == 2.5
+    assert_err { + 1 x }
+    assert_err { - }
+}
+
+test {expr math functions} {
+    proc near {a b} { expr {abs($a - $b) < 1e-9} }
+    foreach {e want} {
+        {floor(2.7)} 2.0   {floor(-2.5)} -3.0   {ceil(2.1)} 3.0
+        {fmod(7, 3)} 1.0   {fmod(-7.5, 2)} -1.5 {hypot(3, 4)} 5.0
+        {atan2(1, 1)} 0.7853981633974483       {log(1)} 0.0
+        {log(2.718281828459045)} 1.0           {log10(1000)} 3.0
+        {exp(0)} 1.0       {exp(1)} 2.718281828459045
+        {sin(0)} 0.0       {cos(0)} 1.0         {tan(0.7853981633974483)} 1.0
+        {asin(1)} 1.5707963267948966            {acos(1)} 0.0
+        {atan(1)} 0.7853981633974483            {sinh(0)} 0.0
+        {cosh(0)} 1.0      {tanh(0)} 0.0        {sqrt(2)} 1.4142135623730951
+        {abs(-2.5)} 2.5
+    } {
+        assert [near [expr $e] $want] == 1 $e
+    }
+    assert [expr {round(2.5)}] eq 3
+    assert [expr {round(-2.5)}] eq -3
+    assert [expr {round(2.4)}] eq 2
+    assert [expr {round(7)}] eq 7
+    assert [expr {round(9.2e18)}] eq 9200000000000000000
+    assert [expr {round(-9.2e18)}] eq -9200000000000000000
+    assert [catch { expr {round(1e19)} } msg] == 1
+    assert $msg eq {integer value too large to represent}
+    assert_err { expr {round(-1e19)} }
+    assert_err { expr {round(9223372036854775807.0)} }
+    assert_err { expr {round(1.0 / 0)} }
+    assert [expr {abs(-4)}] eq 4
+    assert [expr {floor(3)}] eq 3.0
+    assert_err { expr {log(-1)} }
+    assert_err { expr {sqrt(-1)} }
+    assert_err { expr {fmod(1)} }
+}
+
+test {expr coercion functions} {
+    foreach {v want} {
+        1 1  0 0  -3 1  0.0 0  2.5 1
+        true 1  false 0  yes 1  no 0  on 1  off 0
+        TRUE 1  False 0  Yes 1  NO 0  On 1  OFF 0
+    } {
+        assert [expr {bool($v)}] == $want "bool($v)"
+    }
+    assert [expr {bool("yes")}] == 1
+    assert_err { expr {bool("maybe")} }
+    assert_err { expr {bool("")} }
+    assert [expr {entier(3.9)}] eq 3
+    assert [expr {entier(-3.9)}] eq -3
+    assert [expr {entier(12)}] eq 12
+    assert [expr {wide(7.2)}] eq 7
+    assert [expr {wide(1 << 40)}] eq 1099511627776
+    assert_err { expr {entier("x")} }
+    assert [expr {entier(-9.2e18)}] eq -9200000000000000000
+    assert [catch { expr {entier(1e19)} } msg] == 1
+    assert $msg eq {integer value too large to represent}
+    assert_err { expr {int(-1e19)} }
+    assert_err { expr {wide(1.0 / 0)} }
+    assert [catch { expr {entier("NaN")} } msg] == 1
+    assert $msg eq {domain error: argument not in valid range}
+    assert [expr {"off" ? 1 : 2}] == 2
+}
+
+test {tcl::mathfunc} {
+    assert [tcl::mathfunc::max 1 5 3] == 5
+    proc tcl::mathfunc::square {x} {
+        return [* $x $x]
+    }
+    assert [expr {square(4) + 1}] == 17
+    assert_err { expr {nosuchfunc(1)} }
+    assert_err { expr {int()} }
+}
+
+test {tcl_precision} {
+    assert [expr {0.1 + 0.2}] == 0.30000000000000004
+    assert [expr {1.0 * 2}] == 2.0
+    set ::tcl_precision 3
+    assert [expr {1.0 / 3}] == 0.333
+    assert [expr {2.0 * 1}] == 2.0
+    set ::tcl_precision 0
+    assert [expr {1.0 / 4}] == 0.25
+    assert_err { set ::tcl_precision 40 }
+    assert_err { set ::tcl_precision abc }
+    assert $::tcl_precision == 0
+}
+
+test {errorCode} {
+    catch { error oops }
+    assert $::errorCode == NONE
+    catch { error oops {} {MY THING 3} }
+    assert [lindex $::errorCode 0] == MY
+    assert [lindex $::errorCode 2] == 3
+    assert [catch { open /no/such/dir/file.txt } msg] == 1
+    assert [lindex $::errorCode 0] == POSIX
+    assert [lindex $::errorCode 1] == ENOENT
+}
+
+test {divide by zero} {
+    assert [catch { expr {1/0} } msg] == 1
+    assert $msg == "divide by zero"
+    assert [lindex $::errorCode 0] == ARITH
+    assert [catch { expr {7 % 0} }] == 1
+    assert [lindex $::errorCode 1] == DIVZERO
+    assert [catch { / 4 0 }] == 1
+    assert [expr {7 % 3}] == 1
+    assert [expr {-7 % 2}] == 1
+    assert [expr {-7 / 2}] == -4
+    assert [expr {1.0 / 0}] == Inf
+}
+
+test {integers at the 64-bit limits} {
+    set max [expr {0x7fffffffffffffff}]
+    assert $max eq 9223372036854775807
+    assert [format %x $max] eq 7fffffffffffffff
+    assert [format %d [expr {$max - 1}]] eq 9223372036854775806
+    set min [expr {0 - $max - 1}]
+    assert $min eq -9223372036854775808
+    assert [format %x [expr {$min + $max}]] eq ffffffffffffffff
+    assert [string range $min 0 end] == $min
+    assert [expr {[string range $max 0 end] - $max}] == 0
+    assert [expr {0b101}] eq 5
+    assert [expr {-0x10}] eq -16
+    assert [expr {"abc"}] eq abc
+}
+
+test {exponentiation} {
+    assert [expr {2**10}] == 1024
+    assert [expr {2**3**2}] == 512
+    assert [expr {2*3**2}] == 18
+    assert [expr {3**2*2}] == 18
+    assert [expr {2**-1}] == 0
+    assert [expr {-1**-3}] == -1
+    assert [expr {2.0**0.5}] == [expr {sqrt(2)}]
+    assert [catch { expr {0**-1} }] == 1
+    assert [lindex $::errorCode 1] == DOMAIN
+    assert [** 2 3 2] == 512
+    assert [expr {pow(2, 8)}] == 256
+}
+
+test {tcl::prefix match} {
+    assert [tcl::prefix match {apple banana cherry} ban] == banana
+    assert [tcl::prefix match {get getall} get] == get
+    assert [catch { tcl::prefix match {get getall set} ge } msg] == 1
+    assert $msg == {ambiguous option "ge": must be get, getall, or set}
+    assert [catch { tcl::prefix match -message fruit {apple banana} kiwi } msg] == 1
+    assert $msg == {bad fruit "kiwi": must be apple, or banana}
+    assert [catch { tcl::prefix match -exact {apple banana} app }] == 1
+    assert [tcl::prefix match -error {} {apple banana} kiwi] == {}
+    catch { tcl::prefix match -error {-errorcode {FRUIT NONE}} {apple} kiwi }
+    assert [lindex $::errorCode 0] == FRUIT
+}
+
+test {string case mapping} {
+    assert [string totitle "hELLO wORLD"] == "Hello world"
+    assert [string totitle "éCOLE"] == "École"
+    assert [string totitle "ǆungla"] == "ǅungla"
+    assert [string totitle "abCD" 2] == "abCD"
+    assert [string totitle "abcDEF" 3 end] == "abcDef"
+    assert [string toupper "ñandú"] == "ÑANDÚ"
+    assert [string tolower "ÀÉÎ" 1 end-1] == "ÀéÎ"
+    assert [catch { string totitle abc x }] == 1
+    assert [catch { string toupper } msg] == 1
+    assert $msg eq {wrong # args: should be "string toupper string ?first? ?last?"}
+    assert [catch { string tolower a 0 1 2 } msg] == 1
+    assert $msg eq {wrong # args: should be "string tolower string ?first? ?last?"}
+    assert [catch { string totitle } msg] == 1
+    assert $msg eq {wrong # args: should be "string totitle string ?first? ?last?"}
+}
+
+test {regexp} {
+    assert [regexp {b+} abbbc m] == 1
+    assert $m == bbb
+    assert [regexp {(\d+)-(\d+)} "from 10-20" all lo hi] == 1
+    assert $lo == 10
+    assert $hi == 20
+    assert [regexp -nocase {^HELLO} hello] == 1
+    assert [regexp {x} abc] == 0
+    assert [catch { regexp {(} abc }] == 1
+}
+
+test {regexp line modes} {
+    set log "INFO start\nERROR disk full\nINFO done"
+    assert [regexp {^ERROR (.*)$} $log] == 0
+    assert [regexp -line {^ERROR (.*)$} $log all what] == 1
+    assert $what == "disk full"
+    assert [regexp -lineanchor {^ERROR (.*)$} $log all what] == 1
+    assert $what == "disk full\nINFO done"
+    assert [regexp {start.ERROR} $log] == 1
+    assert [regexp -linestop {start.ERROR} $log] == 0
+}
+
+proc by_length {a b} {
+    return [expr {[string length $a] - [string length $b]}]
+}
+
+test {lsort} {
+    assert [lsort {pear apple fig}] == {apple fig pear}
+    assert [lsort -decreasing {pear apple fig}] == {pear fig apple}
+    assert [lsort -integer {10 9 100}] == {9 10 100}
+    assert [lsort {10 9 100}] == {10 100 9}
+    assert [lsort -unique {b a b c a}] == {a b c}
+    assert [lsort -index 1 {{x 3} {y 1} {z 2}}] == {{y 1} {z 2} {x 3}}
+    assert [lsort -integer -index end {{x 30} {y 4}}] == {{y 4} {x 30}}
+    assert [catch { lsort -integer {1 x} }] == 1
+    assert [catch { lsort -bogus {a} }] == 1
+}
+
+test {lsort -indices} {
+    set l {c a d b}
+    set order [lsort -indices $l]
+    assert $order eq {1 3 0 2}
+    set applied {}
+    foreach ind $order { lappend applied [lindex $l $ind] }
+    assert $applied eq [lsort $l]
+
+    set nums {10 9 100 1}
+    set order [lsort -indices -integer -decreasing $nums]
+    assert $order eq {2 0 1 3}
+    set applied {}
+    foreach ind $order { lappend applied [lindex $nums $ind] }
+    assert $applied eq [lsort -integer -decreasing $nums]
+
+    # Sorting a parallel list the same way
+    set names {carol al bob}
+    set ages {35 20 28}
+    set byage {}
+    foreach ind [lsort -indices -command {apply {{a b} { expr {$a - $b} }}} $ages] {
+        lappend byage [lindex $names $ind]
+    }
+    assert $byage eq {al bob carol}
+
+    assert [lsort -indices -unique {b a b}] eq {1 2}
+    assert [lsort -indices -stride 2 -index 1 {x 3 y 1 z 2}] eq {2 4 0}
+    assert [lsort -indices {}] eq {}
+}
+
+test {lsort -real} {
+    assert [lsort -real {1.5 2 0.25}] eq {0.25 1.5 2}
+    assert [lsort -real {10 9.5 1e1 -3 0.5}] eq {-3 0.5 9.5 10 1e1}
+    assert [lsort -real -decreasing -unique {2.0 2 1.5}] eq {2 1.5}
+    assert [lsort -real -index 1 {{a 2.5} {b 0.5}}] eq {{b 0.5} {a 2.5}}
+    assert [catch { lsort -real {1.5 abc} } msg] == 1
+    assert $msg eq {expected floating-point number but got "abc"}
+}
+
+test {lsort -command} {
+    assert [lsort -command by_length {ccc a bb}] == {a bb ccc}
+    assert [lsort -command by_length {bb aa c}] == {c bb aa}
+    assert [lsort -decreasing -command by_length {a ccc bb}] == {ccc bb a}
+    proc bad_cmp {a b} { error "no comparing $a" }
+    assert [catch { lsort -command bad_cmp {x y z} } msg] == 1
+    assert [string match "no comparing *" $msg] == 1
+    assert [catch { lsort -command {list} {x y} } msg] == 1
+    assert $msg eq {-command returned non-integer result}
+}
+
+test {lsort -stride} {
+    assert [lsort -stride 2 {b 2 a 3 c 1}] == {a 3 b 2 c 1}
+    assert [lsort -stride 2 -index 1 -integer {b 2 a 3 c 1}] == {c 1 b 2 a 3}
+    assert [lsort -stride 3 -index end -decreasing {x 1 p y 2 q z 3 r}] == {z 3 r y 2 q x 1 p}
+    assert [catch { lsort -stride 2 {a b c} } msg] == 1
+    assert $msg == "list size must be a multiple of the stride length"
+    assert [catch { lsort -stride 2 -index 2 {a b} }] == 1
+    assert [catch { lsort -stride 1 {a b} }] == 1
+}
+
+test {lsort -nocase} {
+    assert [lsort {b A c}] eq {A b c}
+    assert [lsort -ascii {b A c}] eq {A b c}
+    assert [lsort -nocase {b A c}] eq {A b c}
+    assert [lsort -nocase {banana Apple cherry}] eq {Apple banana cherry}
+    assert [lsort -nocase -decreasing {banana Apple cherry}] eq {cherry banana Apple}
+    assert [lsort -nocase -unique {b A a B c}] eq {a B c}
+    assert [lsort -unique {b A a B c}] eq {A B a b c}
+    assert [lsort -nocase -integer {10 9}] eq {9 10}
+}
+
+test {lsort -dictionary} {
+    assert [lsort -dictionary {file10 file9 File2 file1}] == {file1 File2 file9 file10}
+    assert [lsort -dictionary {v1.10 v1.9 V1.2}] == {V1.2 v1.9 v1.10}
+    assert [lsort -dictionary -decreasing {a10 a2 a1}] == {a10 a2 a1}
+}
+
+test {expr string operands} {
+    set x abc
+    assert [expr {"abc" eq $x}] == 1
+    assert [expr {$x ne "abc"}] == 0
+    assert [expr {{a b} eq "a b"}] == 1
+    assert [expr {abc eq abc}] == 1
+    assert [expr {1.0 == 1}] == 1
+    assert [expr {"1.0" eq 1}] == 0
+    assert [expr {"abc" < "abd"}] == 1
+    assert [expr {"10" < "9"}] == 0
+    assert [expr {"a" ne "b" && 2 == 2.0}] == 1
+    assert [expr {max(1, 2) == 2 && $x eq "abc"}] == 1
+    assert [expr {"x" eq "x" ? "yes" : "no"}] == yes
+    assert [tcl::mathop::eq a a a] == 1
+}
+
+test {expr precedence} {
+    assert [expr {1 + 2 * 3}] == 7
+    assert [expr {2 * 3 + 4 * 5 - 6}] == 20
+    assert [expr {10 - 4 - 3}] == 3
+    assert [expr {1 << 2 + 1}] == 8
+    assert [expr {1 < 2 && 3 < 4}] == 1
+    assert [expr {1 < 2 == 2 > 1}] == 1
+    assert [expr {0 && 1 || 1}] == 1
+    assert [expr {1 || 0 && 0}] == 1
+    assert [expr {1 + 1 == 2 ? 3 * 2 : 0}] == 6
+}
+
+proc tick {v} {
+    lappend ::ticks $v
+    return $v
+}
+
+test {expr evaluation order} {
+    set ::ticks {}
+    expr {[tick 1] + [tick 2] * [tick 3]}
+    assert $::ticks == {1 2 3}
+    set ::ticks {}
+    expr {[tick 0] && [tick 1]}
+    assert $::ticks == 0
+    set ::ticks {}
+    expr {[tick 1] || [tick 2]}
+    assert $::ticks == 1
+    set ::ticks {}
+    expr {[tick 1] < 2 && [tick 0] || [tick 5]}
+    assert $::ticks == {1 0 5}
+    set ::ticks {}
+    assert [expr {[tick 1] ? [tick 2] : [tick 3]}] == 2
+    assert $::ticks == {1 2}
+    set ::ticks {}
+    assert [expr {[tick 0] > 1 ? [tick 2] : [tick 3] + 1}] == 4
+    assert $::ticks == {0 3}
+}
+
+test {set and incr results} {
+    assert [set x 5] == 5
+    assert [set y [set x 3]] == 3
+    assert $x == 3
+    assert [set x] == 3
+    assert [incr x] == 4
+    assert [incr x 10] == 14
+    assert [incr x -20] == -6
+    assert [set arr(k) v] == v
+    assert [lappend l a b] == {a b}
+    unset -nocomplain fresh
+    assert [incr fresh] == 1
+    assert [incr fresh2 5] == 5
+    set s notanumber
+    assert [catch { incr s }] == 1
+}
+
+test {index forms} {
+    set l {a b c d e}
+    assert [lindex $l end] == e
+    assert [lindex $l end-1] == d
+    assert [lindex $l 1+1] == c
+    assert [lindex $l 10] == {}
+    assert [lindex $l -1] == {}
+    assert [lindex {{a b} {c d}} 1 0] == c
+    assert [lindex $l] == $l
+    assert [catch { lindex $l bogus }] == 1
+    assert [string index "héllo" 1] == é
+    assert [string index "hello" end-1] == l
+    assert [string index "hello" 9] == {}
+}
+
+test {lrange and string range} {
+    set l {a b c d e}
+    assert [lrange $l 1 3] == {b c d}
+    assert [lrange $l 0 end] == $l
+    assert [lrange $l -5 1] == {a b}
+    assert [lrange $l 3 100] == {d e}
+    assert [lrange $l 3 1] == {}
+    assert [lrange $l end-1 end] == {d e}
+    assert [string range "hello world" 6 end] == world
+    assert [string range "hello" -3 1] == he
+    assert [string range "hello" 2 99] == llo
+    assert [string range "hello" 3 2] == {}
+    assert [string range abcdef 1 end+9223372036854775807] eq bcdef
+    assert [string range abcdef end+9223372036854775807 1] eq {}
+    assert [string range abcdef end-9223372036854775807 1] eq ab
+    assert [string range abcdef 1 end+99999999999999999999] eq bcdef
+    assert [lrange {a b c} -9223372036854775807-5 0] eq a
+}
+
+test {linsert lreplace lset} {
+    set l {a b c}
+    assert [linsert $l 0 x] == {x a b c}
+    assert [linsert $l end x y] == {a b c x y}
+    assert [linsert $l end-1 x] == {a b x c}
+    assert [linsert $l 99 x] == {a b c x}
+    assert [linsert $l -3 x] == {x a b c}
+    assert [lreplace $l 1 1 X] == {a X c}
+    assert [lreplace $l 0 end] == {}
+    assert [lreplace $l 1 0 X] == {a X b c}
+    assert [lreplace $l end end Y Z] == {a b Y Z}
+    assert [lreplace $l 10 20 D] == {a b c D}
+    assert [lreplace $l 9223372036854775807+1 2 X] == {a b c X}
+    lset l 1 B
+    assert $l == {a B c}
+    lset l end C
+    assert $l == {a B C}
+    lset l end+1 D
+    assert $l == {a B C D}
+    set m {{1 2} {3 4}}
+    lset m 1 0 x
+    assert $m == {{1 2} {x 4}}
+    lset m {0 1} y
+    assert $m == {{1 y} {x 4}}
+    assert [catch { lset l 9 z }] == 1
+}
+
+test {lpop lremove} {
+    set s {a b c d}
+    assert [lpop s] == d
+    assert $s == {a b c}
+    assert [lpop s 0] == a
+    assert $s == {b c}
+    assert [lpop s end-1] == b
+    assert $s == c
+    assert [lpop s] == c
+    assert [llength $s] == 0
+    assert [catch { lpop s } msg] == 1
+    assert $msg == {index "end" out of range}
+    set m {{1 2} {3 4}}
+    assert [lpop m 1 0] == 3
+    assert $m == {{1 2} 4}
+    set q {x y}
+    assert [catch { lpop q 5 } msg] == 1
+    assert $msg == {index "5" out of range}
+    assert $q == {x y}
+    assert_err { lpop nosuchvar }
+    set l {a b c d e}
+    assert [lremove $l 1] == {a c d e}
+    assert [lremove $l 0 end] == {b c d}
+    assert [lremove $l end 0 end] == {b c d}
+    assert [lremove $l 9 -1] == $l
+    assert [lremove $l] == $l
+    assert_err { lremove $l x }
+}
+
+test {lseq} {
+    assert [lseq 5] == {0 1 2 3 4}
+    assert [lseq 0] == {}
+    assert [lseq 1 5] == {1 2 3 4 5}
+    assert [lseq 5 5] == 5
+    assert [lseq 0 100 25] == {0 25 50 75 100}
+    assert [lseq 1 4 2] == {1 3}
+    assert [lseq 3 1] == {3 2 1}
+    assert [lseq 10 0 -5] == {10 5 0}
+    assert [lseq 1 10 -1] == {}
+    assert [lseq 1 10 0] == {}
+    assert [lseq 1 to 10 by 3] == {1 4 7 10}
+    assert [lseq 5 .. 1 by -2] == {5 3 1}
+    assert [lseq 10 count 3] == {10 11 12}
+    assert [lseq 3 count 4 by -1] == {3 2 1 0}
+    assert [lseq 1 count 0] == {}
+    set sum 0
+    foreach n [lseq 1 10] {
+        incr sum $n
+    }
+    assert $sum == 55
+    assert_err { lseq }
+    assert_err { lseq a }
+    assert_err { lseq 1 5 by }
+    assert_err { lseq 1 2 3 4 }
+    assert [catch { lseq 0 9223372036854775807 } msg] == 1
+    assert $msg eq {max length of a Tcl list exceeded}
+    assert_err { lseq 100000000000000 }
+    assert_err { lseq 0 count 100000000000000 }
+    assert [lseq -9223372036854775807 9223372036854775807 4611686018427387904] eq {-9223372036854775807 -4611686018427387903 1 4611686018427387905}
+    assert [lseq 9223372036854775807 -9223372036854775807 -9223372036854775807] eq {9223372036854775807 0 -9223372036854775807}
+}
+
+test {format} {
+    assert [format "%d items" 3] == "3 items"
+    assert [format "%5d|%-5d|%05d" 42 42 42] == "   42|42   |00042"
+    assert [format "%+d %x %X %o" 5 255 255 8] == "+5 ff FF 10"
+    assert [format "%s=%s" a b] == "a=b"
+    assert [format "%.2f" 3.14159] == "3.14"
+    assert [format "%e" 1234.5] == "1.234500e+03"
+    assert [format "%c%c" 72 105] == "Hi"
+    assert [format "%*d" 4 7] == "   7"
+    assert [format "%.3s" abcdef] == "abc"
+    assert [format "100%%"] == "100%"
+    assert [format {%2$s %1$s} world hello] == "hello world"
+    assert [catch { format "%d" }] == 1
+    assert [catch { format "%q" 1 } msg] == 1
+    assert $msg == {bad field specifier "q"}
+    assert [catch { format "%d" abc }] == 1
+    assert [format %x -1] eq ffffffffffffffff
+    assert [format %X -255] eq FFFFFFFFFFFFFF01
+    assert [format %o -8] eq 1777777777777777777770
+    assert [format %u -1] eq 18446744073709551615
+    assert [format %d -1] eq -1
+}
+
+test {format %b and grouping} {
+    assert [format %b 5] == 101
+    assert [format %08b 5] == 00000101
+    assert [format %-6b| 5] == "101   |"
+    assert [format %#b 5] == 0b101
+    assert [format %b 0] == 0
+    assert [string length [format %b -1]] == 64
+    assert [format "%'d" 1000000] == "1,000,000"
+    assert [format "%'d" -1234567] == "-1,234,567"
+    assert [format "%'d" 999] == "999"
+    assert [format "%'10d|" 12345] == "    12,345|"
+    assert [format "%-'10d|" 12345] == "12,345    |"
+}
+
+test {numeric and string comparison} {
+    assert [expr {"10" == 10}] == 1
+    assert [expr {10 == 10.0}] == 1
+    assert [expr {"10" eq "10.0"}] == 0
+    assert [expr {"10" eq "10"}] == 1
+    assert [expr {" 10 " == 10}] == 1
+    assert [expr {0x10 == 16}] == 1
+    assert [expr {"1e1" == 10}] == 1
+    assert [expr {"abc" == "abc"}] == 1
+    assert [expr {"abc" != "abd"}] == 1
+    assert [expr {"10" == "10a"}] == 0
+    assert [expr {"a" < "b"}] == 1
+    assert [expr {"9" < "10"}] == 1
+    assert [expr {1.5 != 1.50}] == 0
+    assert [expr {"1.5" ne "1.50"}] == 1
+    assert [expr {-0 == 0}] == 1
+}
+
+proc whocalled {} {
+    return [info level -1]
+}
+
+proc outer {a b} {
+    return [list [info level] [info level 0] [whocalled]]
+}
+
+test {info level} {
+    # the test body itself runs in a proc
+    set ::base [info level]
+    assert [lindex [info level 0] 0] == test
+    set r [outer 1 {x y}]
+    assert [lindex $r 0] == [+ $::base 1]
+    assert [lindex $r 1] == {outer 1 {x y}}
+    assert [lindex $r 2] == {outer 1 {x y}}
+    assert [catch { info level 50 }] == 1
+    proc deep {n} {
+        if {$n == 0} { return [info level [+ $::base 1]] }
+        deep [- $n 1]
+    }
+    assert [deep 3] == {deep 3}
+}
+
+test {info frame} {
+    proc where {} {
+        return [info frame 0]
+    }
+    set f [where]
+    assert [lindex $f 0] == level
+    assert [lindex $f 1] == [+ [info level] 1]
+    assert [lindex $f 3] == where
+    assert [lindex $f 4] == file
+    assert [lindex $f 6] == line
+    assert [lindex $f 7] > 0
+}
+
+test {namespace names} {
+    assert [namespace qualifiers ::a::b::c] == ::a::b
+    assert [namespace qualifiers a::b] == a
+    assert [namespace qualifiers ::a] == {}
+    assert [namespace qualifiers plain] == {}
+    assert [namespace qualifiers a:::b] == a
+    assert [namespace tail ::a::b::c] == c
+    assert [namespace tail plain] == plain
+    assert [namespace tail a::] == {}
+    assert [namespace current] == ::
+    assert [namespace parent] == {}
+    assert [catch { namespace parent ::nope }] == 1
+}
+
+test {const} {
+    const limit 10
+    assert $limit == 10
+    assert [catch { set limit 11 } msg] == 1
+    assert $msg == "can't set: variable is a constant"
+    assert [catch { incr limit }] == 1
+    assert [catch { lappend limit x }] == 1
+    assert [catch { unset limit } msg] == 1
+    assert $msg == "can't unset: variable is a constant"
+    assert $limit == 10
+    const limit 20
+    assert $limit == 10
+    set plain 1
+    assert [catch { const plain 2 }] == 1
+    assert [catch { const arr(x) 2 }] == 1
+}
+
+
+test {string first and last} {
+    assert [string first ab xxabyyab] == 2
+    assert [string first ab xxabyyab 3] == 6
+    assert [string first ab xxabyyab end-1] == 6
+    assert [string first zz xxabyyab] == -1
+    assert [string first {} abc] == -1
+    assert [string first é aéaé 2] == 3
+    set found {}
+    set ind [string first a banana]
+    while {$ind >= 0} {
+        lappend found $ind
+        set ind [string first a banana [+ $ind 1]]
+    }
+    assert $found eq {1 3 5}
+    assert [string last ab xxabyyab] == 6
+    assert [string last ab xxabyyab 5] == 2
+    assert [string last ab xxabyyab 6] == 2
+    assert [string last ab xxabyyab 7] == 6
+    assert [string last ab xxabyyab 3] == 2
+    assert [string last ab xxabyyab 2] == -1
+    assert [string last ab xxabyyab 1] == -1
+    assert [string last a banana end] == 5
+    assert [string last ab xxab -9223372036854775808] == -1
+    assert [string first a abc 9223372036854775807] == -1
+    assert [string first a abc -9223372036854775808] == 0
+}
+
+test {string replace} {
+    assert [string replace abc 2 1 X] == abc
+    assert [string replace abc 5 9 X] == abc
+    assert [string replace abc -3 -1 X] == abc
+    assert [string replace abc 1 1 X] == aXc
+    assert [string replace abcdef 1 3] == aef
+    assert [string replace abcdef 0 end] == {}
+    assert [string replace abc 1 1 {}] == ac
+    assert [string replace abc -5 0 XY] == XYbc
+    assert [string replace abc 2 99 Z] == abZ
+    assert [string replace abc end end Z] == abZ
+    assert [string replace éaé 1 1 X] == éXé
+    assert_err { string replace abc x 1 }
+    assert_err { string replace abc 1 }
+}
+
+test {string is} {
+    foreach {class v want} {
+        integer 42 1       integer -7 1        integer 0x1f 1      integer 1.5 0
+        integer 9223372036854775807 1          integer 9223372036854775808 0
+        integer -9223372036854775808 1         integer -9223372036854775809 0
+        wideinteger 9223372036854775807 1      wideinteger 9223372036854775808 0
+        entier 9223372036854775808 1           entier -9223372036854775809 1
+        entier 0x8000000000000000 1            entier 123456789012345678901234567890 1
+        entier 0b101 1     entier 12a 0        entier 1.0 0        entier - 0
+        double 1.5 1       double 1e3 1        double abc 0        double 7 1
+        boolean yes 1      boolean OFF 1       boolean 2 1         boolean maybe 0
+        true on 1          true 0 0            false no 1          false 1 0
+        list {a {b c}} 1   list "a \{" 0
+        alpha abcé 1       alpha ab1 0         digit 0123 1        digit 1.0 0
+        alnum a1 1         alnum a-1 0         space " \t\n" 1     space " x" 0
+        upper ABC 1        upper AbC 0         lower abc 1         xdigit 0fA 1
+        xdigit 0g 0        wordchar a_1 1      wordchar a-b 0      ascii abc 1
+        ascii é 0          punct .,! 1
+    } {
+        assert [string is $class $v] == $want "string is $class $v"
+    }
+    assert [string is integer {}] == 1
+    assert [string is integer -strict {}] == 0
+    assert [string is entier -strict 10] == 1
+    assert_err { string is nosuchclass x }
+    assert_err { string is integer -bogus 1 }
+    assert_err { string is integer }
+}
+
+test {string compare and equal} {
+    assert [string compare abc abd] == -1
+    assert [string compare b a] == 1
+    assert [string compare abc abc] == 0
+    assert [string compare -nocase ABC abc] == 0
+    assert [string compare -length 3 abcdef abcxyz] == 0
+    assert [string compare -length 4 abcdef abcxyz] == -1
+    assert [string compare -length -1 abcdef abcxyz] == -1
+    assert [string compare -length 0 a b] == 0
+    assert [string compare -nocase -length 2 ABx abY] == 0
+    assert [string equal abc abc] == 1
+    assert [string equal ABC abc] == 0
+    assert [string equal -nocase ABC abc] == 1
+    assert [string equal -length 2 éaX éaY] == 1
+    assert [string equal -length 3 éaX éaY] == 0
+    assert [string equal -length 2 éé éè] == 0
+    assert [string equal -length 1 éé éè] == 1
+    assert [string equal -nocase -length 2 ÉAx éaY] == 1
+    assert [string compare -length 5 ab abc] == -1
+    # Integers compare by their strings
+    assert [string equal [expr {1 + 1}] [expr {4 - 2}]] == 1
+    assert [string equal [expr {1 + 1}] [expr {1 + 2}]] == 0
+    assert [string equal [expr {1 + 1}] 2] == 1
+    assert [string equal [expr {1 + 1}] 02] == 0
+    assert [string compare [expr {2 + 0}] [expr {10 + 0}]] == 1
+    assert [string compare [expr {5 * 2}] [expr {20 / 2}]] == 0
+    set x [expr {1 << 40}]
+    assert [string equal $x $x] == 1
+    assert_err { string compare a }
+    assert_err { string compare -bogus a b }
+    assert_err { string equal -length x a b }
+    assert_err { string equal -length 2 a }
+}
+
+test {generator} {
+    generator letters { foreach x {a b c} { yield $x } }
+    assert [letters] == a
+    assert [letters] == b
+    assert [letters] == c
+    assert [catch letters] == 3
+    assert [has_command letters] == 0
+
+    proc tens {n} { yield [* $n 10] }
+    generator nums { foreach n {1 2 3} { tens $n } }
+    set got {}
+    while 1 {
+        lappend got [nums]
+    }
+    assert $got == {10 20 30}
+
+    generator failing { yield 1; error boom }
+    assert [failing] == 1
+    assert [catch failing msg] == 1
+    assert $msg == boom
+    assert [has_command failing] == 0
+
+    generator closer {
+        catch { yield 1 } msg
+        set ::closemsg $msg
+        yield 2
+    }
+    assert [closer] == 1
+    closer close
+    assert $::closemsg == "generator closed"
+    assert [has_command closer] == 0
+
+    generator never { yield 1 }
+    never close
+    assert [has_command never] == 0
+
+    generator self { self }
+    assert [catch self msg] == 1
+    assert $msg == {generator "self" is already running}
+    assert_err { yield 1 }
+    assert_err { generator g }
+}
+
+test {proc arg count errors} {
+    proc two {a b} { list $a $b }
+    assert [catch { two 1 } msg] == 1
+    assert $msg == {wrong # args: should be "two a b"}
+    assert [catch { two 1 2 3 } msg] == 1
+    assert $msg == {wrong # args: should be "two a b"}
+    proc opt {a {b 2} args} { list $a $b $args }
+    assert [catch { opt } msg] == 1
+    assert $msg == {wrong # args: should be "opt a ?b? ?arg ...?"}
+    assert [opt 1 2 3 4] == {1 2 {3 4}}
+    proc none {} { return ok }
+    assert [catch { none x } msg] == 1
+    assert $msg == {wrong # args: should be "none"}
+    proc tcl::mathfunc::twice {x} { * $x 2 }
+    assert [catch { expr {twice(1, 2)} } msg] == 1
+    assert $msg == {wrong # args: should be "tcl::mathfunc::twice x"}
+    proc all args { llength $args }
+    assert [all] == 0
+    assert [all a b {c d}] == 3
+    proc rest args { return $args }
+    assert [rest a {b c}] == {a {b c}}
+}
+
+test {csv} {
+    assert [csv split {a,"b,c",d}] eq {a b,c d}
+    assert [llength [csv split {a,"say ""hi""",}]] == 3
+    assert [lindex [csv split {a,"say ""hi""",}] 1] eq {say "hi"}
+    assert [lindex [csv split "\"two\nlines\",x"] 0] eq "two\nlines"
+    assert [csv split {a;b,c} -sep {;}] eq {a b,c}
+    assert [csv split {}] eq {}
+    assert [csv join {}] eq {}
+    assert [csv join {{}}] eq {""}
+    assert [llength [csv split [csv join {{}}]]] == 1
+    assert [llength [csv split [csv join {{} {}}]]] == 2
+    assert [llength [csv split [csv join {}]]] == 0
+    assert [csv join {a b,c {say "hi"}}] eq {a,"b,c","say ""hi"""}
+    assert [csv join [list "two\nlines" x]] eq "\"two\nlines\",x"
+    assert [csv join {a b;c} -sep {;}] eq {a;"b;c"}
+    set fields [list plain "with, comma" {with "quote"} "with\nnewline" {}]
+    assert [csv split [csv join $fields]] eq $fields
+    assert_err { csv split {a,"b} }
+    assert_err { csv join {a b} -sep {""} }
+}
+
+test {binary encode and decode} {
+    assert [binary encode base64 hello] eq aGVsbG8=
+    assert [binary decode base64 aGVsbG8=] eq hello
+    assert [binary decode base64 aGVsbG8] eq hello
+    assert [binary encode hex hi] eq 6869
+    assert [binary decode hex "68 69"] eq hi
+    set long [format %060d 0]
+    set wrapped [binary encode base64 -maxlen 20 $long]
+    assert [llength [split $wrapped \n]] == 4
+    assert [string length [lindex [split $wrapped \n] 0]] == 20
+    assert [binary decode base64 $wrapped] eq $long
+    assert [binary encode base64 -maxlen 4 -wrapchar | abcdef] eq YWJj|ZGVm
+    assert_err { binary decode hex 6 }
+    assert_err { binary decode base64 a*b }
+    assert_err { binary encode base64 -maxlen -1 x }
+    assert_err { binary encode base64 -bogus 1 x }
+}
+
+test {binary scan} {
+    set rec "[format %c 0][format %c 5]abcd"
+    assert [binary scan $rec Sa4 n tag] == 2
+    assert $n == 5
+    assert $tag eq abcd
+    assert [binary scan [binary decode hex ff01] cuc neg pos] == 2
+    assert $neg == 255
+    assert $pos == 1
+    assert [binary scan [binary decode hex ff] c b] == 1
+    assert $b == -1
+    assert [binary scan [binary decode hex 0100000000000002] iI lo hi] == 2
+    assert $lo == 1
+    assert $hi == 2
+    assert [binary scan "abc  " A* s] == 1
+    assert $s eq abc
+    assert [binary scan hi H* h] == 1
+    assert $h eq 6869
+    assert [binary scan hi h3 h] == 1
+    assert $h eq 869
+    assert [binary scan [binary decode hex 010203] c* l] == 1
+    assert $l eq {1 2 3}
+
+    # Moving around the string
+    assert [binary scan abcdef x2a1X2a1@5a* c a f] == 3
+    assert $c eq c
+    assert $a eq b
+    assert $f eq f
+    assert [binary scan abc "x9 X* a1" first] == 1
+    assert $first eq a
+
+    # A field that doesn't fit ends the scan
+    assert [binary scan ab a1a2 x late] == 1
+    assert [info exists late] == 0
+    assert [binary scan abcdefgh w2305843009213693953 huge] == 0
+    assert [binary scan abcdefgh H9223372036854775807 huge] == 0
+    assert [info exists huge] == 0
+
+    # Successive fixed-size records
+    set data "[format %c 1]one[format %c 2]two[format %c 3]six"
+    set got {}
+    while {[binary scan -cursor used $data ca3 id name] == 2} {
+        lappend got $id $name
+        set data [string range $data $used end]
+    }
+    assert $got eq {1 one 2 two 3 six}
+    assert $data eq ""
+    set data abcdefgh
+    set got {}
+    set at 0
+    while {[binary scan -cursor at $data "@$at a3" rec] == 1} {
+        lappend got $rec
+    }
+    assert $got eq {abc def}
+    assert $at == 6
+
+    assert_err { binary scan abc q v }
+    assert_err { binary scan abc a1a1 v }
+    assert_err { binary scan abc a1 v w }
+    assert_err { binary scan abc @ }
+}
+
+test {dict} {
+    set d [dict create a 1 b {x 10 y 20} a 3]
+    assert $d eq {a 3 b {x 10 y 20}}
+    assert [dict get $d a] == 3
+    assert [dict get $d b y] == 20
+    assert [dict size $d] == 2
+    assert [dict keys $d] eq {a b}
+    assert_err { dict get $d c }
+    assert_err { dict get {a 1 b} a }
+    assert [dict exists $d b x] == 1
+    assert [dict exists $d b z] == 0
+    assert [dict exists $d a x] == 0
+    dict set d b z 30
+    dict set d c 4
+    assert $d eq {a 3 b {x 10 y 20 z 30} c 4}
+    set pairs {}
+    dict for {k v} $d { lappend pairs $k }
+    assert $pairs eq {a b c}
+}
+
+test {dict getdef} {
+    set d {a 1 b {x 10}}
+    assert [dict getdef $d a 0] == 1
+    assert [dict getdef $d c 0] == 0
+    assert [dict getdef $d b x 0] == 10
+    assert [dict getdef $d b y none] eq none
+    assert [dict getdef $d q r s none] eq none
+    assert [dict getwithdefault $d b x 0] == 10
+    assert_err { dict getdef $d 0 }
+}
+
+test {dict sort} {
+    set d {pear 10 apple 9 fig 100 Banana 9}
+    assert [dict sort $d] eq {Banana 9 apple 9 fig 100 pear 10}
+    assert [dict sort $d -nocase] eq {apple 9 Banana 9 fig 100 pear 10}
+    assert [dict sort $d -decreasing] eq {pear 10 fig 100 apple 9 Banana 9}
+    assert [dict sort $d -value] eq {pear 10 fig 100 apple 9 Banana 9}
+    assert [dict sort $d -value -integer] eq {apple 9 Banana 9 pear 10 fig 100}
+    assert [dict sort $d -value -integer -decreasing] eq {fig 100 pear 10 apple 9 Banana 9}
+    assert [dict sort {a9 x a10 y} -dictionary] eq {a9 x a10 y}
+    assert [dict sort {b 1 a 2 b 3}] eq {a 2 b 3}
+    assert [dict sort {}] eq {}
+    assert [dict sort {a 2.5 b 10 c 1e1} -value -real] eq {a 2.5 b 10 c 1e1}
+    proc byLen {a b} { expr {[string length $a] - [string length $b]} }
+    assert [dict sort {ccc 1 a 2 bb 3} -command byLen] eq {a 2 bb 3 ccc 1}
+    assert [catch { dict sort {a 1} -indices } msg] == 1
+    assert $msg eq {bad option "-indices": must be -ascii, -command, -decreasing, -dictionary, -increasing, -integer, -key, -nocase, -real, or -value}
+    assert_err { dict sort {a 1} -command }
+    set keys {}
+    dict for {k v} [dict sort $d -value -integer] {
+        lappend keys $k
+    }
+    assert $keys eq {apple Banana pear fig}
+    assert_err { dict sort $d -bogus }
+    assert_err { dict sort $d -value -integer; dict sort {a x b y} -value -integer }
+    assert_err { dict sort {a b c} }
+}
+
+test {dict unset} {
+    set cfg [dict create a 1 b [dict create c 2 d [dict create e 3 f 4 g 5]] h 6]
+    set orig $cfg
+    assert [dict unset cfg b d f] eq {a 1 b {c 2 d {e 3 g 5}} h 6}
+    assert $cfg eq {a 1 b {c 2 d {e 3 g 5}} h 6}
+    assert [dict get $cfg b c] == 2
+    assert [dict get $cfg h] == 6
+    assert [dict get $orig b d f] == 4
+    dict unset cfg b d nosuch
+    assert $cfg eq {a 1 b {c 2 d {e 3 g 5}} h 6}
+    assert [catch { dict unset cfg b x y } msg] == 1
+    assert $msg eq {key "x" not known in dictionary}
+    assert $cfg eq {a 1 b {c 2 d {e 3 g 5}} h 6}
+    dict unset cfg a
+    assert [dict keys $cfg] eq {b h}
+    dict unset fresh k
+    assert $fresh eq {}
+    assert_err { dict unset cfg }
+}
+
+test {dict with} {
+    set rec {name bob age 41 addr {city paris zip 75001}}
+    dict with rec {
+        incr age
+        unset name
+    }
+    assert $rec eq {age 42 addr {city paris zip 75001}}
+    assert [dict with rec addr { set city lyon; string length $zip }] == 5
+    assert [dict get $rec addr city] eq lyon
+    assert [catch { dict with rec { set age 0; error oops } } msg] == 1
+    assert $msg eq oops
+    assert [dict get $rec age] == 0
+    assert_err { dict with rec nosuch { } }
+    dict with rec { unset rec }
+    assert [info exists rec] == 0
+}
 
 proc fib {n} {
     if { $n < 2 } {
@@ -0,0 +1,251 @@
+package gotcl
+
+import (
+	"errors"
+	"strings"
+)
+
+// trace add variable name ops command
+// trace remove variable name ops command
+// trace info variable name
+//
+// Runs command when the variable is read, written or unset, with the
+// variable name, the element name (empty for a scalar) and the
+// operation appended. On an array, traces fire for each element
+// accessed, so a read trace can compute elements on demand. Errors
+// from read and write traces make the access fail; errors from unset
+// traces are ignored. Traces don't fire while one of the variable's
+// traces is running, and are dropped when the variable is unset.
+//
+// trace add command name ops command
+// trace remove command name ops command
+// trace info command name
+//
+// Runs command when the command name is renamed or deleted, with the
+// old name, the new name (empty for a deletion) and the operation
+// appended. The traces follow a command when it's renamed, and are
+// dropped once it's deleted. Replacing a command with another of the
+// same name keeps them. Errors from command traces are ignored.
+
+type traceOp int
+
+const (
+	traceRead traceOp = 1 << iota
+	traceWrite
+	traceUnset
+)
+
+var traceOpNames = []string{"read", "write", "unset"}
+
+const (
+	traceRename traceOp = 1 << iota
+	traceDelete
+)
+
+var cmdTraceOpNames = []string{"rename", "delete"}
+
+func (op traceOp) String() string {
+	return op.name(traceOpNames)
+}
+
+// name returns the name of op, which is a single operation, from
+// names, which has a name per bit.
+func (op traceOp) name(names []string) string {
+	for b, n := range names {
+		if op == 1<<uint(b) {
+			return n
+		}
+	}
+	return ""
+}
+
+type tclTrace struct {
+	ops traceOp
+	cmd *TclObj
+}
+
+// fireTraces runs the traces on v for op. name and elem are passed to
+// the trace commands as the variable and element names.
+func (i *Interp) fireTraces(v *varEntry, name, elem string, op traceOp) error {
+	if len(v.traces) == 0 || v.tracing {
+		return nil
+	}
+	v.tracing = true
+	defer func() { v.tracing = false }()
+	for _, t := range v.traces {
+		if t.ops&op == 0 {
+			continue
+		}
+		words, e := t.cmd.AsList()
+		if e != nil {
+			return e
+		}
+		args := make([]*TclObj, 0, len(words)+3)
+		args = append(append(args, words...), FromStr(name), FromStr(elem), FromStr(op.String()))
+		if rc := i.invoke(args); rc == kTclErr {
+			return i.err
+		}
+	}
+	return nil
+}
+
+// fireCmdTraces runs the traces in ts for op on a command renamed
+// from oldName to newName. Their errors are ignored, and the result
+// and error in flight are kept, unless one exits, which is returned.
+func (i *Interp) fireCmdTraces(ts []*tclTrace, oldName, newName string, op traceOp) error {
+	retval, err := i.retval, i.err
+	for _, t := range ts {
+		if t.ops&op == 0 {
+			continue
+		}
+		words, e := t.cmd.AsList()
+		if e != nil {
+			continue
+		}
+		args := make([]*TclObj, 0, len(words)+3)
+		args = append(append(args, words...), FromStr(oldName), FromStr(newName), FromStr(op.name(cmdTraceOpNames)))
+		if rc := i.invoke(args); rc == kTclErr && isExit(i.err) {
+			return i.err
+		}
+	}
+	i.retval, i.err = retval, err
+	return nil
+}
+
+func parseTraceOps(o *TclObj, names []string) (traceOp, error) {
+	l, e := o.AsList()
+	if e != nil {
+		return 0, e
+	}
+	var ops traceOp
+	for _, w := range l {
+		found := false
+		for ind, n := range names {
+			if w.AsString() == n {
+				ops |= 1 << uint(ind)
+				found = true
+			}
+		}
+		if !found {
+			return 0, errors.New("bad operation \"" + w.AsString() + "\": must be " + strings.Join(names, ", "))
+		}
+	}
+	if ops == 0 {
+		return 0, errors.New("bad operation list \"\": must be one or more of " + strings.Join(names, ", "))
+	}
+	return ops, nil
+}
+
+// tracedVar finds the variable a trace subcommand names, following
+// links.
+func (i *Interp) tracedVar(o *TclObj) (*varEntry, error) {
+	vr := o.asVarRef()
+	if vr.arrind != nil {
+		return nil, errors.New("can't trace \"" + o.AsString() + "\": trace the whole array instead")
+	}
+	v := resolveLink(i.getVarMap(vr.is_global)[vr.name])
+	if v == nil {
+		return nil, errors.New("can't trace \"" + o.AsString() + "\": no such variable")
+	}
+	return v, nil
+}
+
+// traceList returns the traces of the variable or command a trace
+// subcommand names, and the names of their operations. set stores a
+// changed list back.
+func (i *Interp) traceList(kind, name *TclObj) (ts []*tclTrace, names []string, set func([]*tclTrace), err error) {
+	switch kind.AsString() {
+	case "variable":
+		v, e := i.tracedVar(name)
+		if e != nil {
+			return nil, nil, nil, e
+		}
+		return v.traces, traceOpNames, func(ts []*tclTrace) { v.traces = ts }, nil
+	case "command":
+		n := name.AsString()
+		if _, ok := i.cmds[n]; !ok {
+			return nil, nil, nil, errors.New("unknown command \"" + n + "\"")
+		}
+		return i.cmdTraces[n], cmdTraceOpNames, func(ts []*tclTrace) {
+			if len(ts) == 0 {
+				delete(i.cmdTraces, n)
+			} else {
+				i.cmdTraces[n] = ts
+			}
+		}, nil
+	}
+	return nil, nil, nil, errors.New("bad option \"" + kind.AsString() + "\": must be command or variable")
+}
+
+func traceAdd(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 4 {
+		return i.FailStr("wrong # args: should be \"trace add type name opList command\"")
+	}
+	ts, names, set, e := i.traceList(args[0], args[1])
+	if e != nil {
+		return i.Fail(e)
+	}
+	ops, e := parseTraceOps(args[2], names)
+	if e != nil {
+		return i.Fail(e)
+	}
+	if _, e := args[3].AsList(); e != nil {
+		return i.Fail(e)
+	}
+	set(append(ts, &tclTrace{ops, args[3]}))
+	return i.Return(kNil)
+}
+
+func traceRemove(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 4 {
+		return i.FailStr("wrong # args: should be \"trace remove type name opList command\"")
+	}
+	ts, names, set, e := i.traceList(args[0], args[1])
+	if e != nil {
+		return i.Fail(e)
+	}
+	ops, e := parseTraceOps(args[2], names)
+	if e != nil {
+		return i.Fail(e)
+	}
+	cmd := args[3].AsString()
+	for ind, t := range ts {
+		if t.ops == ops && t.cmd.AsString() == cmd {
+			set(append(ts[:ind:ind], ts[ind+1:]...))
+			break
+		}
+	}
+	return i.Return(kNil)
+}
+
+// traceInfo returns a list with an {opList command} pair per trace.
+func traceInfo(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 2 {
+		return i.FailStr("wrong # args: should be \"trace info type name\"")
+	}
+	ts, names, _, e := i.traceList(args[0], args[1])
+	if e != nil {
+		return i.Fail(e)
+	}
+	res := make([]*TclObj, len(ts))
+	for ind, t := range ts {
+		var ops []string
+		for b, n := range names {
+			if t.ops&(1<<uint(b)) != 0 {
+				ops = append(ops, n)
+			}
+		}
+		res[ind] = fromList([]*TclObj{FromList(ops), t.cmd})
+	}
+	return i.Return(fromList(res))
+}
+
+var traceEn = ensembleSpec{
+	"add":    traceAdd,
+	"remove": traceRemove,
+	"info":   traceInfo,
+}
+
+func init() {
+	RegisterDefaultCmd("trace", traceEn.makeCmd())
+}
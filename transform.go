@@ -0,0 +1,163 @@
+package gotcl
+
+import (
+	"errors"
+)
+
+// chan push channelId cmdPrefix
+// chan pop channelId
+//
+// chan push stacks a transform on a channel, so that what's written
+// to it passes through cmdPrefix before going out. The command is
+// called with a method name, the channel name and sometimes data:
+//
+//	cmdPrefix initialize channelId mode
+//	    Returns the list of methods the transform supports, which
+//	    must include write. mode is always "write", since only
+//	    writing is transformed for now.
+//	cmdPrefix write channelId data
+//	    Returns what to write in place of data. It may return less,
+//	    or nothing, and keep the rest until later.
+//	cmdPrefix flush channelId
+//	    Optional. Returns any data held back, to be written before
+//	    the channel is flushed, popped or closed.
+//	cmdPrefix finalize channelId
+//	    Optional. Called when the transform is popped or the channel
+//	    is closed.
+//
+// Transforms pushed later see the data first. An error from the
+// command becomes the error of the puts, flush, chan pop or close that
+// called it. A failed write writes nothing, and the transform stays in
+// place; a transform is always removed by chan pop or close, even if
+// its flush or finalize fails. While any of a channel's transforms is
+// running, the channel can't be closed and its transforms can't be
+// popped, since that would pull them out from under the running one.
+
+type chanTransform struct {
+	i       *Interp
+	ch      *tclChan
+	cmd     []*TclObj
+	chname  string
+	methods map[string]bool
+	busy    bool // set while cmd runs, so it can't write to its own channel
+}
+
+func (t *chanTransform) call(method string, data ...*TclObj) (*TclObj, error) {
+	if t.busy {
+		return nil, errors.New("can't use channel \"" + t.chname + "\" from its own transform")
+	}
+	words := make([]*TclObj, 0, len(t.cmd)+2+len(data))
+	words = append(append(words, t.cmd...), FromStr(method), FromStr(t.chname))
+	words = append(words, data...)
+	t.busy = true
+	t.ch.transforming++
+	rc := t.i.invoke(words)
+	t.ch.transforming--
+	t.busy = false
+	if rc == kTclErr {
+		return nil, t.i.err
+	}
+	return t.i.retval, nil
+}
+
+// transformDown passes s through the transforms below the one at
+// index top, returning what reaches the channel itself.
+func (ch *tclChan) transformDown(s string, top int) (string, error) {
+	for k := top - 1; k >= 0; k-- {
+		r, e := ch.transforms[k].call("write", FromStr(s))
+		if e != nil {
+			return "", e
+		}
+		s = r.AsString()
+	}
+	return s, nil
+}
+
+// flushTransform writes out whatever the transform at index k has
+// held back.
+func (ch *tclChan) flushTransform(k int) error {
+	t := ch.transforms[k]
+	if !t.methods["flush"] {
+		return nil
+	}
+	r, e := t.call("flush")
+	if e != nil {
+		return e
+	}
+	s, e := ch.transformDown(r.AsString(), k)
+	if e != nil {
+		return e
+	}
+	return ch.writeRaw(s)
+}
+
+// pop flushes and removes the topmost transform.
+func (ch *tclChan) pop() error {
+	k := len(ch.transforms) - 1
+	e := ch.flushTransform(k)
+	if t := ch.transforms[k]; t.methods["finalize"] {
+		if _, fe := t.call("finalize"); e == nil {
+			e = fe
+		}
+	}
+	ch.transforms = ch.transforms[:k]
+	return e
+}
+
+func chanPush(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 2 {
+		return i.FailStr("wrong # args: should be \"chan push channelId cmdPrefix\"")
+	}
+	chname := args[0].AsString()
+	ch, e := i.getChan(chname)
+	if e != nil {
+		return i.Fail(e)
+	}
+	if ch.w == nil {
+		return i.FailStr("channel wasn't opened for writing")
+	}
+	cmd, e := args[1].AsList()
+	if e != nil {
+		return i.Fail(e)
+	}
+	if len(cmd) == 0 {
+		return i.FailStr("empty transform command")
+	}
+	t := &chanTransform{i: i, ch: ch, cmd: cmd, chname: chname, methods: make(map[string]bool)}
+	r, e := t.call("initialize", FromStr("write"))
+	if e != nil {
+		return i.Fail(e)
+	}
+	methods, e := r.AsList()
+	if e != nil {
+		return i.Fail(e)
+	}
+	for _, m := range methods {
+		t.methods[m.AsString()] = true
+	}
+	if !t.methods["write"] {
+		return i.FailStr("transform \"" + args[1].AsString() + "\" doesn't support the write method")
+	}
+	ch.transforms = append(ch.transforms, t)
+	return i.Return(args[0])
+}
+
+func chanPop(i *Interp, args []*TclObj) TclStatus {
+	if len(args) != 1 {
+		return i.FailStr("wrong # args: should be \"chan pop channelId\"")
+	}
+	ch, e := i.getChan(args[0].AsString())
+	if e != nil {
+		return i.Fail(e)
+	}
+	if len(ch.transforms) == 0 {
+		return i.FailStr("no transformation on channel \"" + args[0].AsString() + "\"")
+	}
+	if ch.transforming > 0 {
+		return i.FailStr("can't pop a transform from channel \"" + args[0].AsString() + "\" while its transforms are running")
+	}
+	if e := ch.pop(); e != nil {
+		return i.Fail(e)
+	}
+	return i.Return(kNil)
+}